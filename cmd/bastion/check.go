@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/content/watcher"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+// splitErrors flattens an error created with errors.Join into its parts.
+func splitErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, splitErrors(err)...)
+	}
+	return errs
+}
+
+// location formats where in a file an error was found, in the form
+// file:line when the line is known.
+func location(file string, err error) (string, error) {
+	var lineErr content.LineError
+	if errors.As(err, &lineErr) && lineErr.Line > 0 {
+		return fmt.Sprintf("%s:%d", file, lineErr.Line), lineErr.Err
+	}
+	return file, err
+}
+
+// runCheck generates an article from every document of a site and reports
+// every problem found. The exit status is non-zero if there were any. Warnings
+// are reported, but don't affect the exit status.
+func runCheck(args []string) int {
+	fs := newFlagSet("check", "[site-dir]")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+
+	siteDir := "."
+	if fs.NArg() == 1 {
+		siteDir = fs.Arg(0)
+	}
+
+	store := &watcher.Watcher{
+		Path:   path.Join(siteDir, "content"),
+		Logger: log.NewNop(),
+	}
+	if err := store.Load(); err != nil {
+		return failf("couldn't load content: %v", err)
+	}
+
+	articles := store.Articles()
+
+	problems := 0
+	for _, article := range articles {
+		file := filepath.Join(store.Path, filepath.FromSlash(article.Path))
+		for _, err := range splitErrors(article.Err) {
+			loc, err := location(file, err)
+			fmt.Printf("%s: %v\n", loc, err)
+			problems++
+		}
+		for _, err := range splitErrors(article.Warnings) {
+			loc, err := location(file, err)
+			fmt.Printf("%s: warning: %v\n", loc, err)
+		}
+	}
+
+	for _, err := range content.RouteConflicts(articles) {
+		fmt.Printf("%s: %v\n", store.Path, err)
+		problems++
+	}
+
+	// Sites without a config.json have no redirects to check.
+	config, err := loadConfig(siteDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return failf("couldn't load config: %v", err)
+	}
+	configPath := path.Join(siteDir, "config.json")
+	redirects, err := loadRedirects(config)
+	if err != nil {
+		fmt.Printf("%s: %v\n", configPath, err)
+		problems++
+	}
+	for _, err := range content.RedirectConflicts(redirects, articles) {
+		fmt.Printf("%s: %v\n", configPath, err)
+		problems++
+	}
+
+	if problems > 0 {
+		return failf("found %d problems in %d documents", problems, len(articles))
+	}
+
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+// captureStdout returns everything written to stdout while fn runs, along
+// with what fn returns.
+func captureStdout(t *testing.T, fn func() int) (string, int) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+
+	status := fn()
+	w.Close()
+
+	return <-output, status
+}
+
+func TestCheck(t *testing.T) {
+	got, status := captureStdout(t, func() int {
+		return runCheck([]string{"testdata/check"})
+	})
+
+	if want := 1; status != want {
+		t.Errorf("got exit status %d, want %d", status, want)
+	}
+
+	want := strings.Join([]string{
+		`testdata/check/content/date.md:2: couldn't parse 'created': parsing time "the first of January" as "2006-01-02": cannot parse "the first of January" as "2006"`,
+		`testdata/check/content/format.md:2: unknown format "unknown", expected one of html, markdown, text`,
+		`testdata/check/content/pinned.md:2: article property 'Pinned' must be true or false`,
+		`testdata/check/content/pinned.md:3: article property 'Unlisted' must be true or false`,
+		`testdata/check/content/username.md:2: article property 'Username' requires a 'PasswordHash' or 'Password'`,
+		`testdata/check/content: route /second is used by each of /first.md (alias), /second.md`,
+		`testdata/check/config.json: route /blog/post of /blog/post.md is redirected by the redirect from /blog`,
+	}, "\n") + "\n"
+	if got != want {
+		t.Errorf("output doesn't match:\n%s", tests.Diff(want, got))
+	}
+}
+
+func TestCheckValid(t *testing.T) {
+	got, status := captureStdout(t, func() int {
+		return runCheck([]string{"testdata/export"})
+	})
+
+	if want := 0; status != want {
+		t.Errorf("got exit status %d, want %d", status, want)
+	}
+	if got != "" {
+		t.Errorf("got output %q, want none", got)
+	}
+}
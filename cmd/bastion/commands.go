@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand of bastion. The run function receives the arguments
+// that follow the name of the command and returns the exit status.
+type command struct {
+	summary string
+	run     func(args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"check":         {"Validate every document of a site and report all problems", runCheck},
+		"export":        {"Render a site into a directory of static files", runExport},
+		"hash-password": {"Hash a password for the PasswordHash property of a document", runHashPassword},
+		"keys":          {"Rotate the keys used to sign authentication tokens", runKeys},
+		"token":         {"Create, list or revoke the API tokens of users", runToken},
+		"user":          {"Add, remove or change the password or second factor of users who can log in", runUser},
+	}
+}
+
+// commandNames returns the name of every command in sorted order.
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// newFlagSet creates the flag set for a command, with a usage message
+// describing its positional arguments. The name may include a subcommand
+// following the name of the command.
+func newFlagSet(name, positional string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n\n", os.Args[0], name, positional)
+		fmt.Fprintf(fs.Output(), "%s.\n", commands[strings.Fields(name)[0]].summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// warnf prints a warning for the user of a command.
+func warnf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
+}
+
+// failf prints an error for the user of a command and returns a failing exit
+// status.
+func failf(format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+	return 1
+}
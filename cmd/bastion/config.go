@@ -1,8 +1,12 @@
 package main
 
 import (
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/toddgaunt/bastion/internal/content"
 	"github.com/toddgaunt/bastion/internal/errors"
 )
 
@@ -17,11 +21,19 @@ var defaultConfig = configServer{
 			Location: "env",
 			Value:    "BASTION_PASSWORD",
 		},
+		Keyring: configVariable{
+			Location: "file",
+			Value:    "keyring.json",
+		},
+		RefreshTokens: "refresh-tokens.json",
+		APITokens:     "api-tokens.json",
+		Sessions:      "sessions.json",
 	},
 	Content: configContent{
 		Name:         "Example",
 		Description:  "This is a simple example website",
 		Style:        "default",
+		URL:          "",
 		ScanInterval: 60,
 	},
 	Network: configNetwork{
@@ -39,12 +51,77 @@ type configServer struct {
 	Authentication configAuthentication `json:"authentication"`
 	Content        configContent        `json:"content"`
 	Network        configNetwork        `json:"network"`
+	// Redirects send requests for routes, or for every route under them, to
+	// other locations.
+	Redirects []configRedirect `json:"redirects,omitempty"`
 }
 
 type configAuthentication struct {
 	Disabled bool           `json:"disabled"`
 	Username configVariable `json:"username"`
 	Password configVariable `json:"password"`
+	// Keyring holds the keys used to sign tokens. If it isn't set, a new key
+	// is generated each time the server starts.
+	Keyring configVariable `json:"keyring"`
+	// RefreshTokens is the file refresh tokens are kept in. If it isn't set,
+	// refresh tokens are only kept in memory.
+	RefreshTokens string `json:"refresh_tokens"`
+	// APITokens is the file API tokens are kept in. If it isn't set, API
+	// tokens are only kept in memory.
+	APITokens string `json:"api_tokens"`
+	// Sessions is the file the sessions of users logged in from a browser
+	// are kept in. If it isn't set, sessions are only kept in memory.
+	Sessions string `json:"sessions"`
+	// Users is the file listing the users that can log in. If it isn't set,
+	// only the user with the configured username and password can log in.
+	Users string `json:"users"`
+	// OIDC logs users in with the single sign-on of an OpenID Connect
+	// provider, alongside the username and password. It is disabled if it
+	// isn't set.
+	OIDC *configOIDC `json:"oidc,omitempty"`
+}
+
+type configOIDC struct {
+	Issuer       string         `json:"issuer"`
+	ClientID     string         `json:"client_id"`
+	ClientSecret configVariable `json:"client_secret"`
+	// RedirectURL is where the provider sends users back to, which is
+	// /.auth/oidc/callback of the URL of the site by default.
+	RedirectURL string `json:"redirect_url"`
+	// Scopes are requested along with openid, which are email and profile
+	// by default.
+	Scopes []string `json:"scopes,omitempty"`
+	// UsernameClaim and GroupsClaim name the claims of the ID token that
+	// users are known by, and that list their groups.
+	UsernameClaim string `json:"username_claim"`
+	GroupsClaim   string `json:"groups_claim"`
+	// Roles are the roles given to the users in each group of the provider.
+	Roles map[string][]string `json:"roles"`
+}
+
+type configRedirect struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Prefix redirects every route under From as well, with the rest of the
+	// route appended to To.
+	Prefix bool `json:"prefix"`
+	// Status is 301 by default.
+	Status int `json:"status,omitempty"`
+}
+
+// loadRedirects returns the redirects of a config, or an error for the first
+// one that is invalid.
+func loadRedirects(config configServer) ([]content.Redirect, error) {
+	redirects := make([]content.Redirect, 0, len(config.Redirects))
+	for _, r := range config.Redirects {
+		redirect := content.Redirect{From: r.From, To: r.To, Prefix: r.Prefix, Status: r.Status}
+		if err := redirect.Check(); err != nil {
+			return nil, err
+		}
+		redirects = append(redirects, redirect)
+	}
+
+	return redirects, nil
 }
 
 type configVariable struct {
@@ -58,21 +135,65 @@ func (v configVariable) Load() (string, error) {
 		return v.Value, nil
 	case "env":
 		return os.Getenv(v.Value), nil
+	case "file":
+		data, err := os.ReadFile(v.Value)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
 	}
 
 	return "", errors.Errorf("invalid location %q", v.Location)
 }
 
+// inDir returns the variable with a relative file location resolved from the
+// given directory.
+func (v configVariable) inDir(dir string) configVariable {
+	if v.Location == "file" && !filepath.IsAbs(v.Value) {
+		v.Value = filepath.Join(dir, v.Value)
+	}
+	return v
+}
+
 type configContent struct {
 	Name         string `json:"name"`
 	Description  string `json:"description"`
 	Style        string `json:"style"`
+	URL          string `json:"url"`
 	ScanInterval int    `json:"scan_interval"`
 }
 
 type configNetwork struct {
 	Port int       `json:"port"`
 	TLS  configTLS `json:"tls"`
+	// TrustedProxies are the addresses or CIDR networks of the reverse
+	// proxies in front of the server, whose X-Forwarded-For headers give
+	// the address of the client.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+// loadTrustedProxies returns the networks of the trusted proxies of a config.
+// A single address is a network of just that address.
+func loadTrustedProxies(config configServer) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(config.Network.TrustedProxies))
+	for _, proxy := range config.Network.TrustedProxies {
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, errors.Errorf("%q is neither an address nor a CIDR network", proxy)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
 }
 
 type configTLS struct {
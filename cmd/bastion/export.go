@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/toddgaunt/bastion/internal/clock"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/content/watcher"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+// exportStore hides articles that require authentication from a store, unless
+// they are explicitly included. Included articles have their authentication
+// removed so they can be rendered.
+type exportStore struct {
+	content.Store
+	protected bool
+}
+
+func (s exportStore) include(article content.Article) bool {
+	return s.protected || !article.Protected()
+}
+
+func (s exportStore) filter(list []content.Article) []content.Article {
+	filtered := []content.Article{}
+	for _, article := range list {
+		if s.include(article) {
+			filtered = append(filtered, article)
+		}
+	}
+	return filtered
+}
+
+func (s exportStore) Get(key string) (content.Article, error) {
+	article, err := s.Store.Get(key)
+	if err != nil {
+		return article, err
+	}
+
+	if !s.include(article) {
+		return content.Article{}, os.ErrNotExist
+	}
+	article.Authenticator = nil
+	article.Group = ""
+
+	return article, nil
+}
+
+func (s exportStore) GetAll(pinned bool) []content.Article {
+	return s.filter(s.Store.GetAll(pinned))
+}
+
+func (s exportStore) GetTagged(tag string) []content.Article {
+	return s.filter(s.Store.GetTagged(tag))
+}
+
+func (s exportStore) Tags() map[string]int {
+	tags := make(map[string]int)
+	for tag := range s.Store.Tags() {
+		if n := len(s.GetTagged(tag)); n > 0 {
+			tags[tag] = n
+		}
+	}
+	return tags
+}
+
+// pageWriter is an http.ResponseWriter that keeps the response in memory.
+type pageWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (p *pageWriter) Header() http.Header {
+	return p.header
+}
+
+func (p *pageWriter) Write(b []byte) (int, error) {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	return p.body.Write(b)
+}
+
+func (p *pageWriter) WriteHeader(status int) {
+	p.status = status
+}
+
+// exporter renders pages from a router into files in a directory.
+type exporter struct {
+	router http.Handler
+	outDir string
+}
+
+// page requests a route from the router and writes the response to a file at
+// name within the output directory.
+func (e exporter) page(route, name string) error {
+	r, err := http.NewRequest(http.MethodGet, route, nil)
+	if err != nil {
+		return err
+	}
+
+	w := &pageWriter{header: make(http.Header)}
+	e.router.ServeHTTP(w, r)
+	if w.status != http.StatusOK {
+		return errors.Errorf("%s responded with %d: %s", route, w.status, w.body.String())
+	}
+
+	return e.write(name, w.body.Bytes())
+}
+
+func (e exporter) write(name string, data []byte) error {
+	name = filepath.Join(e.outDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(name, data, 0644)
+}
+
+// copyDir recursively copies the files in src to dst.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		in, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+
+		return out.Close()
+	})
+}
+
+// runExport renders every page of a site into a directory of plain files that
+// can be served by any static file server. Each route is written as an
+// index.html file in a directory of the same name so that links to the route
+// continue to work.
+func runExport(args []string) int {
+	fs := newFlagSet("export", "<site-dir> <out-dir>")
+	protected := fs.Bool("protected", false, "Include articles that require authentication")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	siteDir, outDir := fs.Arg(0), fs.Arg(1)
+
+	config, err := loadConfig(siteDir)
+	if err != nil {
+		return failf("couldn't load config: %v", err)
+	}
+
+	store := &watcher.Watcher{
+		Path:    path.Join(siteDir, "content"),
+		Logger:  log.NewNop(),
+		Details: siteDetails(config),
+	}
+	if err := store.Load(); err != nil {
+		return failf("couldn't load content: %v", err)
+	}
+
+	// Exported pages have no search, since a static file server can't
+	// answer it.
+	env := handlers.Env{
+		Store:  exportStore{Store: store, protected: *protected},
+		Logger: log.NewNop(),
+		Clock:  clock.Local(),
+	}
+
+	r, err := newRouter(http.NotFoundHandler(), env)
+	if err != nil {
+		return failf("couldn't create router: %v", err)
+	}
+
+	e := exporter{router: r, outDir: outDir}
+
+	status := 0
+	export := func(route, name string) {
+		if err := e.page(route, name); err != nil {
+			status = failf("couldn't export %s: %v", route, err)
+		}
+	}
+
+	export("/", "index.html")
+
+	now := env.Clock.Now()
+	for _, article := range store.Articles() {
+		switch {
+		case article.Err != nil:
+			warnf("skipping %s: %v", article.Path, article.Err)
+			continue
+		case !article.Visible(now):
+			warnf("skipping %s: article isn't published", article.Path)
+			continue
+		case article.Draft:
+			warnf("skipping %s: article is a draft", article.Path)
+			continue
+		case article.Protected() && !*protected:
+			warnf("skipping %s: article requires authentication", article.Path)
+			continue
+		}
+
+		export(article.Route, path.Join(article.Route, "index.html"))
+
+		// The source of a protected article holds its credentials, which
+		// must never be published.
+		if !article.Protected() {
+			export(article.Route+".md", article.Route+".md")
+		}
+	}
+
+	for _, id := range handlers.ProblemIDs() {
+		export("/.problems/"+id, path.Join(".problems", id, "index.html"))
+	}
+
+	export("/.tags", ".tags/index.html")
+	for tag := range env.Store.Tags() {
+		if tag == "." || tag == ".." || strings.ContainsAny(tag, `/\`) {
+			warnf("skipping tag %q: the tag can't be used as a file name", tag)
+			continue
+		}
+		export("/.tags/"+tag, path.Join(".tags", tag, "index.html"))
+	}
+
+	if config.Content.URL != "" {
+		export("/.feeds/rss.xml", ".feeds/rss.xml")
+		export("/.feeds/atom.xml", ".feeds/atom.xml")
+	} else {
+		warnf("skipping feeds: content.url must be configured for feeds to have absolute links")
+	}
+
+	static := path.Join(siteDir, "static")
+	if _, err := os.Stat(static); err == nil {
+		if err := copyDir(static, filepath.Join(outDir, ".static")); err != nil {
+			status = failf("couldn't copy static files: %v", err)
+		}
+	}
+
+	return status
+}
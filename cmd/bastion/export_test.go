@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata")
+
+// golden compares got with the golden file of a name in testdata, or replaces
+// the file with it if -update is given.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+
+	name = filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(name, []byte(got), 0644); err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if got != string(want) {
+		t.Fatalf("output doesn't match %s:\n%s", name, tests.Diff(string(want), got))
+	}
+}
+
+// listFiles returns the path of every file within a directory relative to it,
+// one per line in sorted order.
+func listFiles(t *testing.T, dir string) string {
+	t.Helper()
+
+	var names []string
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		names = append(names, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, "\n") + "\n"
+}
+
+// grepFiles returns the files within a directory that contain a string.
+func grepFiles(t *testing.T, dir, s string) []string {
+	t.Helper()
+
+	var found []string
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		if strings.Contains(string(data), s) {
+			found = append(found, name)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to search files: %v", err)
+	}
+
+	return found
+}
+
+func TestExport(t *testing.T) {
+	testCases := []struct {
+		name   string
+		flags  []string
+		golden string
+
+		// wantProtected is true if the pages of protected articles are
+		// exported.
+		wantProtected bool
+	}{
+		{name: "Public", golden: "export.golden"},
+		{name: "Protected", flags: []string{"-protected"}, golden: "export-protected.golden", wantProtected: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			out := t.TempDir()
+
+			if got, want := runExport(append(tc.flags, filepath.Join("testdata", "export"), out)), 0; got != want {
+				t.Fatalf("got exit status %d, want %d", got, want)
+			}
+
+			golden(t, tc.golden, listFiles(t, out))
+
+			// Drafts and unpublished articles are never exported, nor
+			// listed anywhere.
+			for _, text := range []string{"One does not simply walk", "The king has come back", "Return of the King"} {
+				if found := grepFiles(t, out, text); len(found) != 0 {
+					t.Errorf("found %q in %v", text, found)
+				}
+			}
+
+			// The credentials of protected articles are never exported.
+			if found := grepFiles(t, out, "$2a$10$"); len(found) != 0 {
+				t.Errorf("found a password hash in %v", found)
+			}
+
+			for _, text := range []string{"Speak, friend", "fellowship of the ring"} {
+				found := grepFiles(t, out, text)
+				if got, want := len(found) != 0, tc.wantProtected; got != want {
+					t.Errorf("got %q exported %t, want %t: %v", text, got, want, found)
+				}
+			}
+		})
+	}
+}
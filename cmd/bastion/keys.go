@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/files"
+)
+
+// loadSigner returns the signer of tokens for a site, and whether its keys
+// persist. If authentication is disabled or no keyring is configured, a key is
+// generated that only lasts until the server stops.
+func loadSigner(prefixDir string, config configServer) (auth.Signer, bool, error) {
+	v := config.Authentication.Keyring.inDir(prefixDir)
+
+	switch {
+	case config.Authentication.Disabled || v.Value == "":
+		key, err := auth.GenerateSymmetricKey()
+		return key, false, err
+	case v.Location == "file":
+		f := &auth.KeyringFile{Path: v.Value}
+		err := f.Load()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, true, errors.Errorf("%w, create it with '%s keys rotate %s'", err, os.Args[0], prefixDir)
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		return f, true, nil
+	}
+
+	data, err := v.Load()
+	if err != nil {
+		return nil, true, err
+	}
+
+	keyring, err := auth.ParseKeyring([]byte(data))
+	return keyring, true, err
+}
+
+// runKeys manages the keyring used to sign tokens.
+func runKeys(args []string) int {
+	if len(args) == 0 || args[0] != "rotate" {
+		fmt.Fprintf(os.Stderr, "Usage: %s keys rotate [flags] <site-dir>\n", os.Args[0])
+		return 2
+	}
+
+	fs := newFlagSet("keys rotate", "<site-dir>")
+	grace := fs.Duration("grace", 24*time.Hour, "How long the previous keys are accepted after rotating")
+	alg := fs.String("alg", auth.HS256, "Algorithm the new key signs with, either HS256 or ES256")
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	siteDir := fs.Arg(0)
+
+	config, err := loadConfig(siteDir)
+	if err != nil {
+		return failf("couldn't load config: %v", err)
+	}
+
+	v := config.Authentication.Keyring.inDir(siteDir)
+	if v.Location != "file" || v.Value == "" {
+		return failf("authentication.keyring must be configured with a file location to rotate keys")
+	}
+
+	now := time.Now()
+
+	var keyring *auth.Keyring
+	data, err := os.ReadFile(v.Value)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		keyring, err = auth.NewKeyring(now, *alg)
+	case err == nil:
+		keyring, err = auth.ParseKeyring(data)
+		if err == nil {
+			err = keyring.Rotate(now, *grace, *alg)
+		}
+	}
+	if err != nil {
+		return failf("couldn't rotate keys in %s: %v", v.Value, err)
+	}
+
+	data, err = json.MarshalIndent(keyring, "", "\t")
+	if err != nil {
+		return failf("couldn't encode keyring: %v", err)
+	}
+
+	if err := files.WriteFile(v.Value, append(data, '\n'), 0600); err != nil {
+		return failf("couldn't write keyring: %v", err)
+	}
+
+	fmt.Printf("%s is the active key in %s\n", keyring.Active, v.Value)
+	for _, key := range keyring.Keys {
+		if !key.Retires.IsZero() {
+			fmt.Printf("%s retires at %s\n", key.ID, key.Retires.Format(time.RFC3339))
+		}
+	}
+
+	return 0
+}
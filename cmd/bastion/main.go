@@ -9,12 +9,14 @@ import (
 	"path"
 	"sync"
 
+	"github.com/go-chi/chi/middleware"
 	"github.com/toddgaunt/bastion/internal/auth"
 	"github.com/toddgaunt/bastion/internal/clock"
 	"github.com/toddgaunt/bastion/internal/content"
 	"github.com/toddgaunt/bastion/internal/content/watcher"
 	"github.com/toddgaunt/bastion/internal/handlers"
 	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/search"
 )
 
 func main() {
@@ -30,6 +32,7 @@ func main() {
 	flag.BoolVar(&tlsDisable, "tls-disable", false, "Disable TLS even if the config specifies it")
 	flag.BoolVar(&exampleConfig, "example-config", false, "Output an example config.json")
 
+	flag.Usage = usage
 	flag.Parse()
 
 	logger := log.New()
@@ -47,19 +50,17 @@ func main() {
 
 	args := flag.Args()
 	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			os.Exit(cmd.run(args[1:]))
+		}
 		prefixDir = args[0]
 	}
 
-	var config configServer
-	data, err := os.ReadFile(prefixDir + "/config.json")
+	config, err := loadConfig(prefixDir)
 	if err != nil {
 		logger.Printf(log.Fatal, "couldn't load config: %v", err)
 	}
 
-	if err := json.Unmarshal(data, &config); err != nil {
-		logger.Printf(log.Fatal, "couldn't load config: %v", err)
-	}
-
 	// Only flags that are explicitly set from commandline can be visited, so
 	// this will skip any that weren't provided and preserve the values from
 	// the config file.
@@ -80,6 +81,45 @@ func main() {
 	os.Exit(serve(prefixDir, config))
 }
 
+// usage prints how to use bastion and each of its subcommands.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [site-dir]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s <command> [flags] [args]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	for _, name := range commandNames() {
+		fmt.Fprintf(out, "  %-14s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// siteDetails returns the details of a site's content from its config.
+func siteDetails(config configServer) content.Details {
+	return content.Details{
+		Name:        config.Content.Name,
+		Description: config.Content.Description,
+		Style:       config.Content.Style,
+		URL:         config.Content.URL,
+	}
+}
+
+// loadConfig reads the config.json located in a site directory.
+func loadConfig(prefixDir string) (configServer, error) {
+	var config configServer
+
+	data, err := os.ReadFile(path.Join(prefixDir, "config.json"))
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
 func isFlagPassed(name string) bool {
 	found := false
 	flag.Visit(func(f *flag.Flag) {
@@ -99,59 +139,98 @@ func serve(prefixDir string, config configServer) int {
 	dir := path.Clean(prefixDir)
 	staticFileServer := http.FileServer(http.Dir(dir + "/static"))
 
-	details := content.Details{
-		Name:        config.Content.Name,
-		Description: config.Content.Description,
-		Style:       config.Content.Style,
-	}
+	index := search.New()
 
 	store := &watcher.Watcher{
 		Path:    dir + "/content",
 		Logger:  logger,
-		Details: details,
+		Details: siteDetails(config),
+		Index:   index,
+		Clock:   clock.Local(),
 	}
 
 	store.Start(done, wg)
 
-	var authenticator auth.Authenticator
+	redirects, err := loadRedirects(config)
+	if err != nil {
+		logger.Printf(log.Fatal, "redirects: %v", err)
+	}
+	for _, err := range content.RedirectConflicts(redirects, store.Articles()) {
+		logger.With("err", err).Print(log.Warn, "redirect conflict")
+	}
+
+	authenticator, err := loadAuthenticator(dir, config)
+	if err != nil {
+		logger.Printf(log.Fatal, "failed to create authenticator: %v", err)
+	}
 	if config.Authentication.Disabled {
 		logger.Print(log.Warn, "Authentication is disabled")
-		authenticator = auth.NewDisabled()
-	} else {
-		username, err := config.Authentication.Username.Load()
-		if err != nil {
-			logger.Printf(log.Fatal, "authentication.username: %v", err)
-		}
+	}
 
-		password, err := config.Authentication.Password.Load()
-		if err != nil {
-			logger.Printf(log.Fatal, "authentication.password: %v", err)
-		}
+	signKey, persistent, err := loadSigner(dir, config)
+	if err != nil {
+		logger.Printf(log.Fatal, "authentication.keyring: %v", err)
+	}
+	if !persistent && !config.Authentication.Disabled {
+		logger.Print(log.Warn, "No keyring is configured, tokens will be invalidated when the server stops")
+	}
 
-		authenticator, err = auth.NewSimple(username, password)
-		if err != nil {
-			logger.Printf(log.Fatal, "failed to create authenticator: %v", err)
-		}
+	refresh, err := loadRefreshStore(dir, config)
+	if err != nil {
+		logger.Printf(log.Fatal, "authentication.refresh_tokens: %v", err)
+	}
+
+	tokens, err := loadTokenStore(dir, config)
+	if err != nil {
+		logger.Printf(log.Fatal, "authentication.api_tokens: %v", err)
+	}
+
+	sessions, err := loadSessionStore(dir, config)
+	if err != nil {
+		logger.Printf(log.Fatal, "authentication.sessions: %v", err)
 	}
 
-	signKey, err := auth.GenerateSymmetricKey()
+	oidc, err := loadOIDC(dir, config)
 	if err != nil {
-		logger.Printf(log.Fatal, "failed to generate auth key: %v", err)
+		logger.Printf(log.Fatal, "authentication.oidc: %v", err)
 	}
 
+	proxies, err := loadTrustedProxies(config)
+	if err != nil {
+		logger.Printf(log.Fatal, "network.trusted_proxies: %v", err)
+	}
+
+	go sweepTokens(map[string]sweeper{
+		"refresh tokens": refresh,
+		"API tokens":     tokens,
+		"sessions":       sessions,
+	}, logger)
+
 	env := handlers.Env{
-		Store:   store,
-		Logger:  logger,
-		Clock:   clock.Local(),
-		Auth:    authenticator,
-		SignKey: signKey,
+		Store:     store,
+		Searcher:  index,
+		Logger:    logger,
+		Clock:     clock.Local(),
+		Redirects: redirects,
+		Auth:      authenticator,
+		SignKey:   signKey,
+		Refresh:   refresh,
+		Tokens:    tokens,
+		Sessions:  sessions,
+		OIDC:      oidc,
+		Limiter:   auth.NewLimiter(clock.Local()),
+
+		TrustedProxies: proxies,
 	}
 
-	r, err := newRouter(staticFileServer, env)
+	router, err := newRouter(staticFileServer, env)
 	if err != nil {
 		logger.Printf(log.Fatal, "couldn't create router: %v", err)
 	}
 
+	// Only requests to the server are logged, not pages rendered by commands.
+	r := middleware.RequestID(middleware.Logger(router))
+
 	addr := fmt.Sprintf(":%d", config.Network.Port)
 
 	if !config.Network.TLS.Disable && (config.Network.TLS.Cert != "" && config.Network.TLS.Key != "") {
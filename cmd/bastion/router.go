@@ -5,15 +5,23 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
+	"github.com/toddgaunt/bastion/internal/auth"
 	"github.com/toddgaunt/bastion/internal/handlers"
 )
 
 func newRouter(staticFileServer http.Handler, env handlers.Env) (chi.Router, error) {
 	r := chi.NewRouter()
 
-	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
+	// Writing documents requires the editor role, while deleting them and
+	// managing other users requires the admin role.
+	editor := []func(http.Handler) http.Handler{env.Authorize, env.RequireRole(auth.RoleEditor)}
+	admin := []func(http.Handler) http.Handler{env.Authorize, env.RequireRole(auth.RoleAdmin)}
+
+	// Moved routes are redirected before anything else sees them.
+	r.Use(env.Redirect)
+
+	// Editors can preview any page as it will be at another time.
+	r.Use(env.AsOf)
 
 	r.NotFound(env.NotFound)
 
@@ -25,7 +33,9 @@ func newRouter(staticFileServer http.Handler, env handlers.Env) (chi.Router, err
 		r.Group(func(r chi.Router) {
 			r.Use(handlers.ArticlePath)
 			r.Get("/*", env.GetArticle)
-			r.With(env.Authorize).Post("/*", env.UpdateDocument)
+			r.With(editor...).Post("/*", env.UpdateDocument)
+			r.With(editor...).Put("/*", env.CreateDocument)
+			r.With(admin...).Delete("/*", env.DeleteDocument)
 		})
 	})
 
@@ -36,11 +46,56 @@ func newRouter(staticFileServer http.Handler, env handlers.Env) (chi.Router, err
 		})
 	})
 
+	r.Route("/.history", func(r chi.Router) {
+		r.Use(handlers.ArticlePath)
+		r.With(editor...).Get("/*", env.History)
+		r.With(editor...).Post("/*", env.RestoreRevision)
+	})
+
+	r.With(editor...).Post("/.preview", env.Preview)
+
+	r.With(handlers.ArticlePath).Get("/.edit/*", env.Edit)
+	r.With(handlers.ArticlePath).With(editor...).Get("/.source/*", env.Source)
+
+	r.Route("/.tags", func(r chi.Router) {
+		r.Get("/", env.Tags)
+		r.With(handlers.TagName).Get("/{tag}", env.Tagged)
+	})
+
+	r.Get("/.search", env.Search)
+	r.Get("/.search.json", env.SearchJSON)
+
+	r.Route("/.feeds", func(r chi.Router) {
+		r.Get("/rss.xml", env.RSS)
+		r.Get("/atom.xml", env.Atom)
+	})
+
 	r.Handle("/.static/*", http.StripPrefix("/.static/", staticFileServer))
 
 	r.Route("/.auth", func(r chi.Router) {
 		r.Get("/login", env.Login)
+		r.Post("/login/code", env.LoginCode)
 		r.Post("/token", env.Token)
+		r.Get("/jwks.json", env.JWKS)
+		r.Post("/logout", env.Logout)
+		r.Get("/article", env.ArticleLogin)
+		r.Post("/article", env.ArticleLogin)
+		r.Post("/article/logout", env.ArticleLogout)
+		r.With(admin...).Post("/revoke", env.RevokeUser)
+		r.With(editor...).Post("/share", env.ShareDraft)
+		r.Get("/session", env.SessionLogin)
+		r.Post("/session", env.SessionLogin)
+		r.With(env.Authorize).Post("/session/logout", env.SessionLogout)
+		r.With(env.Authorize).Get("/sessions", env.ListSessions)
+		r.With(env.Authorize).Post("/sessions/revoke", env.RevokeSession)
+		r.Get("/oidc/login", env.OIDCLogin)
+		r.Get("/oidc/callback", env.OIDCCallback)
+		r.Route("/tokens", func(r chi.Router) {
+			r.Use(env.Authorize)
+			r.Get("/", env.ListTokens)
+			r.Post("/", env.CreateToken)
+			r.Delete("/{id}", env.RevokeToken)
+		})
 	})
 
 	return r, nil
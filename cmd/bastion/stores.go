@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+// loadRefreshStore returns the store of refresh tokens for a site. Refresh
+// tokens are kept in memory if no file is configured, or authentication is
+// disabled.
+func loadRefreshStore(prefixDir string, config configServer) (auth.RefreshStore, error) {
+	name := config.Authentication.RefreshTokens
+	if config.Authentication.Disabled || name == "" {
+		return auth.NewMemoryRefreshStore(), nil
+	}
+
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(prefixDir, name)
+	}
+
+	return auth.NewFileRefreshStore(name)
+}
+
+// tokensPath returns the path of the API token file of a site, or an empty
+// string if there is none.
+func tokensPath(prefixDir string, config configServer) string {
+	name := config.Authentication.APITokens
+	if name != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(prefixDir, name)
+	}
+	return name
+}
+
+// loadTokenStore returns the store of API tokens for a site. API tokens are
+// kept in memory if no file is configured, or authentication is disabled.
+func loadTokenStore(prefixDir string, config configServer) (auth.TokenStore, error) {
+	name := tokensPath(prefixDir, config)
+	if config.Authentication.Disabled || name == "" {
+		return auth.NewMemoryTokenStore(), nil
+	}
+
+	return auth.NewFileTokenStore(name)
+}
+
+// loadSessionStore returns the store of browser sessions for a site. Sessions
+// are kept in memory if no file is configured, or authentication is disabled.
+func loadSessionStore(prefixDir string, config configServer) (auth.SessionStore, error) {
+	name := config.Authentication.Sessions
+	if config.Authentication.Disabled || name == "" {
+		return auth.NewMemorySessionStore(), nil
+	}
+
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(prefixDir, name)
+	}
+
+	return auth.NewFileSessionStore(name)
+}
+
+// sweepInterval is how often expired tokens and sessions are removed.
+const sweepInterval = time.Hour
+
+// sweeper is a store of tokens or sessions that expire.
+type sweeper interface {
+	Sweep(now time.Time) error
+}
+
+// sweepTokens removes expired tokens and sessions from their stores forever.
+// The stores are named by what they keep.
+func sweepTokens(stores map[string]sweeper, logger log.Logger) {
+	for now := range time.Tick(sweepInterval) {
+		for name, store := range stores {
+			if err := store.Sweep(now); err != nil {
+				logger.With("err", err.Error()).Printf(log.Error, "failed to sweep %s", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+// userClaims returns the claims a user of a site is given when they log in.
+func userClaims(prefixDir string, config configServer, username string) (auth.Claims, error) {
+	if name := usersPath(prefixDir, config); name != "" {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return auth.Claims{}, errors.Errorf("couldn't read users: %w", err)
+		}
+
+		users, err := auth.ParseUsers(data)
+		if err != nil {
+			return auth.Claims{}, errors.Errorf("couldn't read users from %s: %w", name, err)
+		}
+
+		for _, user := range users {
+			if user.Username == username {
+				return user.Claims(), nil
+			}
+		}
+
+		return auth.Claims{}, errors.Errorf("%s isn't a user", username)
+	}
+
+	configured, err := config.Authentication.Username.Load()
+	if err != nil {
+		return auth.Claims{}, errors.Errorf("authentication.username: %w", err)
+	}
+	if username != configured {
+		return auth.Claims{}, errors.Errorf("%s isn't a user", username)
+	}
+
+	return auth.Claims{Username: username, Roles: []string{auth.RoleAdmin}, Admin: true}, nil
+}
+
+// runToken manages the API tokens of a site.
+func runToken(args []string) int {
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "Usage: %s token create|list|revoke [flags] <site-dir> [args]\n", os.Args[0])
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	var fs *flag.FlagSet
+	var name, scopes *string
+	var expires *time.Duration
+	minArgs, maxArgs := 2, 2
+	switch args[0] {
+	case "create":
+		fs = newFlagSet("token create", "<site-dir> <username>")
+		name = fs.String("name", "", "Name of the token, describing what it is used for")
+		scopes = fs.String("scopes", auth.ScopeWriteContent, "Comma separated scopes of the token: read-protected, write-content or admin")
+		expires = fs.Duration("expires", 90*24*time.Hour, "How long until the token expires")
+	case "list":
+		fs = newFlagSet("token list", "<site-dir> [username]")
+		minArgs = 1
+	case "revoke":
+		fs = newFlagSet("token revoke", "<site-dir> <id>")
+	default:
+		return usage()
+	}
+	fs.Parse(args[1:])
+
+	if fs.NArg() < minArgs || fs.NArg() > maxArgs {
+		fs.Usage()
+		return 2
+	}
+	siteDir := fs.Arg(0)
+
+	config, err := loadConfig(siteDir)
+	if err != nil {
+		return failf("couldn't load config: %v", err)
+	}
+
+	path := tokensPath(siteDir, config)
+	if path == "" {
+		return failf("authentication.api_tokens must be configured to manage API tokens")
+	}
+
+	store, err := auth.NewFileTokenStore(path)
+	if err != nil {
+		return failf("couldn't read API tokens: %v", err)
+	}
+
+	switch args[0] {
+	case "create":
+		username := fs.Arg(1)
+
+		claims, err := userClaims(siteDir, config, username)
+		if err != nil {
+			return failf("%v", err)
+		}
+
+		token, record, err := store.Create(*name, claims, strings.Split(*scopes, ","), time.Now(), *expires)
+		if err != nil {
+			return failf("couldn't create API token: %v", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Created API token %s for %s, which expires %s. It can't be shown again:\n", record.ID, username, record.Expires.Format(time.RFC3339))
+		fmt.Println(token)
+	case "list":
+		tokens, err := store.List(fs.Arg(1))
+		if err != nil {
+			return failf("%v", err)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSERNAME\tNAME\tSCOPES\tEXPIRES\tLAST USED")
+		for _, token := range tokens {
+			lastUsed := "never"
+			if !token.LastUsed.IsZero() {
+				lastUsed = token.LastUsed.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", token.ID, token.User.Username, token.Name, strings.Join(token.Scopes, ","), token.Expires.Format(time.RFC3339), lastUsed)
+		}
+		tw.Flush()
+	case "revoke":
+		if err := store.Revoke(fs.Arg(1)); err != nil {
+			return failf("couldn't revoke API token %s: %v", fs.Arg(1), err)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/files"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// usersPath returns the path of the user file of a site, or an empty string if
+// there is none.
+func usersPath(prefixDir string, config configServer) string {
+	name := config.Authentication.Users
+	if name != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(prefixDir, name)
+	}
+	return name
+}
+
+// loadAuthenticator returns the authenticator for the users of a site. The
+// users are read from the user file if one is configured, otherwise the
+// configured username and password is the only user.
+func loadAuthenticator(prefixDir string, config configServer) (auth.Authenticator, error) {
+	if config.Authentication.Disabled {
+		return auth.NewDisabled(), nil
+	}
+
+	if name := usersPath(prefixDir, config); name != "" {
+		f := &auth.UserFile{Path: name}
+		err := f.Load()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Errorf("authentication.users: %w, create it with '%s user add %s <username>'", err, os.Args[0], prefixDir)
+		}
+		if err != nil {
+			return nil, errors.Errorf("authentication.users: %w", err)
+		}
+		return f, nil
+	}
+
+	username, err := config.Authentication.Username.Load()
+	if err != nil {
+		return nil, errors.Errorf("authentication.username: %w", err)
+	}
+
+	password, err := config.Authentication.Password.Load()
+	if err != nil {
+		return nil, errors.Errorf("authentication.password: %w", err)
+	}
+
+	return auth.NewSimple(username, password)
+}
+
+// loadOIDC returns the OpenID Connect provider users log in with, or nil if
+// none is configured.
+func loadOIDC(prefixDir string, config configServer) (*auth.OIDC, error) {
+	c := config.Authentication.OIDC
+	if c == nil || config.Authentication.Disabled {
+		return nil, nil
+	}
+
+	secret, err := c.ClientSecret.inDir(prefixDir).Load()
+	if err != nil {
+		return nil, errors.Errorf("client_secret: %w", err)
+	}
+
+	redirectURL := c.RedirectURL
+	if redirectURL == "" {
+		if config.Content.URL == "" {
+			return nil, errors.New("redirect_url is required when content.url isn't set")
+		}
+		redirectURL = strings.TrimSuffix(config.Content.URL, "/") + "/.auth/oidc/callback"
+	}
+
+	return auth.NewOIDC(auth.OIDCConfig{
+		Issuer:        c.Issuer,
+		ClientID:      c.ClientID,
+		ClientSecret:  secret,
+		RedirectURL:   redirectURL,
+		Scopes:        c.Scopes,
+		UsernameClaim: c.UsernameClaim,
+		GroupsClaim:   c.GroupsClaim,
+		Roles:         c.Roles,
+	}, &http.Client{Timeout: 10 * time.Second})
+}
+
+// readLine reads the first line of the standard input, prompting for it if
+// the input is a terminal.
+func readLine(name string) (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintf(os.Stderr, "%s: ", name)
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Errorf("couldn't read %s: %w", strings.ToLower(name), err)
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.Errorf("the %s can't be empty", strings.ToLower(name))
+	}
+
+	return line, nil
+}
+
+// readPassword reads a password from the first line of the standard input.
+func readPassword() (string, error) {
+	return readLine("Password")
+}
+
+// recoveryCodeCount is how many recovery codes a user is given when enrolling
+// a second factor.
+const recoveryCodeCount = 10
+
+// enrollTOTP enrolls a new TOTP second factor for a user, once they have
+// confirmed it with a code from their authenticator app, and returns their
+// recovery codes.
+func enrollTOTP(user *auth.User, issuer string) ([]string, error) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, errors.Errorf("couldn't generate secret: %w", err)
+	}
+
+	fmt.Printf("Add this account to an authenticator app, either from the URL:\n\n\t%s\n\n", auth.TOTPURL(issuer, user.Username, secret))
+	fmt.Printf("or by entering the secret:\n\n\t%s\n\n", secret)
+
+	code, err := readLine("Code")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := auth.VerifyTOTP(secret, strings.TrimSpace(code), time.Now()); err != nil {
+		return nil, errors.Errorf("the code doesn't match, the second factor wasn't enrolled: %w", err)
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, errors.Errorf("couldn't generate recovery codes: %w", err)
+	}
+
+	user.TOTPSecret = secret
+	user.RecoveryCodes = make([]string, len(codes))
+	for i, code := range codes {
+		user.RecoveryCodes[i] = auth.HashRecoveryCode(code)
+	}
+
+	return codes, nil
+}
+
+// runUser manages the users listed in the user file of a site.
+func runUser(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s user add|passwd|totp|remove [flags] <site-dir> <username>\n", os.Args[0])
+		return 2
+	}
+
+	fs := newFlagSet("user "+args[0], "<site-dir> <username>")
+	var name, roles *string
+	var disable *bool
+	switch args[0] {
+	case "add":
+		name = fs.String("name", "", "Name of the user as it is displayed")
+		roles = fs.String("roles", "editor", "Comma separated roles of the user")
+	case "totp":
+		disable = fs.Bool("disable", false, "Remove the second factor of the user instead of enrolling a new one")
+	case "passwd", "remove":
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: %s user add|passwd|totp|remove [flags] <site-dir> <username>\n", os.Args[0])
+		return 2
+	}
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	siteDir, username := fs.Arg(0), fs.Arg(1)
+
+	config, err := loadConfig(siteDir)
+	if err != nil {
+		return failf("couldn't load config: %v", err)
+	}
+
+	path := usersPath(siteDir, config)
+	if path == "" {
+		return failf("authentication.users must be configured to manage users")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !(errors.Is(err, os.ErrNotExist) && args[0] == "add") {
+		return failf("couldn't read users: %v", err)
+	}
+
+	users, err := auth.ParseUsers(data)
+	if err != nil {
+		return failf("couldn't read users from %s: %v", path, err)
+	}
+
+	var recoveryCodes []string
+
+	i := 0
+	for i < len(users) && users[i].Username != username {
+		i++
+	}
+	found := i < len(users)
+
+	switch args[0] {
+	case "add":
+		if found {
+			return failf("%s is already a user, use '%s user passwd' to change their password", username, os.Args[0])
+		}
+
+		user := auth.User{Username: username, Name: *name, Roles: []string{}}
+		if *roles != "" {
+			user.Roles = strings.Split(*roles, ",")
+		}
+
+		password, err := readPassword()
+		if err != nil {
+			return failf("%v", err)
+		}
+		if err := user.SetPassword(password); err != nil {
+			return failf("couldn't hash password: %v", err)
+		}
+
+		users = append(users, user)
+	case "passwd":
+		if !found {
+			return failf("%s isn't a user", username)
+		}
+
+		password, err := readPassword()
+		if err != nil {
+			return failf("%v", err)
+		}
+		if err := users[i].SetPassword(password); err != nil {
+			return failf("couldn't hash password: %v", err)
+		}
+	case "totp":
+		if !found {
+			return failf("%s isn't a user", username)
+		}
+
+		if *disable {
+			users[i].TOTPSecret = ""
+			users[i].RecoveryCodes = nil
+			break
+		}
+
+		recoveryCodes, err = enrollTOTP(&users[i], config.Content.Name)
+		if err != nil {
+			return failf("%v", err)
+		}
+	case "remove":
+		if !found {
+			return failf("%s isn't a user", username)
+		}
+
+		users = append(users[:i], users[i+1:]...)
+	}
+
+	data, err = auth.MarshalUsers(users)
+	if err != nil {
+		return failf("%v", err)
+	}
+
+	if err := files.WriteFile(path, data, 0600); err != nil {
+		return failf("couldn't write users: %v", err)
+	}
+
+	if args[0] == "remove" {
+		fmt.Printf("%s can no longer log in, revoke the tokens already issued to them at /.auth/revoke\n", username)
+	}
+
+	if len(recoveryCodes) > 0 {
+		fmt.Printf("\nEnrolled a second factor for %s. Keep these recovery codes somewhere safe, each can be used once in place of a code:\n\n", username)
+		for _, code := range recoveryCodes {
+			fmt.Printf("\t%s\n", code)
+		}
+	}
+
+	return 0
+}
+
+// runHashPassword prints the bcrypt hash of a password read from the standard
+// input.
+func runHashPassword(args []string) int {
+	fs := newFlagSet("hash-password", "")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return failf("%v", err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return failf("couldn't hash password: %v", err)
+	}
+
+	fmt.Println(string(hash))
+
+	return 0
+}
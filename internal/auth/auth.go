@@ -6,14 +6,37 @@ import (
 )
 
 // Authenticator is the basic interface used for authenticating a user.
+// Authenticating is done in two steps for users that enrolled a second
+// factor: first the password is checked by Authenticate, then a one-time code
+// by VerifyCode.
 type Authenticator interface {
 	Authenticate(username, password string) (Claims, error)
+	// Lookup returns the current claims of a user, without their password,
+	// so that credentials issued earlier follow changes to the user. It
+	// returns ErrUserNotFound if the user no longer exists.
+	Lookup(username string) (Claims, error)
+	// SecondFactor returns true if a user must also give a one-time code.
+	SecondFactor(username string) bool
+	// VerifyCode checks a one-time code or a recovery code of a user. Each
+	// code can only be used once.
+	VerifyCode(username, code string, now time.Time) error
 }
 
 // Claims contains information an authentication claims to verify.
 type Claims struct {
 	Username string `json:"uid,omitempty"`
-	Admin    bool   `json:"adm,omitempty"`
+	// Name is the name of the user as it is displayed.
+	Name  string   `json:"name,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	Admin bool     `json:"adm,omitempty"`
+	// Audience restricts what the claims can be used for, such as "access"
+	// for access tokens.
+	Audience string `json:"aud,omitempty"` // RFC 7519 4.1.3
+	// SessionID is the session a session cookie belongs to.
+	SessionID string `json:"sid,omitempty"`
+	// Credential is the fingerprint of the credentials of the article an
+	// article cookie was issued for.
+	Credential string `json:"crd,omitempty"`
 
 	// These fields are filled in automatically.
 	Expiry    int64 `json:"exp"` // RFC 7519 4.1.4
@@ -21,6 +44,20 @@ type Claims struct {
 	IssuedAt  int64 `json:"iat"` // RFC 7519 4.1.6
 }
 
+// HasRole returns true if the claims grant a role. Administrators have every
+// role.
+func (c Claims) HasRole(role string) bool {
+	if c.Admin {
+		return true
+	}
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 // IsValid returns true if the claims are valid at the given time.
 func (c Claims) IsValid(now time.Time) bool {
 	return c.NotBefore <= now.Unix() && c.Expiry > now.Unix()
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"math/big"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+// JWK is a public key in the JSON Web Key format of RFC 7517, which allows
+// others to verify tokens without sharing a secret.
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+	// N and E are the modulus and exponent of RSA keys, which are only
+	// verified, for tokens signed by others.
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+}
+
+// PublicKey returns the key as an *ecdsa.PublicKey for P-256 keys, or an
+// *rsa.PublicKey for RSA keys.
+func (k JWK) PublicKey() (interface{}, error) {
+	decode := func(name, value string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(b) == 0 {
+			return nil, errors.Errorf("key %q has an invalid %s: %w", k.ID, name, err)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.KeyType {
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, errors.Errorf("key %q has unsupported curve %q", k.ID, k.Curve)
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.Errorf("key %q isn't on the P-256 curve", k.ID)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "RSA":
+		n, err := decode("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > math.MaxInt32 || n.BitLen() < 2048 {
+			return nil, errors.Errorf("key %q isn't a supported RSA key", k.ID)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	}
+
+	return nil, errors.Errorf("key %q has unsupported type %q", k.ID, k.KeyType)
+}
+
+// JWKSet is a set of public keys, as served from a JWKS endpoint.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ECKey is a private key for signing tokens with ES256.
+type ECKey struct {
+	*ecdsa.PrivateKey
+}
+
+// GenerateECKey creates a random P-256 key for signing claims with ES256.
+func GenerateECKey() (ECKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return ECKey{key}, err
+}
+
+// JWK returns the public half of the key with the given key ID.
+func (key ECKey) JWK(id string) JWK {
+	// Coordinates are padded to the size of the curve, as RFC 7518 6.2.1.2
+	// requires.
+	size := (key.Curve.Params().BitSize + 7) / 8
+	x := make([]byte, size)
+	y := make([]byte, size)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return JWK{
+		KeyType:   "EC",
+		Curve:     "P-256",
+		X:         base64.RawURLEncoding.EncodeToString(x),
+		Y:         base64.RawURLEncoding.EncodeToString(y),
+		ID:        id,
+		Algorithm: ES256,
+		Use:       "sig",
+	}
+}
+
+// MarshalJSON encodes the key in PKCS #8 form as base64.
+func (key ECKey) MarshalJSON() ([]byte, error) {
+	if key.PrivateKey == nil {
+		return []byte("null"), nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(base64.StdEncoding.EncodeToString(der))
+}
+
+// UnmarshalJSON decodes a P-256 key in PKCS #8 form from base64.
+func (key *ECKey) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	der, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return err
+	}
+
+	private, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || private.Curve != elliptic.P256() {
+		return errors.New("key must be a P-256 ECDSA key")
+	}
+	key.PrivateKey = private
+
+	return nil
+}
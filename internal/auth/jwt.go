@@ -12,7 +12,15 @@ import (
 
 const (
 	keySize  = 32
-	signAlgo = jose.HS256
+	signAlgo = HS256
+)
+
+// Algorithms tokens can be signed with. RS256 is only verified, since it is
+// what most OpenID Connect providers sign ID tokens with.
+const (
+	HS256 = jose.HS256
+	ES256 = jose.ES256
+	RS256 = jose.RS256
 )
 
 // JWT contains signed claim information.
@@ -69,6 +77,11 @@ func (key SymmetricKey) Verify(token JWT) (Claims, error) {
 	return claims, nil
 }
 
+// PublicKeys returns no keys, since a symmetric key can't be shared.
+func (key SymmetricKey) PublicKeys() JWKSet {
+	return JWKSet{Keys: []JWK{}}
+}
+
 // GenerateSymmetricKey creates a random symmetric key for signing or encrypting claims.
 func GenerateSymmetricKey() (SymmetricKey, error) {
 	var key SymmetricKey
@@ -1,6 +1,7 @@
 package auth_test
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -102,7 +103,7 @@ func TestVerify(t *testing.T) {
 				t.Fatalf("encryption error: %v", err)
 			}
 
-			if got != tc.want {
+			if !reflect.DeepEqual(got, tc.want) {
 				t.Fatalf("got = %v, want %v", got, tc.want)
 			}
 		})
@@ -112,6 +113,8 @@ func TestVerify(t *testing.T) {
 func TestSignAndVerify(t *testing.T) {
 	claims := auth.Claims{
 		Username:  "samwise.gamgee",
+		Name:      "Samwise Gamgee",
+		Roles:     []string{"editor", "admin"},
 		Admin:     true,
 		Expiry:    time.Unix(62, 0).Unix(),
 		IssuedAt:  time.Unix(42, 0).Unix(),
@@ -128,7 +131,7 @@ func TestSignAndVerify(t *testing.T) {
 		t.Fatalf("failed to verify signature of token %q: %v", token, err)
 	}
 
-	if got != claims {
+	if !reflect.DeepEqual(got, claims) {
 		t.Fatalf("claims retrieved from token don't match: got %v, want %v", got, claims)
 	}
 }
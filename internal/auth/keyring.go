@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+const (
+	ErrKeyNotFound = errors.Type("key-not-found")
+	ErrKeyRetired  = errors.Type("key-retired")
+)
+
+// Signer signs claims into a JWT, and verifies the JWTs it signed.
+type Signer interface {
+	Sign(claims Claims, now time.Time, lifetime time.Duration) (JWT, error)
+	Verify(token JWT) (Claims, error)
+	// PublicKeys returns the keys others can verify the JWTs with.
+	PublicKeys() JWKSet
+}
+
+// Key is a signing key within a keyring. Each key signs with a single
+// algorithm, and only tokens signed with that algorithm are verified with it.
+type Key struct {
+	ID string `json:"kid"`
+	// Algorithm is either HS256, which is the default, or ES256. An HS256
+	// key must have a Secret and an ES256 key a Private key, and neither may
+	// have the other, which ParseKeyring checks since each is left out of the
+	// JSON when it is zero.
+	Algorithm string       `json:"alg,omitempty"`
+	Secret    SymmetricKey `json:"secret,omitzero"`
+	Private   ECKey        `json:"private,omitzero"`
+	Created   time.Time    `json:"created"`
+	// Retires is when the key stops being accepted to verify tokens. A key
+	// that hasn't been retired has a zero Retires.
+	Retires time.Time `json:"retires,omitzero"`
+}
+
+// algorithm returns the algorithm the key signs with.
+func (k Key) algorithm() string {
+	if k.Algorithm == "" {
+		return HS256
+	}
+	return k.Algorithm
+}
+
+// signingKey returns the key in the form jose expects to sign with.
+func (k Key) signingKey() interface{} {
+	if k.algorithm() == ES256 {
+		return k.Private.PrivateKey
+	}
+	return k.Secret[:]
+}
+
+// verifyingKey returns the key in the form jose expects to verify with.
+func (k Key) verifyingKey() interface{} {
+	if k.algorithm() == ES256 {
+		return &k.Private.PublicKey
+	}
+	return k.Secret[:]
+}
+
+// retired returns true if the key is no longer accepted at the given time.
+func (k Key) retired(now time.Time) bool {
+	return !k.Retires.IsZero() && !now.Before(k.Retires)
+}
+
+// MarshalJSON encodes a symmetric key as base64.
+func (key SymmetricKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.StdEncoding.EncodeToString(key[:]))
+}
+
+// UnmarshalJSON decodes a symmetric key from base64.
+func (key *SymmetricKey) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(b) != keySize {
+		return errors.Errorf("key must be %d bytes, not %d", keySize, len(b))
+	}
+	copy(key[:], b)
+
+	return nil
+}
+
+// Keyring is a set of signing keys. Tokens are signed with the active key and
+// carry its ID in their kid header, while every key that hasn't been retired
+// is accepted when verifying tokens. This allows keys to be rotated without
+// invalidating the tokens that were already issued.
+type Keyring struct {
+	Active string `json:"active"`
+	Keys   []Key  `json:"keys"`
+
+	// Now returns the current time, which is used to decide which keys are
+	// retired. The local time is used if it isn't set.
+	Now func() time.Time `json:"-"`
+
+	mutex sync.RWMutex
+}
+
+// NewKeyring creates a keyring with a single new active key that signs with
+// the given algorithm.
+func NewKeyring(now time.Time, algorithm string) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.Rotate(now, 0, algorithm); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// ParseKeyring decodes a keyring from JSON, and checks that it has an active
+// key and that every key has what it needs to sign.
+func ParseKeyring(data []byte) (*Keyring, error) {
+	k := &Keyring{}
+	if err := json.Unmarshal(data, k); err != nil {
+		return nil, errors.Errorf("failed to decode keyring: %w", err)
+	}
+
+	if _, ok := k.key(k.Active); !ok {
+		return nil, errors.Errorf("%w: the active key %q isn't in the keyring", ErrKeyNotFound, k.Active)
+	}
+
+	for _, key := range k.Keys {
+		switch {
+		case key.algorithm() == HS256 && key.Secret != (SymmetricKey{}) && key.Private.PrivateKey == nil:
+		case key.algorithm() == ES256 && key.Private.PrivateKey != nil && key.Secret == (SymmetricKey{}):
+		default:
+			// A key without its secret would sign with all zeros, which
+			// anybody could forge tokens with.
+			return nil, errors.Errorf("key %q must be either an HS256 key with only a secret or an ES256 key with only a private key", key.ID)
+		}
+	}
+
+	return k, nil
+}
+
+func (k *Keyring) now() time.Time {
+	if k.Now == nil {
+		return time.Now()
+	}
+	return k.Now()
+}
+
+// key returns the key with the given ID. The caller must hold the mutex.
+func (k *Keyring) key(id string) (Key, bool) {
+	for _, key := range k.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Rotate adds a new key that signs with the given algorithm to the keyring
+// and makes it the active key. Every other key is retired once the grace
+// period has passed, and keys that are already retired are removed.
+func (k *Keyring) Rotate(now time.Time, grace time.Duration, algorithm string) error {
+	active := Key{Algorithm: algorithm, Created: now}
+
+	var err error
+	switch algorithm {
+	case HS256:
+		active.Secret, err = GenerateSymmetricKey()
+	case ES256:
+		active.Private, err = GenerateECKey()
+	default:
+		return errors.Errorf("unsupported algorithm %s, expected %s or %s", algorithm, HS256, ES256)
+	}
+	if err != nil {
+		return errors.Errorf("failed to generate key: %w", err)
+	}
+
+	id, err := ReadBytes(8)
+	if err != nil {
+		return errors.Errorf("failed to generate key ID: %w", err)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	retires := now.Add(grace)
+
+	keys := []Key{}
+	for _, key := range k.Keys {
+		if key.retired(now) {
+			continue
+		}
+		if key.Retires.IsZero() || key.Retires.After(retires) {
+			key.Retires = retires
+		}
+		keys = append(keys, key)
+	}
+
+	active.ID = base64.RawURLEncoding.EncodeToString(id)
+
+	k.Keys = append(keys, active)
+	k.Active = active.ID
+
+	return nil
+}
+
+// Sign creates a new JWT from a set of claims with the active key.
+func (k *Keyring) Sign(claims Claims, now time.Time, lifetime time.Duration) (JWT, error) {
+	k.mutex.RLock()
+	key, ok := k.key(k.Active)
+	k.mutex.RUnlock()
+
+	if !ok {
+		return "", errors.Errorf("%w: there is no active key", ErrKeyNotFound)
+	}
+
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	claims.Expiry = now.Add(lifetime).Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Errorf("failed to sign claims: %w", err)
+	}
+
+	token, err := jose.SignBytes(payload, key.algorithm(), key.signingKey(), jose.Header("kid", key.ID))
+	return JWT(token), err
+}
+
+// PublicKeys returns the public keys of the ES256 keys in the keyring that
+// haven't been retired.
+func (k *Keyring) PublicKeys() JWKSet {
+	now := k.now()
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.Keys {
+		if key.algorithm() == ES256 && !key.retired(now) {
+			set.Keys = append(set.Keys, key.Private.JWK(key.ID))
+		}
+	}
+
+	return set
+}
+
+// Verify checks the signature of the token with the key named by its kid
+// header, which must not be retired.
+func (k *Keyring) Verify(token JWT) (Claims, error) {
+	now := k.now()
+
+	keyFor := func(headers map[string]interface{}, payload string) interface{} {
+		id, _ := headers["kid"].(string)
+
+		k.mutex.RLock()
+		key, ok := k.key(id)
+		k.mutex.RUnlock()
+
+		if !ok {
+			return errors.Errorf("%w: %q", ErrKeyNotFound, id)
+		}
+
+		// Verify that the token was signed using the algorithm of the key.
+		algo, ok := headers["alg"].(string)
+		if !ok || algo != key.algorithm() {
+			return errors.Note{
+				Type:   ErrJWTBadAlgo,
+				Detail: fmt.Sprintf("key %s only verifies the %s signature algorithm", id, key.algorithm()),
+			}.Wrap(errors.Errorf("unsupported algorithm %s", algo))
+		}
+
+		if key.retired(now) {
+			return errors.Errorf("%w: %q", ErrKeyRetired, id)
+		}
+
+		return key.verifyingKey()
+	}
+
+	authJSON, _, err := jose.DecodeBytes(string(token), keyFor)
+	if err != nil {
+		return Claims{}, errors.Note{
+			Type: ErrJWTDecode,
+		}.Wrap(err)
+	}
+
+	var claims = Claims{}
+	if err = json.Unmarshal(authJSON, &claims); err != nil {
+		return Claims{}, err
+	}
+
+	return claims, nil
+}
+
+// MarshalJSON encodes the keyring so that it can be stored.
+func (k *Keyring) MarshalJSON() ([]byte, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return json.Marshal(struct {
+		Active string `json:"active"`
+		Keys   []Key  `json:"keys"`
+	}{k.Active, k.Keys})
+}
+
+// KeyringFile is a keyring stored in a file. The file is read again whenever
+// it is modified, so that keys rotated while the server is running are used
+// without restarting it.
+type KeyringFile struct {
+	Path string
+	// Now is passed on to the keyring read from the file.
+	Now func() time.Time
+
+	mutex   sync.Mutex
+	keyring *Keyring
+	modTime time.Time
+}
+
+// current returns the keyring as it is in the file. If the file was modified
+// but can't be read, the keyring that was last read is returned instead.
+func (f *KeyringFile) current() (*Keyring, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err == nil && f.keyring != nil && info.ModTime().Equal(f.modTime) {
+		return f.keyring, nil
+	}
+	if err != nil {
+		if f.keyring != nil {
+			return f.keyring, nil
+		}
+		return nil, err
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err == nil {
+		var keyring *Keyring
+		keyring, err = ParseKeyring(data)
+		if err == nil {
+			keyring.Now = f.Now
+			f.keyring = keyring
+			f.modTime = info.ModTime()
+		}
+	}
+	if f.keyring == nil {
+		return nil, err
+	}
+
+	return f.keyring, nil
+}
+
+// Load reads the keyring from the file, returning an error if it can't be.
+func (f *KeyringFile) Load() error {
+	_, err := f.current()
+	return err
+}
+
+// Sign creates a new JWT from a set of claims with the active key of the
+// keyring in the file.
+func (f *KeyringFile) Sign(claims Claims, now time.Time, lifetime time.Duration) (JWT, error) {
+	keyring, err := f.current()
+	if err != nil {
+		return "", err
+	}
+	return keyring.Sign(claims, now, lifetime)
+}
+
+// PublicKeys returns the public keys of the keyring in the file.
+func (f *KeyringFile) PublicKeys() JWKSet {
+	keyring, err := f.current()
+	if err != nil {
+		return JWKSet{Keys: []JWK{}}
+	}
+	return keyring.PublicKeys()
+}
+
+// Verify checks the signature of the token with the keyring in the file.
+func (f *KeyringFile) Verify(token JWT) (Claims, error) {
+	keyring, err := f.current()
+	if err != nil {
+		return Claims{}, err
+	}
+	return keyring.Verify(token)
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/clock"
+)
+
+// Limiter slows down attempts to guess passwords. Failed attempts are counted
+// against keys, such as the address of a client or the username it tried.
+// Once a key has failed more than Free times in a row, it is locked out for a
+// delay that doubles with every failure after that, up to Max. A key is
+// forgotten once Forget has passed since its last failure.
+type Limiter struct {
+	Clock clock.Provider
+
+	Free   int
+	Base   time.Duration
+	Max    time.Duration
+	Forget time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]limiterEntry
+}
+
+// limiterEntry is the failures counted against a key.
+type limiterEntry struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// maxLimiterEntries is the most keys that are kept. Once there are this many,
+// the keys that aren't locked out are removed, and then the keys that failed
+// longest ago, so that guessing many usernames can't grow the limiter without
+// bound.
+const maxLimiterEntries = 10000
+
+// NewLimiter creates a limiter that allows five failures, then locks keys out
+// for a second, doubling up to fifteen minutes. Keys are forgotten after a day
+// without failures.
+func NewLimiter(clock clock.Provider) *Limiter {
+	return &Limiter{
+		Clock:  clock,
+		Free:   5,
+		Base:   time.Second,
+		Max:    15 * time.Minute,
+		Forget: 24 * time.Hour,
+	}
+}
+
+// entry returns the failures of a key at the given time. The caller must hold
+// the mutex.
+func (l *Limiter) entry(key string, now time.Time) limiterEntry {
+	e := l.entries[key]
+	if now.Sub(e.last) >= l.Forget {
+		return limiterEntry{}
+	}
+	return e
+}
+
+// Allow returns zero if none of the keys are locked out, or how long until
+// every one of them is allowed again.
+func (l *Limiter) Allow(keys ...string) time.Duration {
+	now := l.Clock.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if d := l.entry(key, now).until.Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
+
+// Fail counts a failed attempt against each of the keys, and returns how long
+// the keys are now locked out for, which is zero while they have failures
+// left.
+func (l *Limiter) Fail(keys ...string) time.Duration {
+	now := l.Clock.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.entries == nil {
+		l.entries = make(map[string]limiterEntry)
+	}
+	if len(l.entries) >= maxLimiterEntries {
+		l.prune(now)
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		e := l.entry(key, now)
+		e.failures++
+		e.last = now
+
+		if over := e.failures - l.Free; over > 0 {
+			delay := l.Max
+			if over <= 32 && l.Base<<(over-1) < l.Max {
+				delay = l.Base << (over - 1)
+			}
+			e.until = now.Add(delay)
+			if delay > wait {
+				wait = delay
+			}
+		}
+
+		l.entries[key] = e
+	}
+
+	return wait
+}
+
+// prune removes the keys that aren't locked out at the given time, and then
+// the oldest keys until only half of the most keys are left. The caller must
+// hold the mutex.
+func (l *Limiter) prune(now time.Time) {
+	for key, e := range l.entries {
+		if !now.Before(e.until) {
+			delete(l.entries, key)
+		}
+	}
+
+	if len(l.entries) < maxLimiterEntries/2 {
+		return
+	}
+
+	keys := make([]string, 0, len(l.entries))
+	for key := range l.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i int, j int) bool {
+		return l.entries[keys[i]].last.Before(l.entries[keys[j]].last)
+	})
+
+	for _, key := range keys[:len(keys)-maxLimiterEntries/2] {
+		delete(l.entries, key)
+	}
+}
+
+// Len returns how many keys failures are counted against.
+func (l *Limiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.entries)
+}
+
+// Succeed forgets the failures of each of the keys.
+func (l *Limiter) Succeed(keys ...string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+}
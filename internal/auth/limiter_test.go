@@ -0,0 +1,82 @@
+package auth_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := auth.NewLimiter(tests.MockClock(now))
+	limiter.Free = 2
+	limiter.Max = 5 * time.Second
+
+	testCases := []struct {
+		name string
+		// after is how long after the previous attempt this attempt is made.
+		after time.Duration
+
+		wantAllow time.Duration
+		wantFail  time.Duration
+	}{
+		{name: "First", wantFail: 0},
+		{name: "Second", wantFail: 0},
+		{name: "Third", wantFail: time.Second},
+		{name: "LockedOut", after: time.Second / 2, wantAllow: time.Second / 2, wantFail: 2 * time.Second},
+		{name: "Doubled", after: 2 * time.Second, wantFail: 4 * time.Second},
+		{name: "Capped", after: 4 * time.Second, wantFail: 5 * time.Second},
+		{name: "Forgotten", after: limiter.Forget, wantFail: 0},
+	}
+
+	for _, tc := range testCases {
+		now = now.Add(tc.after)
+		limiter.Clock = tests.MockClock(now)
+
+		if got, want := limiter.Allow("ip:shire", "user:samwise"), tc.wantAllow; got != want {
+			t.Fatalf("%s: got allowed after %v, want %v", tc.name, got, want)
+		}
+		if got, want := limiter.Fail("ip:shire", "user:samwise"), tc.wantFail; got != want {
+			t.Fatalf("%s: got locked out for %v, want %v", tc.name, got, want)
+		}
+	}
+
+	// Succeeding forgets the failures of a key, but not others.
+	limiter.Fail("ip:shire", "user:samwise")
+	limiter.Fail("ip:shire", "user:samwise")
+	limiter.Succeed("user:samwise")
+
+	if got := limiter.Allow("user:samwise"); got != 0 {
+		t.Fatalf("got user locked out for %v after succeeding, want 0", got)
+	}
+	if got := limiter.Allow("ip:shire"); got == 0 {
+		t.Fatalf("got address allowed after succeeding, want it locked out")
+	}
+}
+
+func TestLimiterBound(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := auth.NewLimiter(tests.MockClock(now))
+	limiter.Free = 1
+
+	limiter.Fail("ip:mordor")
+	if limiter.Fail("ip:mordor") == 0 {
+		t.Fatalf("got address allowed, want it locked out")
+	}
+
+	// Guessing a new username every time doesn't grow the limiter without
+	// bound, or forget the keys that are locked out.
+	for i := 0; i < 50000; i++ {
+		limiter.Fail("user:" + strconv.Itoa(i))
+	}
+
+	if got, max := limiter.Len(), 10000; got > max {
+		t.Fatalf("got %d keys, want at most %d", got, max)
+	}
+	if got := limiter.Allow("ip:mordor"); got == 0 {
+		t.Fatalf("got address allowed after pruning, want it locked out")
+	}
+}
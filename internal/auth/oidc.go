@@ -0,0 +1,465 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+const (
+	ErrOIDCProvider = errors.Type("oidc-provider")
+	ErrOIDCState    = errors.Type("oidc-invalid-state")
+	ErrOIDCIDToken  = errors.Type("oidc-invalid-id-token")
+)
+
+// OIDCLoginDuration is how long a user has to log in at the provider.
+const OIDCLoginDuration = 10 * time.Minute
+
+// OIDCUserPrefix begins the username of every user who logs in with single
+// sign-on. Usernames in a user file can't contain colons, so a user of the
+// provider is never mistaken for a local user of the same name.
+const OIDCUserPrefix = "oidc:"
+
+// IsOIDCUser returns true if a username is of a user who logged in with single
+// sign-on, rather than one an Authenticator knows.
+func IsOIDCUser(username string) bool {
+	return strings.HasPrefix(username, OIDCUserPrefix)
+}
+
+const (
+	// oidcKeysInterval is how often the keys of the provider are fetched
+	// again when a token is signed with a key that isn't known.
+	oidcKeysInterval = time.Minute
+	// oidcMaxPending is how many logins can be in progress at once.
+	oidcMaxPending = 10000
+	// oidcClockSkew is how far the clock of the provider may differ.
+	oidcClockSkew = time.Minute
+)
+
+// OIDCConfig configures logging in with an OpenID Connect provider.
+type OIDCConfig struct {
+	// Issuer is the URL of the provider, which serves its configuration from
+	// /.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends users back to once they have
+	// logged in.
+	RedirectURL string
+	// Scopes are requested along with openid. By default they are email and
+	// profile, since providers differ in the scope that grants groups.
+	Scopes []string
+	// UsernameClaim names the claim of the ID token users are known by,
+	// which is email by default.
+	UsernameClaim string
+	// GroupsClaim names the claim of the ID token listing the groups of the
+	// user, which is groups by default.
+	GroupsClaim string
+	// Roles are the roles given to the users in each group. Users are only
+	// given the roles of their groups.
+	Roles map[string][]string
+}
+
+// oidcProvider is the configuration of a provider, from its discovery
+// document.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcPending is a login that was sent to the provider and hasn't returned.
+type oidcPending struct {
+	verifier string
+	nonce    string
+	next     string
+	expires  time.Time
+}
+
+// OIDC logs users in with an OpenID Connect provider, using the
+// authorization code flow with PKCE. The claims of the ID token the provider
+// returns are mapped into the claims of the user.
+type OIDC struct {
+	config OIDCConfig
+	client *http.Client
+
+	mutex     sync.Mutex
+	provider  *oidcProvider
+	keys      map[string]JWK
+	fetchedAt time.Time
+	pending   map[string]oidcPending
+}
+
+// NewOIDC creates a relying party for the configured provider. The provider
+// is only contacted once the first user logs in. If client is nil,
+// http.DefaultClient is used.
+func NewOIDC(config OIDCConfig, client *http.Client) (*OIDC, error) {
+	switch {
+	case config.Issuer == "":
+		return nil, errors.New("the issuer of the provider is required")
+	case config.ClientID == "":
+		return nil, errors.New("the client ID is required")
+	case config.RedirectURL == "":
+		return nil, errors.New("the redirect URL is required")
+	}
+
+	if config.Scopes == nil {
+		config.Scopes = []string{"email", "profile"}
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "email"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &OIDC{
+		config:  config,
+		client:  client,
+		pending: make(map[string]oidcPending),
+	}, nil
+}
+
+// getJSON decodes the JSON response of a GET request.
+func (o *OIDC) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("%s responded with %s", u, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// discover returns the configuration of the provider, fetching it the first
+// time it is needed.
+func (o *OIDC) discover(ctx context.Context) (oidcProvider, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.provider != nil {
+		return *o.provider, nil
+	}
+
+	var p oidcProvider
+	u := strings.TrimSuffix(o.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := o.getJSON(ctx, u, &p); err != nil {
+		return oidcProvider{}, errors.Errorf("%w: couldn't discover provider: %w", ErrOIDCProvider, err)
+	}
+
+	if p.Issuer != o.config.Issuer {
+		return oidcProvider{}, errors.Errorf("%w: provider has issuer %q, expected %q", ErrOIDCProvider, p.Issuer, o.config.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return oidcProvider{}, errors.Errorf("%w: provider configuration is missing endpoints", ErrOIDCProvider)
+	}
+
+	o.provider = &p
+
+	return p, nil
+}
+
+// key returns the key of the provider with an ID. The keys are fetched again
+// if the key isn't known, unless they were just fetched.
+func (o *OIDC) key(ctx context.Context, jwksURI, id string, now time.Time) (JWK, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if key, ok := o.keys[id]; ok {
+		return key, nil
+	}
+
+	if now.Sub(o.fetchedAt) < oidcKeysInterval {
+		return JWK{}, errors.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+
+	var set JWKSet
+	if err := o.getJSON(ctx, jwksURI, &set); err != nil {
+		return JWK{}, errors.Errorf("%w: couldn't fetch keys: %w", ErrOIDCProvider, err)
+	}
+
+	o.fetchedAt = now
+	o.keys = make(map[string]JWK, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use == "" || key.Use == "sig" {
+			o.keys[key.ID] = key
+		}
+	}
+
+	key, ok := o.keys[id]
+	if !ok {
+		return JWK{}, errors.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+
+	return key, nil
+}
+
+// randomString returns size random bytes encoded for use in a URL.
+func randomString(size int) (string, error) {
+	b, err := ReadBytes(size)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL starts logging in a user, and returns the URL of the provider
+// to send them to along with the state that identifies the login. Next is
+// returned by Exchange once the user comes back.
+func (o *OIDC) AuthCodeURL(ctx context.Context, next string, now time.Time) (string, string, error) {
+	p, err := o.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	o.mutex.Lock()
+	for s, pending := range o.pending {
+		if !now.Before(pending.expires) {
+			delete(o.pending, s)
+		}
+	}
+	if len(o.pending) >= oidcMaxPending {
+		o.mutex.Unlock()
+		return "", "", errors.Errorf("%w: too many logins in progress", ErrOIDCState)
+	}
+	o.pending[state] = oidcPending{
+		verifier: verifier,
+		nonce:    nonce,
+		next:     next,
+		expires:  now.Add(OIDCLoginDuration),
+	}
+	o.mutex.Unlock()
+
+	u, err := url.Parse(p.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", errors.Errorf("%w: %w", ErrOIDCProvider, err)
+	}
+
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", o.config.ClientID)
+	query.Set("redirect_uri", o.config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, o.config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+
+	return u.String(), state, nil
+}
+
+// Exchange finishes logging in a user who came back from the provider with a
+// code, and returns their claims along with the next value given to
+// AuthCodeURL. Each state can only be exchanged once.
+func (o *OIDC) Exchange(ctx context.Context, state, code string, now time.Time) (Claims, string, error) {
+	o.mutex.Lock()
+	pending, ok := o.pending[state]
+	delete(o.pending, state)
+	o.mutex.Unlock()
+
+	if !ok || !now.Before(pending.expires) {
+		return Claims{}, "", errors.Errorf("%w: the login expired or was already used", ErrOIDCState)
+	}
+
+	p, err := o.discover(ctx)
+	if err != nil {
+		return Claims{}, "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.config.RedirectURL},
+		"code_verifier": {pending.verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return Claims{}, "", errors.Errorf("%w: couldn't exchange code: %w", ErrOIDCProvider, err)
+	}
+	defer res.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tokens); err != nil {
+		return Claims{}, "", errors.Errorf("%w: couldn't decode tokens: %w", ErrOIDCProvider, err)
+	}
+	if res.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return Claims{}, "", errors.Errorf("%w: couldn't exchange code: %s %s %s", ErrOIDCProvider, res.Status, tokens.Error, tokens.ErrorDescription)
+	}
+
+	idClaims, err := o.verifyIDToken(ctx, p, tokens.IDToken, pending.nonce, now)
+	if err != nil {
+		return Claims{}, "", err
+	}
+
+	claims, err := o.mapClaims(idClaims)
+	if err != nil {
+		return Claims{}, "", err
+	}
+
+	return claims, pending.next, nil
+}
+
+// verifyIDToken checks the signature of an ID token with the keys of the
+// provider, and that it was issued by the provider to this client for the
+// login with the nonce. It returns the claims of the token.
+func (o *OIDC) verifyIDToken(ctx context.Context, p oidcProvider, token, nonce string, now time.Time) (map[string]any, error) {
+	keyFor := func(headers map[string]interface{}, payload string) interface{} {
+		algo, _ := headers["alg"].(string)
+		id, _ := headers["kid"].(string)
+
+		key, err := o.key(ctx, p.JWKSURI, id, now)
+		if err != nil {
+			return err
+		}
+
+		// Verify that the token was signed using the algorithm of the key.
+		if !(algo == RS256 && key.KeyType == "RSA") && !(algo == ES256 && key.KeyType == "EC") {
+			return errors.Note{
+				Type:   ErrJWTBadAlgo,
+				Detail: fmt.Sprintf("key %s doesn't verify the %s signature algorithm", id, algo),
+			}.Wrap(errors.Errorf("unsupported algorithm %s", algo))
+		}
+
+		public, err := key.PublicKey()
+		if err != nil {
+			return err
+		}
+
+		return public
+	}
+
+	payload, _, err := jose.DecodeBytes(token, keyFor)
+	if err != nil {
+		return nil, errors.Errorf("%w: %w", ErrOIDCIDToken, err)
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Errorf("%w: %w", ErrOIDCIDToken, err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return nil, errors.Errorf("%w: issued by %q", ErrOIDCIDToken, iss)
+	}
+
+	audience := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = aud == o.config.ClientID
+	case []any:
+		for _, a := range aud {
+			audience = audience || a == o.config.ClientID
+		}
+		if azp, ok := claims["azp"]; ok && len(aud) > 1 && azp != o.config.ClientID {
+			audience = false
+		}
+	}
+	if !audience {
+		return nil, errors.Errorf("%w: not issued to this client", ErrOIDCIDToken)
+	}
+
+	exp, _ := claims["exp"].(float64)
+	if !now.Before(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.Errorf("%w: expired", ErrOIDCIDToken)
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.Errorf("%w: issued for another login", ErrOIDCIDToken)
+	}
+
+	return claims, nil
+}
+
+// mapClaims returns the claims of the user of an ID token. The user is named
+// by the username claim after OIDCUserPrefix, and given the roles of their
+// groups.
+func (o *OIDC) mapClaims(idClaims map[string]any) (Claims, error) {
+	username, _ := idClaims[o.config.UsernameClaim].(string)
+	if username == "" {
+		return Claims{}, errors.Errorf("%w: no %s claim", ErrOIDCIDToken, o.config.UsernameClaim)
+	}
+
+	// Anyone can claim an email address they haven't verified.
+	if verified, ok := idClaims["email_verified"].(bool); o.config.UsernameClaim == "email" && ok && !verified {
+		return Claims{}, errors.Errorf("%w: email %s isn't verified", ErrOIDCIDToken, username)
+	}
+
+	claims := Claims{Username: OIDCUserPrefix + username, Roles: []string{}}
+	claims.Name, _ = idClaims["name"].(string)
+
+	var groups []string
+	switch g := idClaims[o.config.GroupsClaim].(type) {
+	case string:
+		groups = []string{g}
+	case []any:
+		for _, group := range g {
+			if s, ok := group.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+
+	given := make(map[string]bool)
+	for _, group := range groups {
+		for _, role := range o.config.Roles[group] {
+			if !given[role] {
+				given[role] = true
+				claims.Roles = append(claims.Roles, role)
+			}
+		}
+	}
+	claims.Admin = given[RoleAdmin]
+
+	return claims, nil
+}
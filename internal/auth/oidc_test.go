@@ -0,0 +1,204 @@
+package auth_test
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func TestOIDC(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name      string
+		algorithm string
+		claims    map[string]any
+		state     string
+
+		wantClaims auth.Claims
+		wantErr    error
+	}{
+		{
+			name:      "RS256",
+			algorithm: auth.RS256,
+			claims:    map[string]any{"email": "samwise@shire.test", "name": "Samwise", "groups": []string{"gardeners"}},
+			wantClaims: auth.Claims{
+				Username: "oidc:samwise@shire.test",
+				Name:     "Samwise",
+				Roles:    []string{},
+			},
+		},
+		{
+			name:      "ES256",
+			algorithm: auth.ES256,
+			claims:    map[string]any{"email": "samwise@shire.test"},
+			wantClaims: auth.Claims{
+				Username: "oidc:samwise@shire.test",
+				Roles:    []string{},
+			},
+		},
+		{
+			name:   "GroupRoles",
+			claims: map[string]any{"email": "frodo@shire.test", "email_verified": true, "groups": []string{"fellowship", "bearers"}},
+			wantClaims: auth.Claims{
+				Username: "oidc:frodo@shire.test",
+				Roles:    []string{auth.RoleEditor, "ring", auth.RoleAdmin},
+				Admin:    true,
+			},
+		},
+		{
+			name:    "UnverifiedEmail",
+			claims:  map[string]any{"email": "sauron@mordor.test", "email_verified": false, "groups": []string{"bearers"}},
+			wantErr: auth.ErrOIDCIDToken,
+		},
+		{
+			name:    "NoEmail",
+			claims:  map[string]any{"groups": []string{"bearers"}},
+			wantErr: auth.ErrOIDCIDToken,
+		},
+		{
+			name:    "WrongIssuer",
+			claims:  map[string]any{"email": "samwise@shire.test", "iss": "https://mordor.test"},
+			wantErr: auth.ErrOIDCIDToken,
+		},
+		{
+			name:    "WrongAudience",
+			claims:  map[string]any{"email": "samwise@shire.test", "aud": []string{"saruman"}},
+			wantErr: auth.ErrOIDCIDToken,
+		},
+		{
+			name:    "WrongNonce",
+			claims:  map[string]any{"email": "samwise@shire.test", "nonce": "bananas"},
+			wantErr: auth.ErrOIDCIDToken,
+		},
+		{
+			name:    "Expired",
+			claims:  map[string]any{"email": "samwise@shire.test", "exp": now.Add(-time.Hour).Unix()},
+			wantErr: auth.ErrOIDCIDToken,
+		},
+		{
+			name:    "UnknownState",
+			claims:  map[string]any{"email": "samwise@shire.test"},
+			state:   "bananas",
+			wantErr: auth.ErrOIDCState,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			provider, err := tests.NewMockOIDCProvider("bastion", "potatoes")
+			if err != nil {
+				t.Fatalf("failed to start provider: %v", err)
+			}
+			defer provider.Close()
+
+			if tc.algorithm != "" {
+				provider.Algorithm = tc.algorithm
+			}
+			provider.Claims = tc.claims
+			provider.Now = now
+
+			oidc, err := auth.NewOIDC(auth.OIDCConfig{
+				Issuer:       provider.URL,
+				ClientID:     "bastion",
+				ClientSecret: "potatoes",
+				RedirectURL:  "https://www.test.com/.auth/oidc/callback",
+				Roles: map[string][]string{
+					"fellowship": {auth.RoleEditor},
+					"bearers":    {"ring", auth.RoleAdmin, auth.RoleEditor},
+				},
+			}, provider.Client())
+			if err != nil {
+				t.Fatalf("failed to create relying party: %v", err)
+			}
+
+			authURL, state, err := oidc.AuthCodeURL(context.Background(), "/hello", now)
+			if err != nil {
+				t.Fatalf("failed to start login: %v", err)
+			}
+			if !strings.HasPrefix(authURL, provider.URL+"/authorize?") {
+				t.Fatalf("got login at %s, want the provider", authURL)
+			}
+
+			redirect, err := provider.Authorize(authURL)
+			if err != nil {
+				t.Fatalf("failed to log in at provider: %v", err)
+			}
+			u, err := url.Parse(redirect)
+			if err != nil {
+				t.Fatalf("failed to parse redirect: %v", err)
+			}
+			if got, want := u.Query().Get("state"), state; got != want {
+				t.Fatalf("got state %s back, want %s", got, want)
+			}
+			if tc.state != "" {
+				state = tc.state
+			}
+
+			claims, next, err := oidc.Exchange(context.Background(), state, u.Query().Get("code"), now)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got err %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to exchange code: %v", err)
+			}
+
+			if got, want := next, "/hello"; got != want {
+				t.Fatalf("got next %s, want %s", got, want)
+			}
+			if got, want := claims, tc.wantClaims; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got claims %+v, want %+v", got, want)
+			}
+
+			// Each login can only be finished once.
+			if _, _, err := oidc.Exchange(context.Background(), state, u.Query().Get("code"), now); !errors.Is(err, auth.ErrOIDCState) {
+				t.Fatalf("got err %v exchanging again, want %v", err, auth.ErrOIDCState)
+			}
+		})
+	}
+}
+
+func TestOIDCWrongClientSecret(t *testing.T) {
+	now := time.Now()
+
+	provider, err := tests.NewMockOIDCProvider("bastion", "potatoes")
+	if err != nil {
+		t.Fatalf("failed to start provider: %v", err)
+	}
+	defer provider.Close()
+
+	oidc, err := auth.NewOIDC(auth.OIDCConfig{
+		Issuer:       provider.URL,
+		ClientID:     "bastion",
+		ClientSecret: "fireworks",
+		RedirectURL:  "https://www.test.com/.auth/oidc/callback",
+	}, provider.Client())
+	if err != nil {
+		t.Fatalf("failed to create relying party: %v", err)
+	}
+
+	authURL, state, err := oidc.AuthCodeURL(context.Background(), "/", now)
+	if err != nil {
+		t.Fatalf("failed to start login: %v", err)
+	}
+	redirect, err := provider.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("failed to log in at provider: %v", err)
+	}
+	u, _ := url.Parse(redirect)
+
+	if _, _, err := oidc.Exchange(context.Background(), state, u.Query().Get("code"), now); !errors.Is(err, auth.ErrOIDCProvider) {
+		t.Fatalf("got err %v, want %v", err, auth.ErrOIDCProvider)
+	}
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/files"
+)
+
+const (
+	ErrRefreshTokenNotFound = errors.Type("refresh-token-not-found")
+	ErrRefreshTokenExpired  = errors.Type("refresh-token-expired")
+	ErrRefreshTokenReused   = errors.Type("refresh-token-reused")
+)
+
+// RefreshStore keeps the refresh tokens issued to users. Every token belongs
+// to a family, which begins when a user logs in. Using a token replaces it
+// with a new token in the same family, so a token that is used twice has been
+// stolen, and the whole family is revoked.
+type RefreshStore interface {
+	// Issue creates a refresh token for the claims that expires after the
+	// lifetime, starting a new family.
+	Issue(claims Claims, now time.Time, lifetime time.Duration) (BearerToken, error)
+	// Rotate exchanges a refresh token for a new one in the same family,
+	// which expires with the rest of its family.
+	Rotate(token BearerToken, now time.Time) (BearerToken, Claims, error)
+	// Revoke revokes every token in the family of a token.
+	Revoke(token BearerToken) error
+	// RevokeUser revokes every token issued to a user, and returns how many
+	// families were revoked.
+	RevokeUser(username string) (int, error)
+	// Sweep removes the tokens that have expired.
+	Sweep(now time.Time) error
+}
+
+// refreshFamily is what is kept of a family of refresh tokens. Only the hash
+// of the latest token of the family is kept, since every other token of the
+// family has been used. Tokens carry the ID of their family, so a token that
+// is used again is recognized as soon as it doesn't match the latest token.
+type refreshFamily struct {
+	Claims  Claims    `json:"claims"`
+	Expires time.Time `json:"expires"`
+	Token   string    `json:"token"`
+}
+
+// refreshStore is a RefreshStore kept in memory, and saved to a file after
+// every change if it has a path.
+type refreshStore struct {
+	path string
+
+	mutex    sync.Mutex
+	families map[string]refreshFamily
+}
+
+// NewMemoryRefreshStore creates a RefreshStore that is only kept in memory, so
+// every token is revoked when the process stops.
+func NewMemoryRefreshStore() RefreshStore {
+	return &refreshStore{families: make(map[string]refreshFamily)}
+}
+
+// NewFileRefreshStore creates a RefreshStore kept in a file, so tokens remain
+// valid after a restart. The file is created if it doesn't exist.
+func NewFileRefreshStore(path string) (RefreshStore, error) {
+	s := &refreshStore{path: path, families: make(map[string]refreshFamily)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, s.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.families); err != nil {
+		return nil, errors.Errorf("failed to decode refresh tokens: %w", err)
+	}
+
+	return s, nil
+}
+
+// hashToken returns the key a token is kept under.
+func hashToken(token BearerToken) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes the records to the file of the store, if it has one. The caller
+// must hold the mutex.
+func (s *refreshStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.families)
+	if err != nil {
+		return err
+	}
+
+	return files.WriteFile(s.path, data, 0600)
+}
+
+// next creates the next token of a family, which replaces every token the
+// family had. The caller must hold the mutex.
+func (s *refreshStore) next(id string, family refreshFamily) (BearerToken, error) {
+	secret, err := NewBearerToken()
+	if err != nil {
+		return "", err
+	}
+
+	token := BearerToken(id + "." + string(secret))
+	family.Token = hashToken(token)
+	s.families[id] = family
+
+	return token, nil
+}
+
+func (s *refreshStore) Issue(claims Claims, now time.Time, lifetime time.Duration) (BearerToken, error) {
+	id, err := NewBearerToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, err := s.next(string(id), refreshFamily{
+		Claims:  claims,
+		Expires: now.Add(lifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, s.save()
+}
+
+func (s *refreshStore) Rotate(token BearerToken, now time.Time) (BearerToken, Claims, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id, _, _ := strings.Cut(string(token), ".")
+
+	family, ok := s.families[id]
+	switch {
+	case !ok:
+		return "", Claims{}, ErrRefreshTokenNotFound
+	case !now.Before(family.Expires):
+		delete(s.families, id)
+		if err := s.save(); err != nil {
+			return "", Claims{}, err
+		}
+		return "", Claims{}, errors.Errorf("%w: %s", ErrRefreshTokenExpired, family.Expires.Format(time.RFC3339))
+	case subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(family.Token)) != 1:
+		delete(s.families, id)
+		if err := s.save(); err != nil {
+			return "", Claims{}, err
+		}
+		return "", Claims{}, errors.Errorf("%w: revoked every token issued to %q since they logged in", ErrRefreshTokenReused, family.Claims.Username)
+	}
+
+	next, err := s.next(id, family)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	return next, family.Claims, s.save()
+}
+
+func (s *refreshStore) Revoke(token BearerToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id, _, _ := strings.Cut(string(token), ".")
+
+	family, ok := s.families[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(family.Token)) != 1 {
+		return ErrRefreshTokenNotFound
+	}
+
+	delete(s.families, id)
+
+	return s.save()
+}
+
+func (s *refreshStore) RevokeUser(username string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n := 0
+	for id, family := range s.families {
+		if family.Claims.Username == username {
+			delete(s.families, id)
+			n++
+		}
+	}
+
+	return n, s.save()
+}
+
+func (s *refreshStore) Sweep(now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, family := range s.families {
+		if !now.Before(family.Expires) {
+			delete(s.families, id)
+		}
+	}
+
+	return s.save()
+}
@@ -0,0 +1,187 @@
+package auth_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+func TestRefreshStore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	claims := auth.Claims{Username: "samwise"}
+
+	stores := map[string]func(t *testing.T) auth.RefreshStore{
+		"Memory": func(t *testing.T) auth.RefreshStore {
+			return auth.NewMemoryRefreshStore()
+		},
+		"File": func(t *testing.T) auth.RefreshStore {
+			store, err := auth.NewFileRefreshStore(filepath.Join(t.TempDir(), "refresh.json"))
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		newStore := newStore
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			first, err := store.Issue(claims, now, time.Hour)
+			if err != nil {
+				t.Fatalf("failed to issue token: %v", err)
+			}
+
+			second, got, err := store.Rotate(first, now.Add(time.Minute))
+			if err != nil {
+				t.Fatalf("failed to rotate token: %v", err)
+			}
+			if got.Username != claims.Username {
+				t.Fatalf("got username %q, want %q", got.Username, claims.Username)
+			}
+
+			// Using a token again revokes its family.
+			third, _, err := store.Rotate(second, now.Add(time.Minute))
+			if err != nil {
+				t.Fatalf("failed to rotate token: %v", err)
+			}
+			if _, _, err := store.Rotate(first, now.Add(time.Minute)); !errors.Is(err, auth.ErrRefreshTokenReused) {
+				t.Fatalf("got error %v, want error %v", err, auth.ErrRefreshTokenReused)
+			}
+			if _, _, err := store.Rotate(third, now.Add(time.Minute)); !errors.Is(err, auth.ErrRefreshTokenNotFound) {
+				t.Fatalf("got error %v after reuse, want error %v", err, auth.ErrRefreshTokenNotFound)
+			}
+
+			second, err = store.Issue(claims, now, time.Hour)
+			if err != nil {
+				t.Fatalf("failed to issue token: %v", err)
+			}
+
+			// Rotating doesn't extend the lifetime of a family.
+			if _, _, err := store.Rotate(second, now.Add(time.Hour)); !errors.Is(err, auth.ErrRefreshTokenExpired) {
+				t.Fatalf("got error %v, want error %v", err, auth.ErrRefreshTokenExpired)
+			}
+
+			other, err := store.Issue(claims, now, time.Hour)
+			if err != nil {
+				t.Fatalf("failed to issue token: %v", err)
+			}
+			kept, err := store.Issue(auth.Claims{Username: "frodo"}, now, time.Hour)
+			if err != nil {
+				t.Fatalf("failed to issue token: %v", err)
+			}
+
+			n, err := store.RevokeUser(claims.Username)
+			if err != nil {
+				t.Fatalf("failed to revoke user: %v", err)
+			}
+			if n != 1 {
+				t.Fatalf("revoked %d families, want 1", n)
+			}
+			if _, _, err := store.Rotate(other, now); !errors.Is(err, auth.ErrRefreshTokenNotFound) {
+				t.Fatalf("got error %v, want error %v", err, auth.ErrRefreshTokenNotFound)
+			}
+
+			if err := store.Sweep(now.Add(time.Hour)); err != nil {
+				t.Fatalf("failed to sweep: %v", err)
+			}
+			if _, _, err := store.Rotate(kept, now); !errors.Is(err, auth.ErrRefreshTokenNotFound) {
+				t.Fatalf("got error %v for a swept token, want error %v", err, auth.ErrRefreshTokenNotFound)
+			}
+		})
+	}
+}
+
+func TestFileRefreshStorePersists(t *testing.T) {
+	now := time.Unix(1000, 0)
+	path := filepath.Join(t.TempDir(), "refresh.json")
+
+	store, err := auth.NewFileRefreshStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	token, err := store.Issue(auth.Claims{Username: "samwise"}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	reopened, err := auth.NewFileRefreshStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+
+	if _, _, err := reopened.Rotate(token, now); err != nil {
+		t.Fatalf("failed to rotate token after reopening the store: %v", err)
+	}
+}
+
+func TestFileRefreshStoreSize(t *testing.T) {
+	now := time.Unix(1000, 0)
+	path := filepath.Join(t.TempDir(), "refresh.json")
+
+	store, err := auth.NewFileRefreshStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	token, err := store.Issue(auth.Claims{Username: "samwise"}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat store: %v", err)
+	}
+	size := info.Size()
+
+	// Only the latest token of a family is kept, however often it rotates.
+	for i := 0; i < 100; i++ {
+		token, _, err = store.Rotate(token, now)
+		if err != nil {
+			t.Fatalf("failed to rotate token: %v", err)
+		}
+	}
+
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat store: %v", err)
+	}
+	if got, want := info.Size(), size; got != want {
+		t.Fatalf("got a store of %d bytes after rotating, want %d", got, want)
+	}
+}
+
+func TestFileRefreshStoreExpired(t *testing.T) {
+	now := time.Unix(1000, 0)
+	path := filepath.Join(t.TempDir(), "refresh.json")
+
+	store, err := auth.NewFileRefreshStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	token, err := store.Issue(auth.Claims{Username: "samwise"}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	if _, _, err := store.Rotate(token, now.Add(time.Hour)); !errors.Is(err, auth.ErrRefreshTokenExpired) {
+		t.Fatalf("got error %v, want error %v", err, auth.ErrRefreshTokenExpired)
+	}
+
+	// The expired family is removed from the file too.
+	reopened, err := auth.NewFileRefreshStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	if _, _, err := reopened.Rotate(token, now.Add(time.Hour)); !errors.Is(err, auth.ErrRefreshTokenNotFound) {
+		t.Fatalf("got error %v after reopening the store, want error %v", err, auth.ErrRefreshTokenNotFound)
+	}
+}
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/files"
+)
+
+const (
+	ErrSessionNotFound = errors.Type("session-not-found")
+	ErrSessionExpired  = errors.Type("session-expired")
+)
+
+// Session is a login from a browser, which is kept on the server so that it
+// can be listed and ended.
+type Session struct {
+	ID     string `json:"id"`
+	Claims Claims `json:"claims"`
+	// CSRFToken must be sent with every request that changes something, so
+	// that other sites can't make them on behalf of the user.
+	CSRFToken string    `json:"csrf_token"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	LastSeen  time.Time `json:"last_seen"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Address   string    `json:"address,omitempty"`
+}
+
+// CheckCSRFToken returns true if a token is the CSRF token of a session.
+func (s Session) CheckCSRFToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
+
+// SessionStore keeps the sessions of users logged in from a browser.
+type SessionStore interface {
+	// Create starts a session for the claims that expires after the
+	// lifetime.
+	Create(claims Claims, userAgent, address string, now time.Time, lifetime time.Duration) (Session, error)
+	// Get returns the session with an ID, and records that it was seen.
+	Get(id string, now time.Time) (Session, error)
+	// List returns the sessions of a user, newest first.
+	List(username string) ([]Session, error)
+	// Revoke ends the session with an ID.
+	Revoke(id string) error
+	// RevokeUser ends every session of a user, and returns how many were
+	// ended.
+	RevokeUser(username string) (int, error)
+	// Sweep removes the sessions that have expired.
+	Sweep(now time.Time) error
+}
+
+// sessionStore is a SessionStore kept in memory, and saved to a file after
+// every change if it has a path.
+type sessionStore struct {
+	path string
+
+	mutex    sync.Mutex
+	sessions map[string]Session
+	// saved is the time each session was last seen as of the last save.
+	saved map[string]time.Time
+}
+
+// NewMemorySessionStore creates a SessionStore that is only kept in memory, so
+// every session ends when the process stops.
+func NewMemorySessionStore() SessionStore {
+	return &sessionStore{sessions: make(map[string]Session)}
+}
+
+// NewFileSessionStore creates a SessionStore kept in a file, so sessions
+// continue after a restart. The file is created if it doesn't exist.
+func NewFileSessionStore(path string) (SessionStore, error) {
+	s := &sessionStore{path: path, sessions: make(map[string]Session)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, s.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.sessions); err != nil {
+		return nil, errors.Errorf("failed to decode sessions: %w", err)
+	}
+
+	return s, nil
+}
+
+// save writes the sessions to the file of the store, if it has one. The
+// caller must hold the mutex.
+func (s *sessionStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.sessions)
+	if err != nil {
+		return err
+	}
+
+	if err := files.WriteFile(s.path, data, 0600); err != nil {
+		return err
+	}
+
+	s.saved = make(map[string]time.Time, len(s.sessions))
+	for id, session := range s.sessions {
+		s.saved[id] = session.LastSeen
+	}
+
+	return nil
+}
+
+func (s *sessionStore) Create(claims Claims, userAgent, address string, now time.Time, lifetime time.Duration) (Session, error) {
+	id, err := ReadBytes(16)
+	if err != nil {
+		return Session{}, err
+	}
+	csrf, err := ReadBytes(32)
+	if err != nil {
+		return Session{}, err
+	}
+
+	claims.Audience = ""
+	claims.Expiry, claims.NotBefore, claims.IssuedAt = 0, 0, 0
+
+	session := Session{
+		ID:        hex.EncodeToString(id),
+		Claims:    claims,
+		CSRFToken: hex.EncodeToString(csrf),
+		Created:   now.UTC(),
+		Expires:   now.Add(lifetime).UTC(),
+		LastSeen:  now.UTC(),
+		UserAgent: userAgent,
+		Address:   address,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sessions[session.ID] = session
+
+	return session, s.save()
+}
+
+func (s *sessionStore) Get(id string, now time.Time) (Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[id]
+	switch {
+	case !ok:
+		return Session{}, ErrSessionNotFound
+	case !now.Before(session.Expires):
+		delete(s.sessions, id)
+		return Session{}, errors.Errorf("%w: %s", ErrSessionExpired, session.Expires.Format(time.RFC3339))
+	}
+
+	session.LastSeen = now.UTC()
+	s.sessions[id] = session
+
+	if now.Sub(s.saved[id]) >= lastUsedInterval {
+		if err := s.save(); err != nil {
+			return Session{}, err
+		}
+	}
+
+	return session, nil
+}
+
+func (s *sessionStore) List(username string) ([]Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := []Session{}
+	for _, session := range s.sessions {
+		if session.Claims.Username == username {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].Created.Equal(sessions[j].Created) {
+			return sessions[i].Created.After(sessions[j].Created)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (s *sessionStore) Revoke(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+
+	delete(s.sessions, id)
+
+	return s.save()
+}
+
+func (s *sessionStore) RevokeUser(username string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n := 0
+	for id, session := range s.sessions {
+		if session.Claims.Username == username {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+
+	return n, s.save()
+}
+
+func (s *sessionStore) Sweep(now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, session := range s.sessions {
+		if !now.Before(session.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+
+	return s.save()
+}
@@ -0,0 +1,84 @@
+package auth_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+func TestSessionStore(t *testing.T) {
+	now := time.Now()
+	claims := auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor}}
+
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	s, err := auth.NewFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("failed to create session store: %v", err)
+	}
+
+	first, err := s.Create(claims, "Firefox", "10.0.0.1", now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	second, err := s.Create(claims, "Chrome", "10.0.0.2", now.Add(time.Minute), time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if _, err := s.Create(auth.Claims{Username: "pippin"}, "", "", now, time.Hour); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	if first.CSRFToken == "" || first.CSRFToken == second.CSRFToken {
+		t.Fatalf("got CSRF tokens %q and %q, want different tokens", first.CSRFToken, second.CSRFToken)
+	}
+	if !first.CheckCSRFToken(first.CSRFToken) || first.CheckCSRFToken(second.CSRFToken) || first.CheckCSRFToken("") {
+		t.Fatalf("CSRF token of the session isn't the only one accepted")
+	}
+
+	// Sessions continue after a restart.
+	s, err = auth.NewFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("failed to open session store: %v", err)
+	}
+
+	got, err := s.Get(first.ID, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if got, want := got.LastSeen, now.Add(2*time.Minute).UTC(); !got.Equal(want) {
+		t.Fatalf("got last seen %v, want %v", got, want)
+	}
+
+	sessions, err := s.List("samwise")
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if got, want := len(sessions), 2; got != want {
+		t.Fatalf("got %d sessions, want %d", got, want)
+	}
+	if got, want := sessions[0].ID, second.ID; got != want {
+		t.Fatalf("got newest session %s, want %s", got, want)
+	}
+
+	if _, err := s.Get(first.ID, now.Add(time.Hour)); !errors.Is(err, auth.ErrSessionExpired) {
+		t.Fatalf("got err %v, want %v", err, auth.ErrSessionExpired)
+	}
+
+	if err := s.Revoke(second.ID); err != nil {
+		t.Fatalf("failed to revoke session: %v", err)
+	}
+	if _, err := s.Get(second.ID, now); !errors.Is(err, auth.ErrSessionNotFound) {
+		t.Fatalf("got err %v, want %v", err, auth.ErrSessionNotFound)
+	}
+
+	n, err := s.RevokeUser("pippin")
+	if err != nil {
+		t.Fatalf("failed to revoke sessions of user: %v", err)
+	}
+	if got, want := n, 1; got != want {
+		t.Fatalf("got %d sessions revoked, want %d", got, want)
+	}
+}
@@ -82,3 +82,55 @@ func TestSimple(t *testing.T) {
 		})
 	}
 }
+
+func TestSimpleHash(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("potatoes"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		username string
+		hash     []byte
+
+		wantErr bool
+	}{
+		{
+			name:     "ValidHash",
+			username: "samwise",
+			hash:     hash,
+		},
+		{
+			name:     "EmptyHash",
+			username: "samwise",
+			wantErr:  true,
+		},
+		{
+			name:     "NotBcrypt",
+			username: "samwise",
+			hash:     []byte("potatoes"),
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := auth.NewSimpleHash(tc.username, tc.hash)
+			if got := err != nil; got != tc.wantErr {
+				t.Fatalf("got err %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if _, err := a.Authenticate(tc.username, "potatoes"); err != nil {
+				t.Fatalf("failed to authenticate with the hashed password: %v", err)
+			}
+			if _, err := a.Authenticate(tc.username, "fireworks"); err == nil {
+				t.Fatalf("authenticated with the wrong password")
+			}
+		})
+	}
+}
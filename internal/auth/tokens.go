@@ -0,0 +1,407 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/files"
+)
+
+const (
+	ErrAPITokenNotFound = errors.Type("api-token-not-found")
+	ErrAPITokenExpired  = errors.Type("api-token-expired")
+	ErrInvalidAPIToken  = errors.Type("invalid-api-token")
+	ErrScopeNotGranted  = errors.Type("scope-not-granted")
+)
+
+// The scopes of API tokens, which limit what they can be used for.
+const (
+	// ScopeReadProtected allows reading the articles of the groups the user
+	// is in.
+	ScopeReadProtected = "read-protected"
+	// ScopeWriteContent allows creating, updating and restoring documents,
+	// if the user is an editor.
+	ScopeWriteContent = "write-content"
+	// ScopeAdmin allows everything the user can do.
+	ScopeAdmin = "admin"
+)
+
+// scopeRoles is the role a user must have to create a token with a scope.
+var scopeRoles = map[string]string{
+	ScopeReadProtected: "",
+	ScopeWriteContent:  RoleEditor,
+	ScopeAdmin:         RoleAdmin,
+}
+
+// APITokenPrefix begins every API token, so that they can be told apart from
+// access tokens, and found by secret scanners.
+const APITokenPrefix = "bst_"
+
+// lastUsedInterval is how often the time a token or session was last used is
+// saved, so that a busy one doesn't save its store on every request.
+const lastUsedInterval = time.Minute
+
+// IsAPIToken returns true if a token is an API token rather than an access
+// token.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// APIToken is a long-lived token that a user created for automation. The
+// token itself is never kept, only its hash.
+type APIToken struct {
+	// ID names the token when listing or revoking it.
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// User is the claims of the user the token was created by, as they were
+	// when it was created. A token grants the claims the user has now.
+	User     Claims    `json:"user"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"last_used,omitzero"`
+}
+
+// Claims returns the claims a token grants, which are the claims of its user
+// limited to its scopes.
+func (t APIToken) Claims() Claims {
+	claims := Claims{
+		Username: t.User.Username,
+		Name:     t.User.Name,
+		Roles:    []string{},
+		Expiry:   t.Expires.Unix(),
+	}
+
+	for _, scope := range t.Scopes {
+		switch scope {
+		case ScopeAdmin:
+			claims.Roles = t.User.Roles
+			claims.Admin = t.User.Admin
+			return claims
+		case ScopeWriteContent:
+			if t.User.HasRole(RoleEditor) {
+				claims.Roles = append(claims.Roles, RoleEditor)
+			}
+		case ScopeReadProtected:
+			for _, role := range t.User.Roles {
+				if role != RoleEditor && role != RoleAdmin {
+					claims.Roles = append(claims.Roles, role)
+				}
+			}
+		}
+	}
+
+	return claims
+}
+
+// CheckScopes returns an error if a scope is unknown, or requires a role the
+// claims don't grant.
+func CheckScopes(claims Claims, scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.Errorf("%w: a token needs at least one scope", ErrInvalidAPIToken)
+	}
+
+	for _, scope := range scopes {
+		role, ok := scopeRoles[scope]
+		if !ok {
+			return errors.Errorf("%w: unknown scope %q", ErrInvalidAPIToken, scope)
+		}
+		if role != "" && !claims.HasRole(role) {
+			return errors.Errorf("%w: the %s scope requires the %s role", ErrScopeNotGranted, scope, role)
+		}
+	}
+
+	return nil
+}
+
+// TokenStore keeps the API tokens created by users.
+type TokenStore interface {
+	// Create issues a new token with the claims of a user limited to the
+	// scopes, which expires after the lifetime. The token is only ever
+	// returned here.
+	Create(name string, claims Claims, scopes []string, now time.Time, lifetime time.Duration) (BearerToken, APIToken, error)
+	// Verify returns the claims the user of a token has now, as looked up
+	// by the authenticator and limited to its scopes, and records that it
+	// was used.
+	Verify(token BearerToken, users Authenticator, now time.Time) (Claims, error)
+	// List returns the tokens of a user, or of every user if the username is
+	// empty, oldest first.
+	List(username string) ([]APIToken, error)
+	// Revoke revokes the token with an ID.
+	Revoke(id string) error
+	// RevokeUser revokes every token of a user, and returns how many were
+	// revoked.
+	RevokeUser(username string) (int, error)
+	// Sweep removes the tokens that have expired.
+	Sweep(now time.Time) error
+}
+
+// tokenStore is a TokenStore kept in memory, and saved to a file after every
+// change if it has a path. The file is read again whenever it is modified by
+// something else, such as the token command.
+type tokenStore struct {
+	path string
+
+	mutex   sync.Mutex
+	records map[string]APIToken
+	modTime time.Time
+	// saved is the time each token was last used as of the last save.
+	saved map[string]time.Time
+}
+
+// NewMemoryTokenStore creates a TokenStore that is only kept in memory, so
+// every token is revoked when the process stops.
+func NewMemoryTokenStore() TokenStore {
+	return &tokenStore{records: make(map[string]APIToken)}
+}
+
+// NewFileTokenStore creates a TokenStore kept in a file. The file is created
+// if it doesn't exist.
+func NewFileTokenStore(path string) (TokenStore, error) {
+	s := &tokenStore{path: path, records: make(map[string]APIToken)}
+
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, s.save()
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads the records from the file of the store, if it has one and it was
+// modified since it was last read or saved. The caller must hold the mutex.
+func (s *tokenStore) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	records := make(map[string]APIToken)
+	if err := json.Unmarshal(data, &records); err != nil {
+		return errors.Errorf("failed to decode API tokens: %w", err)
+	}
+
+	s.records = records
+	s.modTime = info.ModTime()
+	s.saved = nil
+
+	return nil
+}
+
+// save writes the records to the file of the store, if it has one. The caller
+// must hold the mutex.
+func (s *tokenStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.records)
+	if err != nil {
+		return err
+	}
+
+	if err := files.WriteFile(s.path, data, 0600); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.modTime = info.ModTime()
+
+	s.saved = make(map[string]time.Time, len(s.records))
+	for hash, record := range s.records {
+		s.saved[hash] = record.LastUsed
+	}
+
+	return nil
+}
+
+func (s *tokenStore) Create(name string, claims Claims, scopes []string, now time.Time, lifetime time.Duration) (BearerToken, APIToken, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", APIToken{}, errors.Errorf("%w: a token needs a name", ErrInvalidAPIToken)
+	}
+	if lifetime <= 0 {
+		return "", APIToken{}, errors.Errorf("%w: a token must expire after it is created", ErrInvalidAPIToken)
+	}
+	if err := CheckScopes(claims, scopes); err != nil {
+		return "", APIToken{}, err
+	}
+
+	secret, err := ReadBytes(32)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	id, err := ReadBytes(6)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+
+	token := BearerToken(APITokenPrefix + base64.RawURLEncoding.EncodeToString(secret))
+
+	claims.Audience = ""
+	claims.Expiry, claims.NotBefore, claims.IssuedAt = 0, 0, 0
+
+	record := APIToken{
+		ID:      hex.EncodeToString(id),
+		Name:    name,
+		User:    claims,
+		Scopes:  scopes,
+		Created: now.UTC(),
+		Expires: now.Add(lifetime).UTC(),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return "", APIToken{}, err
+	}
+
+	s.records[hashToken(token)] = record
+
+	return token, record, s.save()
+}
+
+func (s *tokenStore) Verify(token BearerToken, users Authenticator, now time.Time) (Claims, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return Claims{}, err
+	}
+
+	hash := hashToken(token)
+
+	record, ok := s.records[hash]
+	if !ok {
+		return Claims{}, ErrAPITokenNotFound
+	}
+	if !now.Before(record.Expires) {
+		return Claims{}, errors.Errorf("%w: %s", ErrAPITokenExpired, record.Expires.Format(time.RFC3339))
+	}
+
+	user, err := users.Lookup(record.User.Username)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	record.LastUsed = now.UTC()
+	s.records[hash] = record
+
+	if now.Sub(s.saved[hash]) >= lastUsedInterval {
+		if err := s.save(); err != nil {
+			return Claims{}, err
+		}
+	}
+
+	// A token never grants more than its user has now, so that removing a
+	// user or one of their roles also applies to their tokens.
+	current := record
+	current.User = user
+
+	return current.Claims(), nil
+}
+
+func (s *tokenStore) List(username string) ([]APIToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	tokens := []APIToken{}
+	for _, record := range s.records {
+		if username == "" || record.User.Username == username {
+			tokens = append(tokens, record)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].Created.Equal(tokens[j].Created) {
+			return tokens[i].Created.Before(tokens[j].Created)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+
+	return tokens, nil
+}
+
+func (s *tokenStore) Revoke(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for hash, record := range s.records {
+		if record.ID == id {
+			delete(s.records, hash)
+			return s.save()
+		}
+	}
+
+	return ErrAPITokenNotFound
+}
+
+func (s *tokenStore) RevokeUser(username string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for hash, record := range s.records {
+		if record.User.Username == username {
+			delete(s.records, hash)
+			n++
+		}
+	}
+
+	return n, s.save()
+}
+
+func (s *tokenStore) Sweep(now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for hash, record := range s.records {
+		if !now.Before(record.Expires) {
+			delete(s.records, hash)
+		}
+	}
+
+	return s.save()
+}
@@ -0,0 +1,251 @@
+package auth_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+// mockUsers is an authenticator of users that can only be looked up.
+type mockUsers map[string]auth.Claims
+
+func (m mockUsers) Authenticate(username, password string) (auth.Claims, error) {
+	return auth.Claims{}, errors.New("not implemented")
+}
+
+func (m mockUsers) Lookup(username string) (auth.Claims, error) {
+	claims, ok := m[username]
+	if !ok {
+		return auth.Claims{}, auth.ErrUserNotFound
+	}
+	return claims, nil
+}
+
+func (m mockUsers) SecondFactor(username string) bool {
+	return false
+}
+
+func (m mockUsers) VerifyCode(username, code string, now time.Time) error {
+	return errors.New("not implemented")
+}
+
+func TestTokenStoreScopes(t *testing.T) {
+	now := time.Now()
+
+	editor := auth.Claims{Username: "samwise", Name: "Samwise Gamgee", Roles: []string{auth.RoleEditor, "hobbits"}}
+	admin := auth.Claims{Username: "gandalf", Roles: []string{auth.RoleAdmin}, Admin: true}
+
+	testCases := []struct {
+		name   string
+		claims auth.Claims
+		scopes []string
+
+		want auth.Claims
+		err  error
+	}{
+		{
+			name:   "WriteContent",
+			claims: editor,
+			scopes: []string{auth.ScopeWriteContent},
+			want:   auth.Claims{Username: "samwise", Name: "Samwise Gamgee", Roles: []string{auth.RoleEditor}},
+		},
+		{
+			name:   "ReadProtected",
+			claims: editor,
+			scopes: []string{auth.ScopeReadProtected},
+			want:   auth.Claims{Username: "samwise", Name: "Samwise Gamgee", Roles: []string{"hobbits"}},
+		},
+		{
+			name:   "Admin",
+			claims: admin,
+			scopes: []string{auth.ScopeAdmin},
+			want:   auth.Claims{Username: "gandalf", Roles: []string{auth.RoleAdmin}, Admin: true},
+		},
+		{
+			name:   "AdminWritesContent",
+			claims: admin,
+			scopes: []string{auth.ScopeWriteContent},
+			want:   auth.Claims{Username: "gandalf", Roles: []string{auth.RoleEditor}},
+		},
+		{
+			name:   "NotGranted",
+			claims: editor,
+			scopes: []string{auth.ScopeAdmin},
+			err:    auth.ErrScopeNotGranted,
+		},
+		{
+			name:   "UnknownScope",
+			claims: editor,
+			scopes: []string{"everything"},
+			err:    auth.ErrInvalidAPIToken,
+		},
+		{
+			name:   "NoScopes",
+			claims: editor,
+			err:    auth.ErrInvalidAPIToken,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := auth.NewMemoryTokenStore()
+
+			token, record, err := s.Create("ci", tc.claims, tc.scopes, now, time.Hour)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got err %v, want %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if !strings.HasPrefix(string(token), auth.APITokenPrefix) {
+				t.Fatalf("got token %q without the prefix %q", token, auth.APITokenPrefix)
+			}
+
+			got, err := s.Verify(token, mockUsers{tc.claims.Username: tc.claims}, now)
+			if err != nil {
+				t.Fatalf("failed to verify token: %v", err)
+			}
+
+			want := tc.want
+			want.Expiry = record.Expires.Unix()
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestTokenStore(t *testing.T) {
+	now := time.Now()
+	claims := auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor}}
+	users := mockUsers{"samwise": claims, "pippin": {Username: "pippin"}}
+
+	path := filepath.Join(t.TempDir(), "api-tokens.json")
+	s, err := auth.NewFileTokenStore(path)
+	if err != nil {
+		t.Fatalf("failed to create token store: %v", err)
+	}
+
+	token, record, err := s.Create("ci", claims, []string{auth.ScopeWriteContent}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+
+	// Only the hash of the token is saved.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read token store: %v", err)
+	}
+	if strings.Contains(string(data), string(token)) {
+		t.Fatalf("the token was saved to %s", path)
+	}
+
+	if _, err := s.Verify(token, users, now); err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	// Tokens created elsewhere, such as by the token command, are seen once
+	// the file changes.
+	other, err := auth.NewFileTokenStore(path)
+	if err != nil {
+		t.Fatalf("failed to open token store: %v", err)
+	}
+	otherToken, _, err := other.Create("deploy", auth.Claims{Username: "pippin"}, []string{auth.ScopeReadProtected}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("failed to modify token store: %v", err)
+	}
+
+	tokens, err := s.List("")
+	if err != nil {
+		t.Fatalf("failed to list tokens: %v", err)
+	}
+	if got, want := len(tokens), 2; got != want {
+		t.Fatalf("got %d tokens, want %d", got, want)
+	}
+	for _, token := range tokens {
+		if token.ID != record.ID {
+			continue
+		}
+		if got, want := token.LastUsed, now.UTC(); !got.Equal(want) {
+			t.Fatalf("got last used %v, want %v", got, want)
+		}
+	}
+
+	if _, err := s.Verify(otherToken, users, now); err != nil {
+		t.Fatalf("failed to verify token created elsewhere: %v", err)
+	}
+
+	if _, err := s.Verify(token, users, now.Add(time.Hour)); !errors.Is(err, auth.ErrAPITokenExpired) {
+		t.Fatalf("got err %v, want %v", err, auth.ErrAPITokenExpired)
+	}
+
+	if err := s.Revoke(record.ID); err != nil {
+		t.Fatalf("failed to revoke token: %v", err)
+	}
+	if _, err := s.Verify(token, users, now); !errors.Is(err, auth.ErrAPITokenNotFound) {
+		t.Fatalf("got err %v, want %v", err, auth.ErrAPITokenNotFound)
+	}
+	if err := s.Revoke(record.ID); !errors.Is(err, auth.ErrAPITokenNotFound) {
+		t.Fatalf("got err %v revoking twice, want %v", err, auth.ErrAPITokenNotFound)
+	}
+
+	n, err := s.RevokeUser("pippin")
+	if err != nil {
+		t.Fatalf("failed to revoke tokens of user: %v", err)
+	}
+	if got, want := n, 1; got != want {
+		t.Fatalf("got %d tokens revoked, want %d", got, want)
+	}
+}
+
+func TestTokenFollowsUser(t *testing.T) {
+	now := time.Now()
+	editor := auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor, "hobbits"}}
+
+	s := auth.NewMemoryTokenStore()
+	token, _, err := s.Create("ci", editor, []string{auth.ScopeWriteContent, auth.ScopeReadProtected}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+
+	// The token loses the roles its user loses.
+	users := mockUsers{"samwise": {Username: "samwise", Roles: []string{"hobbits"}}}
+	got, err := s.Verify(token, users, now)
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+	if got, want := got.Roles, []string{"hobbits"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got roles %v, want %v", got, want)
+	}
+
+	// The token never gains roles its scopes don't allow.
+	users["samwise"] = auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor, auth.RoleAdmin}, Admin: true}
+	got, err = s.Verify(token, users, now)
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+	if got, want := got.Roles, []string{auth.RoleEditor}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got roles %v, want %v", got, want)
+	}
+	if got.Admin {
+		t.Fatalf("got an admin token without the admin scope")
+	}
+
+	// The token can't be used once its user is removed.
+	delete(users, "samwise")
+	if _, err := s.Verify(token, users, now); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Fatalf("got err %v, want %v", err, auth.ErrUserNotFound)
+	}
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+const (
+	ErrSecondFactorNotEnrolled = errors.Type("second-factor-not-enrolled")
+	ErrInvalidCode             = errors.Type("invalid-code")
+)
+
+const (
+	// totpPeriod is how long each TOTP code is valid for.
+	totpPeriod = 30 * time.Second
+	// totpDigits is the number of digits in a TOTP code.
+	totpDigits = 6
+	// totpSkew is how many periods before or after the current one a code is
+	// accepted from, to allow for clocks that differ.
+	totpSkew = 1
+	// recoveryCodeSize is the number of random bytes in a recovery code.
+	recoveryCodeSize = 10
+)
+
+// totpEncoding is the encoding of TOTP secrets expected by authenticator apps.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret creates a new random TOTP secret, encoded as base32.
+func GenerateTOTPSecret() (string, error) {
+	key, err := ReadBytes(20)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(key), nil
+}
+
+// decodeTOTPSecret decodes a base32 TOTP secret, ignoring case and spaces.
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return totpEncoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// totpStep returns the period a time falls in.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// hotp computes the code for a counter, as defined by RFC 4226.
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// TOTPCode returns the TOTP code of a secret at the given time, as defined by
+// RFC 6238 with SHA-1, six digits and a thirty second period.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", errors.Errorf("invalid TOTP secret: %w", err)
+	}
+
+	return hotp(key, totpStep(t)), nil
+}
+
+// VerifyTOTP checks a TOTP code of a secret at the given time, and returns
+// the period it was issued in. Codes from the periods just before and after
+// the current one are also accepted.
+func VerifyTOTP(secret, code string, now time.Time) (int64, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, errors.Errorf("invalid TOTP secret: %w", err)
+	}
+
+	step := totpStep(now)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step+int64(i))), []byte(code)) == 1 {
+			return step + int64(i), nil
+		}
+	}
+
+	return 0, ErrInvalidCode
+}
+
+// TOTPURL returns the otpauth URL of a secret, which authenticator apps can
+// import, usually as a QR code.
+func TOTPURL(issuer, account, secret string) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+		RawQuery: url.Values{
+			"secret": {secret},
+			"issuer": {issuer},
+		}.Encode(),
+	}
+
+	return u.String()
+}
+
+// normalizeRecoveryCode removes the formatting of a recovery code, so that it
+// is accepted however it was typed.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored as. Recovery
+// codes are random enough that they don't need a slow hash.
+func HashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateRecoveryCodes creates n random recovery codes.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b, err := ReadBytes(recoveryCodeSize)
+		if err != nil {
+			return nil, err
+		}
+
+		code := totpEncoding.EncodeToString(b)
+		codes[i] = code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,85 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+// rfc6238Secret is the SHA-1 secret of the test vectors of RFC 6238,
+// "12345678901234567890", encoded as base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The test vectors of RFC 6238 have eight digits, of which codes are the
+	// last six.
+	testCases := []struct {
+		time int64
+		want string
+	}{
+		{time: 59, want: "287082"},
+		{time: 1111111109, want: "081804"},
+		{time: 1111111111, want: "050471"},
+		{time: 1234567890, want: "005924"},
+		{time: 2000000000, want: "279037"},
+		{time: 20000000000, want: "353130"},
+	}
+
+	for _, tc := range testCases {
+		got, err := auth.TOTPCode(rfc6238Secret, time.Unix(tc.time, 0))
+		if err != nil {
+			t.Fatalf("failed to generate code at %d: %v", tc.time, err)
+		}
+		if got != tc.want {
+			t.Errorf("got code %s at %d, want %s", got, tc.time, tc.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	testCases := []struct {
+		name string
+		at   time.Time
+
+		wantErr error
+	}{
+		{name: "Current", at: now},
+		{name: "Previous", at: now.Add(-30 * time.Second)},
+		{name: "Next", at: now.Add(30 * time.Second)},
+		{name: "TooOld", at: now.Add(-90 * time.Second), wantErr: auth.ErrInvalidCode},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			code, err := auth.TOTPCode(rfc6238Secret, tc.at)
+			if err != nil {
+				t.Fatalf("failed to generate code: %v", err)
+			}
+
+			if _, err := auth.VerifyTOTP(rfc6238Secret, code, now); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got err %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := auth.GenerateRecoveryCodes(2)
+	if err != nil {
+		t.Fatalf("failed to generate recovery codes: %v", err)
+	}
+
+	if codes[0] == codes[1] {
+		t.Fatalf("got the same recovery code twice: %s", codes[0])
+	}
+
+	// Codes are accepted however they are typed.
+	if got, want := auth.HashRecoveryCode("abcd efgh-ijkl"), auth.HashRecoveryCode("ABCD-EFGH-IJKL"); got != want {
+		t.Fatalf("got hash %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,375 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/files"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ErrUserNotFound = errors.Type("user-not-found")
+	ErrInvalidUser  = errors.Type("invalid-user")
+)
+
+const (
+	// RoleEditor is the role of users that write documents.
+	RoleEditor = "editor"
+	// RoleAdmin is the role of users that administer the site.
+	RoleAdmin = "admin"
+)
+
+// unknownUserHash is compared against the password of users that don't exist,
+// so that they take as long to reject as users with the wrong password.
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+
+// User is a user that can log in, as stored in a user file.
+type User struct {
+	Username string
+	// Hash is the bcrypt hash of the user's password.
+	Hash []byte
+	// Name is the name of the user as it is displayed.
+	Name  string
+	Roles []string
+	// TOTPSecret is the secret of the user's second factor, or empty if they
+	// haven't enrolled one.
+	TOTPSecret string
+	// RecoveryCodes are the hashes of the codes that can be used once each
+	// in place of a TOTP code.
+	RecoveryCodes []string
+}
+
+// SetPassword replaces the password of the user.
+func (u *User) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	u.Hash = hash
+
+	return nil
+}
+
+// Claims returns the claims of an authenticated user.
+func (u User) Claims() Claims {
+	claims := Claims{
+		Username: u.Username,
+		Name:     u.Name,
+		Roles:    u.Roles,
+	}
+	for _, role := range u.Roles {
+		if role == RoleAdmin {
+			claims.Admin = true
+		}
+	}
+
+	return claims
+}
+
+// validate checks that the user can be written to a user file.
+func (u User) validate() error {
+	switch {
+	case u.Username == "":
+		return errors.Errorf("%w: the username is empty", ErrInvalidUser)
+	case strings.ContainsAny(u.Username, ":\r\n") || strings.TrimSpace(u.Username) != u.Username:
+		return errors.Errorf("%w: the username %q can't contain colons, newlines or surrounding spaces", ErrInvalidUser, u.Username)
+	case strings.HasPrefix(u.Username, "#"):
+		return errors.Errorf("%w: the username %q can't begin with #", ErrInvalidUser, u.Username)
+	case strings.ContainsAny(u.Name, ":\r\n"):
+		return errors.Errorf("%w: the name %q can't contain colons or newlines", ErrInvalidUser, u.Name)
+	}
+
+	if _, err := bcrypt.Cost(u.Hash); err != nil {
+		return errors.Errorf("%w: the password of %q isn't a bcrypt hash", ErrInvalidUser, u.Username)
+	}
+
+	for _, role := range u.Roles {
+		if role == "" || strings.ContainsAny(role, ":, \t\r\n") {
+			return errors.Errorf("%w: the role %q of %q can't be empty or contain colons, commas or spaces", ErrInvalidUser, role, u.Username)
+		}
+	}
+
+	if u.TOTPSecret != "" {
+		if _, err := decodeTOTPSecret(u.TOTPSecret); err != nil || strings.ContainsAny(u.TOTPSecret, ": ") {
+			return errors.Errorf("%w: the TOTP secret of %q isn't base32", ErrInvalidUser, u.Username)
+		}
+	}
+
+	for _, hash := range u.RecoveryCodes {
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return errors.Errorf("%w: the recovery code %q of %q isn't a SHA-256 hash", ErrInvalidUser, hash, u.Username)
+		}
+	}
+
+	return nil
+}
+
+// ParseUsers decodes the users of a user file. Each line of the file is a
+// user, in the form:
+//
+//	username:bcrypt-hash:display name:role,role
+//
+// Users that enrolled a second factor have two more fields, their TOTP secret
+// and the hashes of their recovery codes:
+//
+//	username:bcrypt-hash:display name:role,role:totp-secret:hash,hash
+//
+// Empty lines and lines beginning with # are ignored.
+func ParseUsers(data []byte) ([]User, error) {
+	users := []User{}
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 4 && len(fields) != 6 {
+			return nil, errors.Errorf("line %d: %w: expected username:hash:name:roles, optionally followed by :totp-secret:recovery-codes", n, ErrInvalidUser)
+		}
+
+		user := User{
+			Username: fields[0],
+			Hash:     []byte(fields[1]),
+			Name:     fields[2],
+			Roles:    []string{},
+		}
+		if fields[3] != "" {
+			user.Roles = strings.Split(fields[3], ",")
+		}
+		if len(fields) == 6 {
+			user.TOTPSecret = fields[4]
+			if fields[5] != "" {
+				user.RecoveryCodes = strings.Split(fields[5], ",")
+			}
+		}
+
+		if err := user.validate(); err != nil {
+			return nil, errors.Errorf("line %d: %w", n, err)
+		}
+		if seen[user.Username] {
+			return nil, errors.Errorf("line %d: %w: %q is listed more than once", n, ErrInvalidUser, user.Username)
+		}
+		seen[user.Username] = true
+
+		users = append(users, user)
+	}
+
+	return users, scanner.Err()
+}
+
+// MarshalUsers encodes users in the format of a user file, sorted by username.
+func MarshalUsers(users []User) ([]byte, error) {
+	sorted := append([]User{}, users...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Username < sorted[j].Username
+	})
+
+	var buf bytes.Buffer
+	for _, user := range sorted {
+		if err := user.validate(); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%s:%s:%s:%s", user.Username, user.Hash, user.Name, strings.Join(user.Roles, ","))
+		if user.TOTPSecret != "" {
+			fmt.Fprintf(&buf, ":%s:%s", user.TOTPSecret, strings.Join(user.RecoveryCodes, ","))
+		}
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UserFile is an Authenticator for the users listed in a user file. The file
+// is read again whenever it is modified, so that users can be added or
+// removed without restarting the server. The file is written when a recovery
+// code is used up.
+type UserFile struct {
+	Path string
+
+	mutex   sync.Mutex
+	users   map[string]User
+	modTime time.Time
+	// lastStep is the TOTP period of the last code used by each user, so that
+	// a code can't be used twice.
+	lastStep map[string]int64
+}
+
+// current returns the users as they are in the file. If the file was modified
+// but can't be read, the users that were last read are returned instead.
+func (f *UserFile) current() (map[string]User, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err == nil && f.users != nil && info.ModTime().Equal(f.modTime) {
+		return f.users, nil
+	}
+	if err != nil {
+		if f.users != nil {
+			return f.users, nil
+		}
+		return nil, err
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err == nil {
+		var list []User
+		list, err = ParseUsers(data)
+		if err == nil {
+			f.users = make(map[string]User, len(list))
+			for _, user := range list {
+				f.users[user.Username] = user
+			}
+			f.modTime = info.ModTime()
+		}
+	}
+	if f.users == nil {
+		return nil, err
+	}
+
+	return f.users, nil
+}
+
+// Load reads the users from the file, returning an error if they can't be.
+func (f *UserFile) Load() error {
+	_, err := f.current()
+	return err
+}
+
+// Authenticate checks the password of a user in the file, and returns the
+// claims of the user's name and roles.
+func (f *UserFile) Authenticate(username, password string) (Claims, error) {
+	users, err := f.current()
+	if err != nil {
+		return Claims{}, err
+	}
+
+	user, ok := users[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+		return Claims{}, errors.Errorf("%w: %q", ErrUserNotFound, username)
+	}
+
+	if err := bcrypt.CompareHashAndPassword(user.Hash, []byte(password)); err != nil {
+		return Claims{}, err
+	}
+
+	return user.Claims(), nil
+}
+
+// Lookup returns the claims of the name and roles a user has in the file now.
+func (f *UserFile) Lookup(username string) (Claims, error) {
+	users, err := f.current()
+	if err != nil {
+		return Claims{}, err
+	}
+
+	user, ok := users[username]
+	if !ok {
+		return Claims{}, errors.Errorf("%w: %q", ErrUserNotFound, username)
+	}
+
+	return user.Claims(), nil
+}
+
+// SecondFactor returns true if the user enrolled a TOTP second factor.
+func (f *UserFile) SecondFactor(username string) bool {
+	users, err := f.current()
+	if err != nil {
+		return false
+	}
+
+	return users[username].TOTPSecret != ""
+}
+
+// VerifyCode checks a TOTP code of a user, or one of their recovery codes,
+// which is removed from the file once used.
+func (f *UserFile) VerifyCode(username, code string, now time.Time) error {
+	if _, err := f.current(); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	user, ok := f.users[username]
+	if !ok {
+		return errors.Errorf("%w: %q", ErrUserNotFound, username)
+	}
+	if user.TOTPSecret == "" {
+		return errors.Errorf("%w: %q", ErrSecondFactorNotEnrolled, username)
+	}
+
+	step, err := VerifyTOTP(user.TOTPSecret, code, now)
+	if err == nil {
+		if f.lastStep == nil {
+			f.lastStep = make(map[string]int64)
+		}
+		if last, ok := f.lastStep[username]; ok && step <= last {
+			return errors.Errorf("%w: the code was already used", ErrInvalidCode)
+		}
+		f.lastStep[username] = step
+
+		return nil
+	}
+
+	hash := HashRecoveryCode(code)
+	for i, h := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+
+		user.RecoveryCodes = append(append([]string{}, user.RecoveryCodes[:i]...), user.RecoveryCodes[i+1:]...)
+
+		return f.save(user)
+	}
+
+	return ErrInvalidCode
+}
+
+// save replaces a user in the file. The caller must hold the mutex.
+func (f *UserFile) save(user User) error {
+	users := make(map[string]User, len(f.users))
+	list := make([]User, 0, len(f.users))
+	for name, u := range f.users {
+		if name == user.Username {
+			u = user
+		}
+		users[name] = u
+		list = append(list, u)
+	}
+
+	data, err := MarshalUsers(list)
+	if err != nil {
+		return err
+	}
+	if err := files.WriteFile(f.Path, data, 0600); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+
+	f.users = users
+	f.modTime = info.ModTime()
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package auth_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+func mustUser(t *testing.T, username, password, name string, roles ...string) auth.User {
+	t.Helper()
+
+	user := auth.User{Username: username, Name: name, Roles: roles}
+	if err := user.SetPassword(password); err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	return user
+}
+
+func TestParseUsers(t *testing.T) {
+	const hash = "$2a$04$P3sXAZ0FjvQhmJ4CZ2c9/OKyVRyJgn5hAi5jzrH5DGZbz1SXqCCKm"
+
+	testCases := []struct {
+		name string
+		data string
+
+		want []auth.User
+		err  error
+	}{
+		{
+			name: "Users",
+			data: "# The editors\n\nsamwise:" + hash + ":Samwise Gamgee:editor\r\ngandalf:" + hash + "::editor,admin\n",
+			want: []auth.User{
+				{Username: "samwise", Hash: []byte(hash), Name: "Samwise Gamgee", Roles: []string{"editor"}},
+				{Username: "gandalf", Hash: []byte(hash), Name: "", Roles: []string{"editor", "admin"}},
+			},
+		},
+		{
+			name: "NoRoles",
+			data: "pippin:" + hash + ":Pippin:\n",
+			want: []auth.User{
+				{Username: "pippin", Hash: []byte(hash), Name: "Pippin", Roles: []string{}},
+			},
+		},
+		{
+			name: "MissingFields",
+			data: "samwise:" + hash + "\n",
+			err:  auth.ErrInvalidUser,
+		},
+		{
+			name: "NotBcrypt",
+			data: "samwise:bananas:Samwise:editor\n",
+			err:  auth.ErrInvalidUser,
+		},
+		{
+			name: "EmptyRole",
+			data: "samwise:" + hash + ":Samwise:editor,\n",
+			err:  auth.ErrInvalidUser,
+		},
+		{
+			name: "Duplicate",
+			data: "samwise:" + hash + "::\nsamwise:" + hash + "::\n",
+			err:  auth.ErrInvalidUser,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := auth.ParseUsers([]byte(tc.data))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got err %v, want %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMarshalUsers(t *testing.T) {
+	users := []auth.User{
+		mustUser(t, "samwise", "potatoes", "Samwise Gamgee", "editor"),
+		mustUser(t, "gandalf", "fireworks", "Gandalf", "editor", auth.RoleAdmin),
+	}
+
+	data, err := auth.MarshalUsers(users)
+	if err != nil {
+		t.Fatalf("failed to encode users: %v", err)
+	}
+
+	got, err := auth.ParseUsers(data)
+	if err != nil {
+		t.Fatalf("failed to decode users: %v", err)
+	}
+
+	// Users are written sorted by username.
+	want := []auth.User{users[1], users[0]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	_, err = auth.MarshalUsers([]auth.User{mustUser(t, "sam:wise", "potatoes", "")})
+	if !errors.Is(err, auth.ErrInvalidUser) {
+		t.Fatalf("got err %v, want %v", err, auth.ErrInvalidUser)
+	}
+}
+
+func TestUserFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	write := func(users ...auth.User) {
+		data, err := auth.MarshalUsers(users)
+		if err != nil {
+			t.Fatalf("failed to encode users: %v", err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("failed to write users: %v", err)
+		}
+	}
+
+	write(
+		mustUser(t, "samwise", "potatoes", "Samwise Gamgee", "editor"),
+		mustUser(t, "gandalf", "fireworks", "Gandalf", "editor", auth.RoleAdmin),
+	)
+
+	f := &auth.UserFile{Path: path}
+	if err := f.Load(); err != nil {
+		t.Fatalf("failed to load users: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		username string
+		password string
+
+		want    auth.Claims
+		succeed bool
+	}{
+		{
+			name:     "Editor",
+			username: "samwise",
+			password: "potatoes",
+			want:     auth.Claims{Username: "samwise", Name: "Samwise Gamgee", Roles: []string{"editor"}},
+			succeed:  true,
+		},
+		{
+			name:     "Admin",
+			username: "gandalf",
+			password: "fireworks",
+			want:     auth.Claims{Username: "gandalf", Name: "Gandalf", Roles: []string{"editor", auth.RoleAdmin}, Admin: true},
+			succeed:  true,
+		},
+		{
+			name:     "WrongPassword",
+			username: "samwise",
+			password: "fireworks",
+		},
+		{
+			name:     "UnknownUser",
+			username: "sauron",
+			password: "potatoes",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := f.Authenticate(tc.username, tc.password)
+			if (err == nil) != tc.succeed {
+				t.Fatalf("got err %v, want success %v", err, tc.succeed)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	// Users removed from the file can't log in once it is modified.
+	write(mustUser(t, "gandalf", "fireworks", "Gandalf", auth.RoleAdmin))
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("failed to modify users: %v", err)
+	}
+
+	if _, err := f.Authenticate("samwise", "potatoes"); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Fatalf("got err %v, want %v", err, auth.ErrUserNotFound)
+	}
+}
+
+func TestUserFileVerifyCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	recovery := "ABCD-EFGH-IJKL-MNOP"
+	user := mustUser(t, "samwise", "potatoes", "Samwise Gamgee", auth.RoleEditor)
+	user.TOTPSecret = rfc6238Secret
+	user.RecoveryCodes = []string{auth.HashRecoveryCode(recovery)}
+
+	data, err := auth.MarshalUsers([]auth.User{user, mustUser(t, "pippin", "apples", "")})
+	if err != nil {
+		t.Fatalf("failed to encode users: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write users: %v", err)
+	}
+
+	f := &auth.UserFile{Path: path}
+
+	if got, want := f.SecondFactor("samwise"), true; got != want {
+		t.Fatalf("got second factor %v for samwise, want %v", got, want)
+	}
+	if got, want := f.SecondFactor("pippin"), false; got != want {
+		t.Fatalf("got second factor %v for pippin, want %v", got, want)
+	}
+
+	code, err := auth.TOTPCode(rfc6238Secret, now)
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		username string
+		code     string
+
+		wantErr error
+	}{
+		{name: "Code", username: "samwise", code: code},
+		{name: "CodeReused", username: "samwise", code: code, wantErr: auth.ErrInvalidCode},
+		{name: "WrongCode", username: "samwise", code: "000000", wantErr: auth.ErrInvalidCode},
+		{name: "RecoveryCode", username: "samwise", code: "abcd efgh ijkl mnop"},
+		{name: "RecoveryCodeReused", username: "samwise", code: recovery, wantErr: auth.ErrInvalidCode},
+		{name: "NotEnrolled", username: "pippin", code: code, wantErr: auth.ErrSecondFactorNotEnrolled},
+	}
+
+	// The cases run in order, since each code can only be used once.
+	for _, tc := range testCases {
+		if err := f.VerifyCode(tc.username, tc.code, now); !errors.Is(err, tc.wantErr) {
+			t.Fatalf("%s: got err %v, want %v", tc.name, err, tc.wantErr)
+		}
+	}
+
+	// The used recovery code was removed from the file.
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read users: %v", err)
+	}
+	users, err := auth.ParseUsers(data)
+	if err != nil {
+		t.Fatalf("failed to decode users: %v", err)
+	}
+	if got := users[1].RecoveryCodes; len(got) != 0 {
+		t.Fatalf("got recovery codes %v after using the only one, want none", got)
+	}
+	if got, want := users[1].TOTPSecret, rfc6238Secret; got != want {
+		t.Fatalf("got secret %q, want %q", got, want)
+	}
+}
@@ -1,16 +1,20 @@
 package content
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"html/template"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/toddgaunt/bastion/internal/auth"
 	"github.com/toddgaunt/bastion/internal/errors"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Article represents an article served by the bastion webservice.
@@ -24,6 +28,10 @@ type Article struct {
 	// Route is the route to the article on the website.
 	Route string
 
+	// Aliases are the routes the article was served from before it moved,
+	// which redirect to its route.
+	Aliases []string
+
 	// Content of the article
 	Title       string
 	Description string
@@ -32,11 +40,29 @@ type Article struct {
 	Created     time.Time
 	Updated     time.Time
 
+	// Publish and Expires bound the time the article is visible, if they
+	// are set.
+	Publish time.Time
+	Expires time.Time
+
+	// Tags the article was generated with
+	Tags []string
+
 	// Is the article unlisted?
 	Unlisted bool
 
+	// Draft articles are never listed, and can only be viewed by editors or
+	// with a share link.
+	Draft bool
+
 	// Does the article require authentication to view?
 	Authenticator auth.Authenticator
+	// Credential is a fingerprint of the username and password hash of the
+	// article, which changes whenever either of them does.
+	Credential string
+
+	// Group is the role users must have to view the article, if any.
+	Group string
 
 	// Original text content of the article
 	Text []byte
@@ -45,6 +71,10 @@ type Article struct {
 	HTML template.HTML
 
 	Err error
+
+	// Warnings joins problems with the document that don't prevent the
+	// article from being served, the same way as Err.
+	Warnings error
 }
 
 // FormattedDate returns a formatted date string from an article's Created
@@ -53,6 +83,22 @@ func (article Article) FormattedDate() string {
 	return article.Created.Format("2006-01-02")
 }
 
+// Protected returns true if the article requires authentication to view.
+func (article Article) Protected() bool {
+	return article.Authenticator != nil || article.Group != ""
+}
+
+// Visible returns true if the article is published at a time, which is from
+// its publish time until it expires.
+func (article Article) Visible(now time.Time) bool {
+	return !now.Before(article.Publish) && (article.Expires.IsZero() || now.Before(article.Expires))
+}
+
+// ETag returns a strong entity tag identifying the text of an article.
+func (article Article) ETag() string {
+	return ETag(article.Text)
+}
+
 // SetTimestamps parses string timestamps and converts them to timestamps to
 // set in the article.
 func (a *Article) SetTimestamps(created string, updated string) {
@@ -72,6 +118,23 @@ func (a *Article) SetTimestamps(created string, updated string) {
 	}
 }
 
+// ValidRoute returns true if a route is a clean, absolute path that doesn't
+// lead into a hidden file or directory, since those are reserved for built-in
+// routes. Only valid routes can be associated with a document.
+func ValidRoute(route string) bool {
+	if route == "/" || route != path.Clean(route) || !strings.HasPrefix(route, "/") {
+		return false
+	}
+
+	for _, name := range strings.Split(route[1:], "/") {
+		if strings.HasPrefix(name, ".") {
+			return false
+		}
+	}
+
+	return true
+}
+
 // ArticleRoute creates the route to an article from the root filepath and the
 // path to the document the article was generated from. The route does not
 // include the file extension.
@@ -86,12 +149,15 @@ func ArticlePath(root, filepath string) string {
 }
 
 // GenerateArticle reads a document from the filesystem and generates an
-// in-memory article for use by the web-server.
+// in-memory article for use by the web-server. If the document has problems,
+// Err joins every problem found, and Warnings joins those that don't prevent
+// the article from being served. Problems with a particular line of the
+// document are reported as a LineError.
 func GenerateArticle(root, filepath string) Article {
 	key := ArticlePath(root, filepath)
 	route := ArticleRoute(root, filepath)
 
-	article := Article{Path: key, Route: route}
+	article := Article{FilePath: filepath, Path: key, Route: route}
 
 	bytes, err := os.ReadFile(filepath)
 	if err != nil {
@@ -99,57 +165,210 @@ func GenerateArticle(root, filepath string) Article {
 		return article
 	}
 
-	var doc Document
-	doc, article.Err = UnmarshalDocument(bytes)
-	if article.Err != nil {
+	doc, err := UnmarshalDocument(bytes)
+	if err != nil {
+		article.Err = err
 		return article
 	}
 
+	article = NewArticle(doc)
+	article.FilePath = filepath
+	article.Path = key
+	article.Route = route
+
+	return article
+}
+
+// NewArticle generates an in-memory article from a document that isn't
+// associated with any file, so the article has no path or route. Problems are
+// reported the same way as GenerateArticle.
+func NewArticle(doc Document) Article {
+	var article Article
+	var err error
+
 	// Marshal here rather than use the bytes directly
 	article.Text, article.Err = MarshalDocument(doc)
 	if article.Err != nil {
 		return article
 	}
 
-	article.FilePath = filepath
+	var errs, warnings []error
+
 	article.Title = doc.Properties.Value("Title")
 	article.Description = doc.Properties.Value("Description")
 	article.Author = doc.Properties.Value("Author")
+	article.Tags = doc.Properties.Values("Tag")
 
+	article.Aliases = doc.Properties.Values("Alias")
+	for i, alias := range article.Aliases {
+		if !ValidRoute(alias) {
+			errs = append(errs, doc.valueLineError("Alias", i, errors.Errorf("article property 'Alias' must be a clean route starting with '/', not %q", alias)))
+		}
+	}
 
 	// Setup authentication for an article
 	username := doc.Properties.Value("Username")
 	password := doc.Properties.Value("Password")
-
-	if username != "" || password != "" {
-		article.Authenticator, article.Err = auth.NewSimple(username, password)
-		if article.Err != nil {
-			return article
+	passwordHash := doc.Properties.Value("PasswordHash")
+
+	switch {
+	case password != "" && passwordHash != "":
+		errs = append(errs, doc.lineError("PasswordHash", errors.New("article property 'PasswordHash' can't be used with 'Password'")))
+	case username != "" && password == "" && passwordHash == "":
+		errs = append(errs, doc.lineError("Username", errors.New("article property 'Username' requires a 'PasswordHash' or 'Password'")))
+	case username == "" && password != "":
+		errs = append(errs, doc.lineError("Password", errors.New("article property 'Password' requires a 'Username'")))
+	case username == "" && passwordHash != "":
+		errs = append(errs, doc.lineError("PasswordHash", errors.New("article property 'PasswordHash' requires a 'Username'")))
+	case passwordHash != "":
+		article.Authenticator, err = auth.NewSimpleHash(username, []byte(passwordHash))
+		if err != nil {
+			errs = append(errs, doc.lineError("PasswordHash", err))
+		}
+	case username != "":
+		warnings = append(warnings, doc.lineError("Password", errors.New("article property 'Password' is stored in plaintext, use 'PasswordHash' instead")))
+		// The password is hashed here, so that only its hash is ever
+		// fingerprinted.
+		var hash []byte
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err == nil {
+			passwordHash = string(hash)
+			article.Authenticator, err = auth.NewSimpleHash(username, hash)
+		}
+		if err != nil {
+			errs = append(errs, doc.lineError("Password", err))
 		}
 	}
 
-	pin := strings.ToLower(doc.Properties.Value("Pinned"))
-	article.Pinned, article.Err = toBool(pin)
+	// The fingerprint is only of the bcrypt hash of the password, since it
+	// can be read from the cookies it is given in. A plaintext password is
+	// hashed with a new salt whenever the article is generated, so cookies
+	// for it don't outlive the article.
+	if article.Authenticator != nil {
+		sum := sha256.Sum256([]byte(username + "\x00" + passwordHash))
+		article.Credential = hex.EncodeToString(sum[:8])
+	}
+
+	article.Group = doc.Properties.Value("Group")
+	switch {
+	case article.Group != "" && username != "":
+		errs = append(errs, doc.lineError("Group", errors.New("article property 'Group' can't be used with 'Username' and 'Password'")))
+	case strings.ContainsAny(article.Group, ", \t"):
+		errs = append(errs, doc.lineError("Group", errors.New("article property 'Group' must be a single role")))
+	}
+
+	article.Pinned, err = toBool(doc, "Pinned")
+	errs = append(errs, err)
+
+	article.Unlisted, err = toBool(doc, "Unlisted")
+	errs = append(errs, err)
+
+	article.Draft, err = toBool(doc, "Draft")
+	errs = append(errs, err)
+
+	article.Created, err = toDate(doc, "Created")
+	errs = append(errs, err)
+
+	article.Updated, err = toDate(doc, "Updated")
+	errs = append(errs, err)
+
+	article.Publish, err = toDateTime(doc, "Publish")
+	errs = append(errs, err)
 
-	unlisted := doc.Properties.Value("Unlisted")
-	article.Unlisted, article.Err = toBool(unlisted)
+	article.Expires, err = toDateTime(doc, "Expires")
+	errs = append(errs, err)
 
-	article.SetTimestamps(doc.Properties.Value("Created"), doc.Properties.Value("Updated"))
+	if !article.Publish.IsZero() && !article.Expires.IsZero() && !article.Expires.After(article.Publish) {
+		errs = append(errs, doc.lineError("Expires", errors.New("article property 'Expires' must be after 'Publish'")))
+	}
+
+	article.HTML, err = doc.GenerateHTML()
+	errs = append(errs, err)
 
-	article.HTML, article.Err = doc.GenerateHTML()
+	article.Err = errors.Join(errs...)
+	article.Warnings = errors.Join(warnings...)
 
 	return article
 }
 
-func toBool(property string) (bool, error) {
-		if property == "" {
-			return false, nil
+// toBool parses the value of a property that must be either true or false.
+// A missing property is false.
+func toBool(doc Document, key string) (bool, error) {
+	property := strings.ToLower(doc.Properties.Value(key))
+	if property == "" {
+		return false, nil
+	}
+
+	if property == "true" || property == "false" {
+		val, _ := strconv.ParseBool(property)
+		return val, nil
+	}
+
+	return false, doc.lineError(key, fmt.Errorf("article property '%s' must be true or false", key))
+}
+
+// toDate parses the value of a property that must be a date of the form
+// YYYY-MM-DD. A missing property is the zero time.
+func toDate(doc Document, key string) (time.Time, error) {
+	property := doc.Properties.Value(key)
+	if property == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse("2006-01-02", property)
+	if err != nil {
+		return time.Time{}, doc.lineError(key, fmt.Errorf("couldn't parse '%s': %w", strings.ToLower(key), err))
+	}
+
+	return t, nil
+}
+
+// dateTimeFormat is the layout of properties that are a date and time, which
+// must include a timezone so that they mean the same wherever the site is
+// served.
+const dateTimeFormat = time.RFC3339
+
+// toDateTime parses the value of a property that must be a date and time with
+// a timezone, such as 2006-01-02T15:04:05-07:00. A missing property is the
+// zero time.
+func toDateTime(doc Document, key string) (time.Time, error) {
+	property := doc.Properties.Value(key)
+	if property == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(dateTimeFormat, property)
+	if err != nil {
+		return time.Time{}, doc.lineError(key, fmt.Errorf("article property '%s' must be a date and time with a timezone, such as 2006-01-02T15:04:05-07:00", key))
+	}
+
+	return t, nil
+}
+
+// RouteConflicts returns an error for every route that more than one article
+// is served from, either as its route or as one of its aliases.
+func RouteConflicts(articles []Article) []error {
+	paths := make(map[string][]string)
+	for _, article := range articles {
+		paths[article.Route] = append(paths[article.Route], article.Path)
+		for _, alias := range article.Aliases {
+			paths[alias] = append(paths[alias], article.Path+" (alias)")
 		}
+	}
+
+	routes := make([]string, 0, len(paths))
+	for route := range paths {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
 
-		if property == "true" || property == "false" {
-			val, _ := strconv.ParseBool(property)
-			return val, nil
+	var errs []error
+	for _, route := range routes {
+		if len(paths[route]) > 1 {
+			sort.Strings(paths[route])
+			errs = append(errs, errors.Errorf("route %s is used by each of %s", route, strings.Join(paths[route], ", ")))
 		}
+	}
 
-		return false, fmt.Errorf("article property '%s' must be true or false", property)
+	return errs
 }
@@ -0,0 +1,331 @@
+package content_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/gmath"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// lines returns the line of every LineError joined in err.
+func lines(err error) []int {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		var lineErr content.LineError
+		if errors.As(err, &lineErr) {
+			return []int{lineErr.Line}
+		}
+		return nil
+	}
+
+	var list []int
+	for _, err := range joined.Unwrap() {
+		list = append(list, lines(err)...)
+	}
+	return list
+}
+
+func TestGenerateArticle(t *testing.T) {
+	testCases := []struct {
+		name     string
+		document string
+
+		wantErr   bool
+		wantLines []int
+		// wantWarnings is the line of every warning.
+		wantWarnings []int
+	}{
+		{
+			name: "Valid",
+			document: gmath.Concat(
+				"Title: Valid\n",
+				"Created: 2020-11-02\n",
+				"Pinned: true\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+		},
+		{
+			name: "EveryPropertyInvalid",
+			document: gmath.Concat(
+				"Title: Invalid\n",
+				"Pinned: yes\n",
+				"Unlisted: no\n",
+				"Created: 2020-13-01\n",
+				"Updated: not-a-date\n",
+				"Username: samwise\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+			wantErr:   true,
+			wantLines: []int{6, 2, 3, 4, 5},
+		},
+		{
+			name: "Scheduled",
+			document: gmath.Concat(
+				"Title: Scheduled\n",
+				"Publish: 2024-06-01T09:00:00-04:00\n",
+				"Expires: 2024-07-01T00:00:00Z\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+		},
+		{
+			name: "PublishWithoutTimezone",
+			document: gmath.Concat(
+				"Title: Scheduled\n",
+				"Publish: 2024-06-01 09:00\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+			wantErr:   true,
+			wantLines: []int{2},
+		},
+		{
+			name: "ExpiresBeforePublish",
+			document: gmath.Concat(
+				"Title: Scheduled\n",
+				"Publish: 2024-06-01T09:00:00Z\n",
+				"Expires: 2024-06-01T09:00:00Z\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+			wantErr:   true,
+			wantLines: []int{3},
+		},
+		{
+			name: "Aliases",
+			document: gmath.Concat(
+				"Title: Moved\n",
+				"Alias: /old\n",
+				"Alias: /older/still\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+		},
+		{
+			name: "InvalidAlias",
+			document: gmath.Concat(
+				"Title: Moved\n",
+				"Alias: old\n",
+				"Alias: /.history/old\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+			wantErr:   true,
+			wantLines: []int{2, 3},
+		},
+		{
+			name: "PasswordHash",
+			document: gmath.Concat(
+				"Title: Hashed\n",
+				"Username: samwise\n",
+				"PasswordHash: $2a$04$P3sXAZ0FjvQhmJ4CZ2c9/OKyVRyJgn5hAi5jzrH5DGZbz1SXqCCKm\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+		},
+		{
+			name: "PlaintextPassword",
+			document: gmath.Concat(
+				"Title: Plaintext\n",
+				"Username: samwise\n",
+				"Password: potatoes\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+			wantWarnings: []int{3},
+		},
+		{
+			name: "PasswordAndHash",
+			document: gmath.Concat(
+				"Title: Both\n",
+				"Username: samwise\n",
+				"Password: potatoes\n",
+				"PasswordHash: $2a$04$P3sXAZ0FjvQhmJ4CZ2c9/OKyVRyJgn5hAi5jzrH5DGZbz1SXqCCKm\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+			wantErr:   true,
+			wantLines: []int{4},
+		},
+		{
+			name: "InvalidPasswordHash",
+			document: gmath.Concat(
+				"Title: Not a hash\n",
+				"Username: samwise\n",
+				"PasswordHash: potatoes\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+			wantErr:   true,
+			wantLines: []int{3},
+		},
+		{
+			name: "GroupWithPassword",
+			document: gmath.Concat(
+				"Title: Protected twice\n",
+				"Username: samwise\n",
+				"Password: potatoes\n",
+				"Group: staff\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+			wantErr:      true,
+			wantLines:    []int{4},
+			wantWarnings: []int{3},
+		},
+		{
+			name: "UnknownFormat",
+			document: gmath.Concat(
+				"Title: Unknown format\n",
+				"\n",
+				"=== md ===\n",
+				"Hello world!",
+			),
+			wantErr:   true,
+			wantLines: []int{3},
+		},
+		{
+			name: "MalformedProperty",
+			document: gmath.Concat(
+				"Title: Malformed\n",
+				"No colon here\n",
+				"=== markdown ===\n",
+				"Hello world!",
+			),
+			wantErr:   true,
+			wantLines: []int{2},
+		},
+		{
+			name:      "MissingDelimiter",
+			document:  "Title: Missing\nHello world!",
+			wantErr:   true,
+			wantLines: []int{0},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			file := filepath.Join(root, "article.md")
+			if err := os.WriteFile(file, []byte(tc.document), 0644); err != nil {
+				t.Fatalf("failed to write document: %v", err)
+			}
+
+			article := content.GenerateArticle(root, file)
+			if got := article.Err != nil; got != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", article.Err, tc.wantErr)
+			}
+
+			if got := lines(article.Err); !reflect.DeepEqual(got, tc.wantLines) {
+				t.Fatalf("got errors on lines %v, want %v: %v", got, tc.wantLines, article.Err)
+			}
+
+			if got := lines(article.Warnings); !reflect.DeepEqual(got, tc.wantWarnings) {
+				t.Fatalf("got warnings on lines %v, want %v: %v", got, tc.wantWarnings, article.Warnings)
+			}
+
+			if got, want := article.Route, "/article"; got != want {
+				t.Errorf("got route %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestVisible(t *testing.T) {
+	publish := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	expires := publish.Add(24 * time.Hour)
+
+	testCases := []struct {
+		name    string
+		article content.Article
+		now     time.Time
+
+		want bool
+	}{
+		{name: "Unscheduled", article: content.Article{}, now: publish, want: true},
+		{name: "BeforePublish", article: content.Article{Publish: publish}, now: publish.Add(-time.Second), want: false},
+		{name: "AtPublish", article: content.Article{Publish: publish}, now: publish, want: true},
+		{name: "BeforeExpiry", article: content.Article{Publish: publish, Expires: expires}, now: expires.Add(-time.Second), want: true},
+		{name: "AtExpiry", article: content.Article{Publish: publish, Expires: expires}, now: expires, want: false},
+		{name: "OtherTimezone", article: content.Article{Publish: publish}, now: publish.In(time.FixedZone("EDT", -4*60*60)), want: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := tc.article.Visible(tc.now), tc.want; got != want {
+				t.Fatalf("got visible %t, want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestCredential(t *testing.T) {
+	credential := func(header string) string {
+		doc, err := content.UnmarshalDocument([]byte(header + "=== markdown ===\nOne ring"))
+		if err != nil {
+			t.Fatalf("failed to unmarshal document: %v", err)
+		}
+		return content.NewArticle(doc).Credential
+	}
+
+	hash := func(password string) string {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("failed to hash password: %v", err)
+		}
+		return string(hash)
+	}
+	potatoes, lembas := hash("potatoes"), hash("lembas")
+
+	original := credential("Title: The Ring\nUsername: samwise\nPasswordHash: " + potatoes + "\n")
+	if original == "" {
+		t.Fatalf("got no credential for a protected article")
+	}
+	if got := credential("Title: The One Ring\nUsername: samwise\nPasswordHash: " + potatoes + "\n"); got != original {
+		t.Fatalf("got credential %q after changing the title, want %q", got, original)
+	}
+	if got := credential("Title: The Ring\nUsername: samwise\nPasswordHash: " + lembas + "\n"); got == original {
+		t.Fatalf("got the same credential after changing the password")
+	}
+	if got := credential("Title: The Ring\n"); got != "" {
+		t.Fatalf("got credential %q for an unprotected article, want none", got)
+	}
+
+	// A plaintext password is only fingerprinted once it is hashed with a
+	// new salt.
+	plaintext := "Title: The Ring\nUsername: samwise\nPassword: potatoes\n"
+	if first, second := credential(plaintext), credential(plaintext); first == "" || first == second {
+		t.Fatalf("got credentials %q and %q for a plaintext password, want different ones", first, second)
+	}
+}
+
+func TestRouteConflicts(t *testing.T) {
+	articles := []content.Article{
+		{Path: "/a.md", Route: "/a"},
+		{Path: "/a.txt", Route: "/a"},
+		{Path: "/b.md", Route: "/b"},
+		{Path: "/c.md", Route: "/c", Aliases: []string{"/b", "/old"}},
+	}
+
+	errs := content.RouteConflicts(articles)
+	if len(errs) != 2 {
+		t.Fatalf("got %d conflicts, want 2: %v", len(errs), errs)
+	}
+
+	if got, want := errs[0].Error(), "route /a is used by each of /a.md, /a.txt"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got, want := errs[1].Error(), "route /b is used by each of /b.md, /c.md (alias)"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -1,14 +1,75 @@
 package content
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+const (
+	ErrArticleNotFound  = errors.Type("article-not-found")
+	ErrRevisionNotFound = errors.Type("revision-not-found")
+	// ErrPreconditionFailed is returned when a document was modified since
+	// the version a change was based on was read.
+	ErrPreconditionFailed = errors.Type("precondition-failed")
+	// ErrArticleExists is returned when creating a document that already
+	// exists.
+	ErrArticleExists = errors.Type("article-exists")
+	// ErrInvalidKey is returned when a key can't refer to a document, such as
+	// a key leading outside of the content or into a hidden directory.
+	ErrInvalidKey = errors.Type("invalid-key")
+)
+
+// Store keeps the articles of a site. Articles that aren't visible at the
+// time of the store's clock are left out of GetAll, GetTagged and Tags, but
+// are still returned by Get so that they can be edited.
 type Store interface {
 	GetDetails() Details
 	Get(key string) (Article, error)
+	// GetAlias returns the article that has a route as one of its aliases.
+	GetAlias(route string) (Article, error)
 	GetAll(pinned bool) []Article
-	Update(key string, doc Document) error
+	GetTagged(tag string) []Article
+	Tags() map[string]int
+	// AsOf returns a view of the store in which the articles visible at a
+	// time are listed instead.
+	AsOf(t time.Time) Store
+	// Update replaces a document. If etag isn't empty, the document is only
+	// replaced if its current ETag matches.
+	Update(key string, doc Document, etag string) error
+	// Create adds a new document.
+	Create(key string, doc Document) error
+	// Delete removes a document. The removed document is kept as a revision.
+	Delete(key string) error
+
+	// History returns the revisions kept of a document, newest first.
+	History(key string) ([]Revision, error)
+	// GetRevision returns the source of a document at a revision.
+	GetRevision(key string, id string) ([]byte, error)
+	// Restore replaces a document with the source it had at a revision.
+	Restore(key string, id string) error
+}
+
+// ETag returns a strong entity tag identifying the text of a document.
+func ETag(text []byte) string {
+	sum := sha256.Sum256(text)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// Revision is a version of a document that was kept when the document was
+// modified.
+type Revision struct {
+	ID   string
+	Time time.Time
 }
 
 type Details struct {
 	Name        string
 	Description string
 	Style       string
+	// URL is the absolute URL the site is served from, used wherever a link
+	// must be absolute such as in feeds. It may be empty.
+	URL string
 }
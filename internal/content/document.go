@@ -42,11 +42,55 @@ MathJax = {
 var headerTemplate = template.Must(template.New("header").Parse(headerHTML))
 var textTemplate = template.Must(template.New("text").Parse(`<pre>{{.}}</pre>`))
 
+// formats are the content formats a document can be written in.
+var formats = []string{"html", "markdown", "text"}
+
+// LineError is an error found on a particular line of a document. A line of 0
+// means the error applies to the document as a whole.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e LineError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e LineError) Unwrap() error {
+	return e.Err
+}
+
 // Document is a structured represtation of the file format for articles.
 type Document struct {
 	Properties Properties
 	Format     string
 	Content    []byte
+
+	// Line numbers of each property key, and the format delimiter, as found
+	// in the source the document was unmarshaled from.
+	lines      map[string][]int
+	formatLine int
+}
+
+// lineError annotates err with the line a property key first appeared on. If
+// the document wasn't unmarshaled, the error is returned without a line.
+func (doc Document) lineError(key string, err error) error {
+	return doc.valueLineError(key, 0, err)
+}
+
+// valueLineError annotates err with the line the i-th value of a repeated
+// property key appeared on.
+func (doc Document) valueLineError(key string, i int, err error) error {
+	line := 0
+	if lines := doc.lines[strings.ToLower(key)]; i < len(lines) {
+		line = lines[i]
+	}
+
+	return LineError{Line: line, Err: err}
 }
 
 // UnmarshalDocument parses bytes and returns a Document, or an error if the
@@ -55,20 +99,29 @@ func UnmarshalDocument(data []byte) (Document, error) {
 	re := regexp.MustCompile(`===.*===`)
 	index := re.FindIndex(data)
 	if index == nil {
-		return Document{}, errors.New("document does not have a content delimiter of the form === <format> ===")
+		return Document{}, LineError{Err: errors.New("document does not have a content delimiter of the form === <format> ===")}
 	}
 
-	properties, err := parseProperties(data[:index[0]])
+	properties, lines, err := parseProperties(data[:index[0]])
 	if err != nil {
 		return Document{}, err
 	}
 	format := strings.TrimSpace(string(data[index[0]+3 : index[1]-3]))
+	// The line ending after the delimiter belongs to the delimiter, so that a
+	// document is the same after being marshaled and unmarshaled again.
 	content := data[index[1]:]
+	if bytes.HasPrefix(content, []byte("\r\n")) {
+		content = content[2:]
+	} else {
+		content = bytes.TrimPrefix(content, []byte("\n"))
+	}
 
 	return Document{
 		Properties: properties,
 		Format:     format,
 		Content:    content,
+		lines:      lines,
+		formatLine: bytes.Count(data[:index[0]], []byte("\n")) + 1,
 	}, nil
 }
 
@@ -162,6 +215,11 @@ func (doc *Document) GenerateHTML() (template.HTML, error) {
 
 		p := parser.NewWithExtensions(markdownExtensions)
 		_, _ = buf.Write(markdown.ToHTML(doc.Content, p, nil))
+	default:
+		return "", LineError{
+			Line: doc.formatLine,
+			Err:  errors.Errorf("unknown format %q, expected one of %s", doc.Format, strings.Join(formats, ", ")),
+		}
 	}
 	buf.WriteString(footerHTML)
 
@@ -216,10 +274,13 @@ func (p Properties) Values(key string) []string {
 	return values
 }
 
-func parseProperties(data []byte) (Properties, error) {
+// parseProperties parses the header of a document. The line numbers each key
+// was found on are returned along with the properties.
+func parseProperties(data []byte) (Properties, map[string][]int, error) {
 	properties := make(Properties)
+	lines := make(map[string][]int)
 	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
+	for line := 1; scanner.Scan(); line++ {
 		text := scanner.Text()
 		// Ignore blank lines
 		if strings.TrimSpace(text) == "" {
@@ -229,16 +290,17 @@ func parseProperties(data []byte) (Properties, error) {
 		// KEY : VALUE syntax is expected on non blank lines
 		split := strings.SplitN(text, ":", 2)
 		if len(split) != 2 {
-			return nil, errors.New("expected 'Key : Value' pair")
+			return nil, nil, LineError{Line: line, Err: errors.New("expected 'Key : Value' pair")}
 		}
 
 		key := strings.ToLower(strings.TrimSpace(split[0]))
 		value := strings.TrimSpace(split[1])
 
 		properties.Add(key, value)
+		lines[key] = append(lines[key], line)
 	}
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return properties, nil
+	return properties, lines, nil
 }
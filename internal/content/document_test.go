@@ -63,4 +63,38 @@ func TestMarshalDocument(t *testing.T) {
 }
 
 func TestMarshalUnmarshalDocument(t *testing.T) {
+	testCases := []struct {
+		name string
+		text string
+	}{
+		{
+			name: "Markdown",
+			text: "author: Tolkien\ntitle: The Hobbit\n=== markdown ===\nIn a hole in the ground\n\nthere lived a hobbit.\n",
+		},
+		{
+			name: "LeadingBlankLine",
+			text: "title: The Hobbit\n=== text ===\n\nThere and back again",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := content.UnmarshalDocument([]byte(tc.text))
+			if err != nil {
+				t.Fatalf("failed to unmarshal document: %v", err)
+			}
+
+			got, err := content.MarshalDocument(doc)
+			if err != nil {
+				t.Fatalf("failed to marshal document: %v", err)
+			}
+
+			if string(got) != tc.text {
+				t.Fatalf("Document changed after unmarshaling and marshaling:\n%v",
+					string(tests.Diff(tc.text, string(got))),
+				)
+			}
+		})
+	}
 }
@@ -0,0 +1,112 @@
+package content
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+// Redirect sends requests for a route, or for every route under it, to
+// another location.
+type Redirect struct {
+	// From is the route that is redirected.
+	From string
+	// To is the location requests are redirected to, which is either a route
+	// or an absolute http or https URL.
+	To string
+	// Prefix redirects every route under From as well, with the rest of the
+	// route appended to To.
+	Prefix bool
+	// Status is the status code of the redirect, which is 301 Moved
+	// Permanently if it is zero.
+	Status int
+}
+
+// Check returns an error if a redirect is invalid.
+func (r Redirect) Check() error {
+	if !ValidRoute(r.From) {
+		return errors.Errorf("redirect from %q must be a clean route starting with '/'", r.From)
+	}
+
+	if !strings.HasPrefix(r.To, "/") || strings.HasPrefix(r.To, "//") {
+		u, err := url.Parse(r.To)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("redirect from %s must be to a route or an http URL, not %q", r.From, r.To)
+		}
+	}
+
+	switch r.Status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return errors.Errorf("redirect from %s has status %d, which must be one of 301, 302, 307 or 308", r.From, r.Status)
+	}
+
+	return nil
+}
+
+// Code returns the status code of a redirect.
+func (r Redirect) Code() int {
+	if r.Status == 0 {
+		return http.StatusMovedPermanently
+	}
+	return r.Status
+}
+
+// Match returns the location a redirect sends a route to, if it redirects
+// the route at all.
+func (r Redirect) Match(route string) (string, bool) {
+	if route == r.From {
+		return r.To, true
+	}
+
+	if r.Prefix && strings.HasPrefix(route, r.From+"/") {
+		return strings.TrimSuffix(r.To, "/") + route[len(r.From):], true
+	}
+
+	return "", false
+}
+
+// FindRedirect returns the redirect of a route among a list of redirects, and
+// the location it sends the route to. A redirect of exactly the route is
+// preferred, and then the prefix redirect with the longest route.
+func FindRedirect(redirects []Redirect, route string) (Redirect, string, bool) {
+	for _, r := range redirects {
+		if !r.Prefix && r.From == route {
+			return r, r.To, true
+		}
+	}
+
+	var found Redirect
+	var location string
+	var ok bool
+	for _, r := range redirects {
+		if !r.Prefix || (ok && len(r.From) <= len(found.From)) {
+			continue
+		}
+		if to, matched := r.Match(route); matched {
+			found, location, ok = r, to, true
+		}
+	}
+
+	return found, location, ok
+}
+
+// RedirectConflicts returns an error for every article that can't be reached
+// because a redirect takes its route.
+func RedirectConflicts(redirects []Redirect, articles []Article) []error {
+	var errs []error
+	for _, article := range articles {
+		if r, _, ok := FindRedirect(redirects, article.Route); ok {
+			errs = append(errs, errors.Errorf("route %s of %s is redirected by the redirect from %s", article.Route, article.Path, r.From))
+		}
+	}
+
+	sort.Slice(errs, func(i int, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+
+	return errs
+}
@@ -0,0 +1,96 @@
+package content_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/toddgaunt/bastion/internal/content"
+)
+
+func TestRedirectCheck(t *testing.T) {
+	testCases := []struct {
+		name     string
+		redirect content.Redirect
+
+		wantErr bool
+	}{
+		{name: "Route", redirect: content.Redirect{From: "/old", To: "/new"}},
+		{name: "URL", redirect: content.Redirect{From: "/old", To: "https://www.example.com/new", Status: http.StatusFound}},
+		{name: "RelativeFrom", redirect: content.Redirect{From: "old", To: "/new"}, wantErr: true},
+		{name: "HiddenFrom", redirect: content.Redirect{From: "/.auth/login", To: "/new"}, wantErr: true},
+		{name: "RelativeTo", redirect: content.Redirect{From: "/old", To: "new"}, wantErr: true},
+		{name: "SchemeRelativeTo", redirect: content.Redirect{From: "/old", To: "//www.example.com/new"}, wantErr: true},
+		{name: "OtherScheme", redirect: content.Redirect{From: "/old", To: "javascript:alert(1)"}, wantErr: true},
+		{name: "NotARedirectStatus", redirect: content.Redirect{From: "/old", To: "/new", Status: http.StatusOK}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.redirect.Check()
+			if got, want := err != nil, tc.wantErr; got != want {
+				t.Fatalf("got error %v, want error %t", err, want)
+			}
+		})
+	}
+}
+
+func TestFindRedirect(t *testing.T) {
+	redirects := []content.Redirect{
+		{From: "/blog", To: "/posts", Prefix: true},
+		{From: "/blog/2020", To: "https://archive.example.com/2020", Prefix: true},
+		{From: "/blog/about", To: "/about", Status: http.StatusFound},
+	}
+
+	testCases := []struct {
+		name  string
+		route string
+
+		wantOK       bool
+		wantLocation string
+		wantCode     int
+	}{
+		{name: "PrefixRoute", route: "/blog", wantOK: true, wantLocation: "/posts", wantCode: http.StatusMovedPermanently},
+		{name: "UnderPrefix", route: "/blog/shire", wantOK: true, wantLocation: "/posts/shire", wantCode: http.StatusMovedPermanently},
+		{name: "LongestPrefix", route: "/blog/2020/mordor", wantOK: true, wantLocation: "https://archive.example.com/2020/mordor", wantCode: http.StatusMovedPermanently},
+		{name: "ExactBeforePrefix", route: "/blog/about", wantOK: true, wantLocation: "/about", wantCode: http.StatusFound},
+		{name: "PrefixRespectsNames", route: "/blogroll", wantOK: false},
+		{name: "Unmatched", route: "/shire", wantOK: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			redirect, location, ok := content.FindRedirect(redirects, tc.route)
+			if got, want := ok, tc.wantOK; got != want {
+				t.Fatalf("got match %t, want %t", got, want)
+			}
+			if !ok {
+				return
+			}
+			if got, want := location, tc.wantLocation; got != want {
+				t.Fatalf("got location %q, want %q", got, want)
+			}
+			if got, want := redirect.Code(), tc.wantCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestRedirectConflicts(t *testing.T) {
+	redirects := []content.Redirect{{From: "/blog", To: "/posts", Prefix: true}}
+	articles := []content.Article{
+		{Path: "/blog/shire.md", Route: "/blog/shire"},
+		{Path: "/posts/shire.md", Route: "/posts/shire"},
+	}
+
+	errs := content.RedirectConflicts(redirects, articles)
+	if len(errs) != 1 {
+		t.Fatalf("got %d conflicts, want 1: %v", len(errs), errs)
+	}
+
+	if got, want := errs[0].Error(), "route /blog/shire of /blog/shire.md is redirected by the redirect from /blog"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
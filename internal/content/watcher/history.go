@@ -0,0 +1,133 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+// historyDir is where revisions are kept within the watched path. Since it is
+// hidden, documents within it are never turned into articles.
+const historyDir = ".history"
+
+// revisionFormat is the layout of a revision ID. IDs sort in the same order
+// as the times they were created.
+const revisionFormat = "20060102T150405.000000000Z"
+
+func (w *Watcher) now() time.Time {
+	if w.Clock == nil {
+		return time.Now()
+	}
+	return w.Clock.Now()
+}
+
+// revisionDir returns the directory the revisions of the document with the
+// given article path are kept in.
+func (w *Watcher) revisionDir(path string) string {
+	return filepath.Join(w.Path, historyDir, filepath.FromSlash(path))
+}
+
+// parseRevision validates a revision ID and returns the time it was created.
+func parseRevision(id string) (time.Time, error) {
+	t, err := time.Parse(revisionFormat, id)
+	if err != nil {
+		return time.Time{}, errors.Errorf("%w: %s", content.ErrRevisionNotFound, id)
+	}
+	return t, nil
+}
+
+// saveRevision keeps a copy of the document an article was generated from.
+func (w *Watcher) saveRevision(article content.Article) error {
+	data, err := os.ReadFile(article.FilePath)
+	if err != nil {
+		return err
+	}
+
+	dir := w.revisionDir(article.Path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	id := w.now().UTC().Format(revisionFormat)
+
+	return os.WriteFile(filepath.Join(dir, id), data, 0644)
+}
+
+// History returns the revisions kept of the document associated with the
+// given key, newest first.
+func (w *Watcher) History(key string) ([]content.Revision, error) {
+	if !content.ValidRoute(key) {
+		return nil, errors.Errorf("%w: %s", content.ErrArticleNotFound, key)
+	}
+
+	entries, err := os.ReadDir(w.revisionDir(key + ".md"))
+	if errors.Is(err, os.ErrNotExist) {
+		if _, err := w.Get(key); err != nil {
+			return nil, err
+		}
+		return []content.Revision{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []content.Revision{}
+	for _, entry := range entries {
+		t, err := parseRevision(entry.Name())
+		if entry.IsDir() || err != nil {
+			continue
+		}
+		revisions = append(revisions, content.Revision{ID: entry.Name(), Time: t})
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].ID > revisions[j].ID
+	})
+
+	return revisions, nil
+}
+
+// GetRevision returns the source of the document associated with the given
+// key as it was at a revision.
+func (w *Watcher) GetRevision(key string, id string) ([]byte, error) {
+	if !content.ValidRoute(key) {
+		return nil, errors.Errorf("%w: %s", content.ErrArticleNotFound, key)
+	}
+	if _, err := parseRevision(id); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(w.revisionDir(key+".md"), id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Errorf("%w: %s", content.ErrRevisionNotFound, id)
+	}
+
+	return data, err
+}
+
+// Restore replaces the document associated with the given key with its
+// source at a revision. The replaced document is kept as a new revision. If
+// the document was deleted, it is created again.
+func (w *Watcher) Restore(key string, id string) error {
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+
+	data, err := w.GetRevision(key, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := content.UnmarshalDocument(data); err != nil {
+		return errors.Errorf("revision %s is not a valid document: %w", id, err)
+	}
+
+	if _, err := w.Get(key); errors.Is(err, content.ErrArticleNotFound) {
+		return w.create(key, data)
+	}
+
+	return w.write(key, data, "")
+}
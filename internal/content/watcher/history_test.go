@@ -0,0 +1,211 @@
+package watcher_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/content/watcher"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func mustUnmarshal(t *testing.T, text string) content.Document {
+	doc, err := content.UnmarshalDocument([]byte(text))
+	if err != nil {
+		t.Fatalf("failed to unmarshal document: %v", err)
+	}
+	return doc
+}
+
+func TestHistory(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "ring.md")
+	original := "Title: Ring\n=== markdown ===\nOne ring to rule them all"
+	if err := os.WriteFile(file, []byte(original), 0644); err != nil {
+		t.Fatalf("failed to write document: %v", err)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := &watcher.Watcher{Path: root, Logger: log.NewNop(), Clock: tests.MockClock(now)}
+	if err := w.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+
+	revisions, err := w.History("/ring")
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	if len(revisions) != 0 {
+		t.Fatalf("got %d revisions before any update, want 0", len(revisions))
+	}
+
+	if err := w.Update("/ring", mustUnmarshal(t, "Title: Ring\n=== markdown ===\nOne ring to find them"), ""); err != nil {
+		t.Fatalf("failed to update document: %v", err)
+	}
+
+	revisions, err = w.History("/ring")
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("got %d revisions, want 1", len(revisions))
+	}
+	if got, want := revisions[0].Time, now; !got.Equal(want) {
+		t.Errorf("got revision time %v, want %v", got, want)
+	}
+
+	data, err := w.GetRevision("/ring", revisions[0].ID)
+	if err != nil {
+		t.Fatalf("failed to get revision: %v", err)
+	}
+	if got := string(data); got != original {
+		t.Fatalf("got revision %q, want %q", got, original)
+	}
+
+	w.Clock = tests.MockClock(now.Add(time.Minute))
+	if err := w.Restore("/ring", revisions[0].ID); err != nil {
+		t.Fatalf("failed to restore revision: %v", err)
+	}
+
+	data, err = os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read document: %v", err)
+	}
+	if got, want := string(data), "Title: Ring\n=== markdown ===\nOne ring to rule them all"; got != want {
+		t.Fatalf("got restored document %q, want %q", got, want)
+	}
+
+	revisions, err = w.History("/ring")
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	if len(revisions) != 2 || !revisions[0].Time.After(revisions[1].Time) {
+		t.Fatalf("got revisions %v, want 2 ordered newest first", revisions)
+	}
+}
+
+func TestUpdatePrecondition(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "ring.md")
+	original := "title: Ring\n=== markdown ===\nOne ring to rule them all"
+	if err := os.WriteFile(file, []byte(original), 0644); err != nil {
+		t.Fatalf("failed to write document: %v", err)
+	}
+
+	w := &watcher.Watcher{Path: root, Logger: log.NewNop()}
+	if err := w.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+
+	etag := content.ETag([]byte(original))
+	update := mustUnmarshal(t, "title: Ring\n=== markdown ===\nOne ring to find them")
+	if err := w.Update("/ring", update, etag); err != nil {
+		t.Fatalf("failed to update document: %v", err)
+	}
+
+	// The article hasn't been regenerated, but the document on disk no longer
+	// matches the ETag it was read with.
+	err := w.Update("/ring", mustUnmarshal(t, "title: Ring\n=== markdown ===\nOne ring to bring them all"), etag)
+	if !errors.Is(err, content.ErrPreconditionFailed) {
+		t.Fatalf("got error %v, want error %v", err, content.ErrPreconditionFailed)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read document: %v", err)
+	}
+	if got, want := string(data), "title: Ring\n=== markdown ===\nOne ring to find them"; got != want {
+		t.Fatalf("got document %q, want %q", got, want)
+	}
+}
+
+func TestGetRevisionInvalid(t *testing.T) {
+	root := t.TempDir()
+	w := &watcher.Watcher{Path: root, Logger: log.NewNop()}
+	if err := w.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		key  string
+		id   string
+
+		err error
+	}{
+		{
+			name: "MalformedID",
+			key:  "/ring",
+			id:   "../../ring.md",
+			err:  content.ErrRevisionNotFound,
+		},
+		{
+			name: "MissingRevision",
+			key:  "/ring",
+			id:   "20200101T000000.000000000Z",
+			err:  content.ErrRevisionNotFound,
+		},
+		{
+			name: "KeyOutsidePath",
+			key:  "/../ring",
+			id:   "20200101T000000.000000000Z",
+			err:  content.ErrArticleNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := w.GetRevision(tc.key, tc.id)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want error %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestRestoreDeleted(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "ring.md")
+	original := "Title: Ring\n=== markdown ===\nOne ring to rule them all"
+	if err := os.WriteFile(file, []byte(original), 0644); err != nil {
+		t.Fatalf("failed to write document: %v", err)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := &watcher.Watcher{Path: root, Logger: log.NewNop(), Clock: tests.MockClock(now)}
+	if err := w.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+
+	if err := w.Delete("/ring"); err != nil {
+		t.Fatalf("failed to delete document: %v", err)
+	}
+
+	revisions, err := w.History("/ring")
+	if err != nil {
+		t.Fatalf("failed to get history of a deleted document: %v", err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("got %d revisions, want 1", len(revisions))
+	}
+
+	if err := w.Restore("/ring", revisions[0].ID); err != nil {
+		t.Fatalf("failed to restore deleted document: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read document: %v", err)
+	}
+	if got := string(data); got != original {
+		t.Fatalf("got restored document %q, want %q", got, original)
+	}
+	if _, err := w.Get("/ring"); err != nil {
+		t.Fatalf("failed to get restored article: %v", err)
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/toddgaunt/bastion/internal/clock"
 	"github.com/toddgaunt/bastion/internal/content"
 	"github.com/toddgaunt/bastion/internal/errors"
 	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/search"
 )
 
 // Watcher reads documents from the filesystem on whenever it detects they
@@ -24,10 +27,18 @@ type Watcher struct {
 	Logger log.Logger
 	// Details
 	Details content.Details
+	// Index is kept up to date with every article the watcher generates, if
+	// it is set.
+	Index *search.Index
+	// Clock timestamps revisions, and decides which articles are visible.
+	// The local clock is used if it isn't set.
+	Clock clock.Provider
 
 	// Internal state
 	mutex      sync.RWMutex
 	articleMap map[string]content.Article
+	// writeMutex serializes modifications to documents.
+	writeMutex sync.Mutex
 }
 
 // Get returns a single article associated with the given key.
@@ -37,36 +48,281 @@ func (w *Watcher) Get(key string) (content.Article, error) {
 
 	article, ok := w.articleMap[key+".md"]
 	if !ok {
-		return content.Article{}, errors.New("article does not exist")
+		return content.Article{}, errors.Errorf("%w: %s", content.ErrArticleNotFound, key)
 	}
 
 	return article, nil
 }
 
-// Update modifies the underlying document associated with the given key.
-func (w *Watcher) Update(key string, doc content.Document) error {
+// GetAlias returns the article that lists route as one of its aliases. If
+// more than one article does, the one with the first path is returned so that
+// the conflict at least resolves the same way every time.
+func (w *Watcher) GetAlias(route string) (content.Article, error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	var found content.Article
+	for _, article := range w.articleMap {
+		if article.Err != nil || (found.Path != "" && found.Path < article.Path) {
+			continue
+		}
+		for _, alias := range article.Aliases {
+			if alias == route {
+				found = article
+				break
+			}
+		}
+	}
+
+	if found.Path == "" {
+		return content.Article{}, errors.Errorf("%w: %s", content.ErrArticleNotFound, route)
+	}
+
+	return found, nil
+}
+
+// Update modifies the underlying document associated with the given key. The
+// previous version of the document is kept as a revision. If etag isn't empty
+// and the document on disk no longer matches it, ErrPreconditionFailed is
+// returned and nothing is modified.
+func (w *Watcher) Update(key string, doc content.Document, etag string) error {
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+
+	bytes, err := content.MarshalDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	return w.write(key, bytes, etag)
+}
+
+// write replaces the source of the document associated with the given key,
+// keeping the previous source as a revision. The caller must hold writeMutex.
+func (w *Watcher) write(key string, bytes []byte, etag string) error {
 	article, err := w.Get(key)
 	if err != nil {
 		return err
 	}
 
+	// The article map is only refreshed once the filesystem notifies the
+	// watcher, so the document is read again to compare against its latest
+	// version.
+	if etag != "" {
+		current := content.GenerateArticle(w.Path, article.FilePath)
+		if current.ETag() != etag {
+			return errors.Errorf("%w: %s", content.ErrPreconditionFailed, key)
+		}
+	}
+
+	if err := w.saveRevision(article); err != nil {
+		return errors.Errorf("failed to keep revision: %w", err)
+	}
+
+	err = os.WriteFile(article.FilePath, bytes, 0755)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// documentPath returns the path of the document associated with the given
+// key, or ErrInvalidKey if the key can't be associated with a document.
+func (w *Watcher) documentPath(key string) (string, error) {
+	if !content.ValidRoute(key) {
+		return "", errors.Errorf("%w: %s", content.ErrInvalidKey, key)
+	}
+
+	return filepath.Join(w.Path, filepath.FromSlash(key)+".md"), nil
+}
+
+// Create writes a new document associated with the given key, creating any
+// directories leading to it. If a document is already associated with the
+// key, ErrArticleExists is returned.
+func (w *Watcher) Create(key string, doc content.Document) error {
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+
 	bytes, err := content.MarshalDocument(doc)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(article.FilePath, bytes, 0755)
+	return w.create(key, bytes)
+}
+
+// create writes the source of a new document associated with the given key.
+// The caller must hold writeMutex.
+func (w *Watcher) create(key string, bytes []byte) error {
+	filePath, err := w.documentPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return errors.Errorf("%w: %s", content.ErrArticleExists, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(bytes); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// The article is generated now, rather than when the filesystem notifies
+	// the watcher, so that it can be requested as soon as this returns.
+	w.generate(filePath)
+
+	return nil
+}
+
+// Delete removes the document associated with the given key. The document is
+// kept as a revision, so that it can be recovered with Restore.
+func (w *Watcher) Delete(key string) error {
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+
+	if _, err := w.documentPath(key); err != nil {
+		return err
+	}
+
+	article, err := w.Get(key)
 	if err != nil {
 		return err
 	}
 
+	if err := w.saveRevision(article); err != nil {
+		return errors.Errorf("failed to keep revision: %w", err)
+	}
+
+	if err := os.Remove(article.FilePath); err != nil {
+		return err
+	}
+
+	w.remove(article.Path)
+
 	return nil
 }
 
-// GetAll returns all articles generated from documents that are not unlisted.
-// Only articles with the given value of pinned are returned.
+// generate generates an article from the document at filePath and adds it.
+func (w *Watcher) generate(filePath string) content.Article {
+	article := content.GenerateArticle(w.Path, filePath)
+	w.add(article)
+
+	return article
+}
+
+// add adds an article to the article map and search index, and logs any
+// route it now shares with another article.
+func (w *Watcher) add(article content.Article) {
+	w.mutex.Lock()
+	w.articleMap[article.Path] = article
+	shared := w.sharingRoutes(article)
+	w.mutex.Unlock()
+
+	if w.Index != nil {
+		w.Index.Add(article)
+	}
+
+	w.logConflicts("add", shared)
+}
+
+// sharingRoutes returns the articles that are served from the route or one
+// of the aliases of an article, including the article itself. The caller
+// must hold mutex.
+func (w *Watcher) sharingRoutes(article content.Article) []content.Article {
+	if article.Err != nil {
+		return nil
+	}
+
+	routes := map[string]bool{article.Route: true}
+	for _, alias := range article.Aliases {
+		routes[alias] = true
+	}
+
+	var shared []content.Article
+	for _, other := range w.articleMap {
+		if other.Err != nil {
+			continue
+		}
+		if routes[other.Route] {
+			shared = append(shared, other)
+			continue
+		}
+		for _, alias := range other.Aliases {
+			if routes[alias] {
+				shared = append(shared, other)
+				break
+			}
+		}
+	}
+
+	return shared
+}
+
+// logConflicts logs a warning for every route more than one of the articles
+// is served from.
+func (w *Watcher) logConflicts(op string, articles []content.Article) {
+	for _, err := range content.RouteConflicts(articles) {
+		w.Logger.With("op", op, "err", err).Print(log.Warn, "route conflict")
+	}
+}
+
+// remove removes the article with the given path from the article map and
+// search index.
+func (w *Watcher) remove(path string) {
+	w.mutex.Lock()
+	delete(w.articleMap, path)
+	w.mutex.Unlock()
+
+	if w.Index != nil {
+		w.Index.Remove(path)
+	}
+}
+
+// GetAll returns all articles generated from documents that are not unlisted
+// or drafts, and are visible at the time of the watcher's clock. Only articles with the
+// given value of pinned are returned.
 // TODO: Maybe pass in a string rather than a bool for pinned? That way we can just GetAll("category")?
 func (w *Watcher) GetAll(pinned bool) []content.Article {
+	return w.getAll(pinned, w.now())
+}
+
+// GetTagged returns all articles that are listed, as they are by GetAll, and
+// have the given tag, in the same order as GetAll.
+func (w *Watcher) GetTagged(tag string) []content.Article {
+	return w.getTagged(tag, w.now())
+}
+
+// Tags returns every tag used by an article that is listed, as they are by
+// GetAll, along with the number of articles using it.
+func (w *Watcher) Tags() map[string]int {
+	return w.tags(w.now())
+}
+
+// AsOf returns a view of the watcher in which the articles visible at a time
+// are listed.
+func (w *Watcher) AsOf(t time.Time) content.Store {
+	return view{Watcher: w, now: t}
+}
+
+// listed returns true if an article is listed at a time.
+func listed(article content.Article, now time.Time) bool {
+	return !article.Unlisted && !article.Draft && article.Visible(now)
+}
+
+func (w *Watcher) getAll(pinned bool, now time.Time) []content.Article {
 	w.mutex.RLock()
 	defer w.mutex.RUnlock()
 
@@ -74,34 +330,134 @@ func (w *Watcher) GetAll(pinned bool) []content.Article {
 	for _, v := range w.articleMap {
 		// Only add pinned articles to the list
 		// Don't add unlisted articles
-		if v.Pinned == pinned  && !v.Unlisted {
+		if v.Pinned == pinned && listed(v, now) {
 			list = append(list, v)
 		}
 	}
 
-	sort.Slice(list, func(i int, j int) bool {
+	sortArticles(list)
+
+	return list
+}
+
+func (w *Watcher) getTagged(tag string, now time.Time) []content.Article {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	list := []content.Article{}
+	for _, v := range w.articleMap {
+		if !listed(v, now) {
+			continue
+		}
+		for _, t := range v.Tags {
+			if t == tag {
+				list = append(list, v)
+				break
+			}
+		}
+	}
+
+	sortArticles(list)
+
+	return list
+}
+
+func (w *Watcher) tags(now time.Time) map[string]int {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	tags := make(map[string]int)
+	for _, v := range w.articleMap {
+		if !listed(v, now) {
+			continue
+		}
+		for _, t := range v.Tags {
+			tags[t]++
+		}
+	}
+
+	return tags
+}
+
+// view is a watcher as it is at a time other than that of its clock. Every
+// other method is the watcher's own.
+type view struct {
+	*Watcher
+	now time.Time
+}
+
+func (v view) GetAll(pinned bool) []content.Article {
+	return v.getAll(pinned, v.now)
+}
+
+func (v view) GetTagged(tag string) []content.Article {
+	return v.getTagged(tag, v.now)
+}
+
+func (v view) Tags() map[string]int {
+	return v.tags(v.now)
+}
+
+func (v view) AsOf(t time.Time) content.Store {
+	return view{Watcher: v.Watcher, now: t}
+}
+
+// sortArticles sorts articles from newest to oldest, and then by title.
+func sortArticles(list []content.Article) {
+	sort.SliceStable(list, func(i int, j int) bool {
+		if !list[i].Created.Equal(list[j].Created) {
+			return list[i].Created.After(list[j].Created)
+		}
 		return list[i].Title < list[j].Title
 	})
+}
+
+// GetDetails returns the details of the content.
+func (w *Watcher) GetDetails() content.Details {
+	return w.Details
+}
+
+// Articles returns every article the watcher has generated, including those
+// that are unlisted or failed to generate, ordered by path.
+func (w *Watcher) Articles() []content.Article {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	list := make([]content.Article, 0, len(w.articleMap))
+	for _, v := range w.articleMap {
+		list = append(list, v)
+	}
 
 	sort.Slice(list, func(i int, j int) bool {
-		return list[i].Created.After(list[j].Created)
+		return list[i].Path < list[j].Path
 	})
 
 	return list
 }
 
-// GetDetails returns the details of the content.
-func (w *Watcher) GetDetails() content.Details {
-	return w.Details
+// Load generates articles from every document found under w.Path once,
+// without watching for changes. This is useful when the content only needs to
+// be read, rather than served.
+func (w *Watcher) Load() error {
+	articles, err := watchArticles(w.Path, w.Path, nil)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	w.articleMap = articles
+	w.mutex.Unlock()
+
+	return nil
 }
 
-// watchArticles walks a directory to find all subdirectories and add them to
-// the watcher. Each document found within a subdirectory is used to generate
-// an article.
-func watchArticles(root string, watcher *fsnotify.Watcher) (map[string]content.Article, error) {
+// watchArticles walks a directory within root to find all subdirectories and
+// add them to the watcher, if one is provided. Each document found within a
+// subdirectory is used to generate an article.
+func watchArticles(root string, dir string, watcher *fsnotify.Watcher) (map[string]content.Article, error) {
 	articles := make(map[string]content.Article)
 
-	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -123,7 +479,9 @@ func watchArticles(root string, watcher *fsnotify.Watcher) (map[string]content.A
 		// Only directories should be watched. Files shouldn't be watched
 		// directly. This allows us to detect when files are added or removed.
 		if info.IsDir() {
-			watcher.Add(filePath)
+			if watcher != nil {
+				watcher.Add(filePath)
+			}
 		} else {
 			article := content.GenerateArticle(root, filePath)
 			articles[article.Path] = article
@@ -139,6 +497,14 @@ func watchArticles(root string, watcher *fsnotify.Watcher) (map[string]content.A
 	return articles, nil
 }
 
+// logWarnings logs the warnings about the document of an article, if there
+// are any.
+func logWarnings(logger log.Logger, article content.Article) {
+	if article.Warnings != nil {
+		logger.With("warnings", article.Warnings.Error()).Print(log.Warn, "document has warnings")
+	}
+}
+
 func logStatus(b bool) string {
 	if b {
 		return "ok"
@@ -158,23 +524,38 @@ func (w *Watcher) Start(done chan bool, wg *sync.WaitGroup) {
 		return
 	}
 
-	articles, err := watchArticles(w.Path, watcher)
+	articles, err := watchArticles(w.Path, w.Path, watcher)
 	if err != nil {
 		w.Logger.Printf(log.Fatal, "failed to watch articles: %v", err)
 	}
 
 	for _, article := range articles {
-		w.Logger.With(
+		logger := w.Logger.With(
 			"op", "init",
 			"route", article.Route,
-			"err", article.Err,
-		).Print(log.Info, "init")
+		)
+		logger.With("err", article.Err).Print(log.Info, "init")
+		logWarnings(logger, article)
+	}
+
+	list := make([]content.Article, 0, len(articles))
+	for _, article := range articles {
+		if article.Err == nil {
+			list = append(list, article)
+		}
 	}
+	w.logConflicts("init", list)
 
 	w.mutex.Lock()
 	w.articleMap = articles
 	w.mutex.Unlock()
 
+	if w.Index != nil {
+		for _, article := range articles {
+			w.Index.Add(article)
+		}
+	}
+
 	go func() {
 	loop:
 		for {
@@ -193,10 +574,7 @@ func (w *Watcher) Start(done chan bool, wg *sync.WaitGroup) {
 
 				if op.Has(fsnotify.Remove) || op.Has(fsnotify.Rename) {
 					logger.Print(log.Info, "watch")
-
-					w.mutex.Lock()
-					delete(w.articleMap, path)
-					w.mutex.Unlock()
+					w.remove(path)
 				}
 
 				if op.Has(fsnotify.Create) || op.Has(fsnotify.Write) {
@@ -213,19 +591,25 @@ func (w *Watcher) Start(done chan bool, wg *sync.WaitGroup) {
 					// watched directly.
 					if isDir {
 						logger.Print(log.Info, "watch")
-						watcher.Add(event.Name)
+						// Directories may be created along with their
+						// contents, such as by Create, before the watcher is
+						// told about them.
+						articles, err := watchArticles(w.Path, event.Name, watcher)
+						if err != nil {
+							logger.With("err", err.Error()).Print(log.Error, "failed to watch articles")
+						}
+						for _, article := range articles {
+							w.add(article)
+						}
 						break
 					}
 
-					article := content.GenerateArticle(w.Path, event.Name)
+					article := w.generate(event.Name)
 
 					logger.With(
 						"err", article.Err,
 					).Print(log.Info, "watch")
-
-					w.mutex.Lock()
-					w.articleMap[article.Path] = article
-					w.mutex.Unlock()
+					logWarnings(logger, article)
 				}
 			case err, ok := <-watcher.Errors:
 				logger := w.Logger
@@ -0,0 +1,256 @@
+package watcher_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/content/watcher"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/search"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func TestCreate(t *testing.T) {
+	root := t.TempDir()
+	index := search.New()
+	w := &watcher.Watcher{Path: root, Logger: log.NewNop(), Index: index}
+	if err := w.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+
+	doc := mustUnmarshal(t, "title: Rivendell\n=== markdown ===\nThe last homely house")
+
+	testCases := []struct {
+		name string
+		key  string
+
+		err error
+	}{
+		{
+			name: "Nested",
+			key:  "/places/elves/rivendell",
+		},
+		{
+			name: "Exists",
+			key:  "/places/elves/rivendell",
+			err:  content.ErrArticleExists,
+		},
+		{
+			name: "Traversal",
+			key:  "/../rivendell",
+			err:  content.ErrInvalidKey,
+		},
+		{
+			name: "Hidden",
+			key:  "/.history/rivendell",
+			err:  content.ErrInvalidKey,
+		},
+		{
+			name: "Root",
+			key:  "/",
+			err:  content.ErrInvalidKey,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := w.Create(tc.key, doc)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want error %v", err, tc.err)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "places", "elves", "rivendell.md")); err != nil {
+		t.Fatalf("document wasn't created: %v", err)
+	}
+
+	article, err := w.Get("/places/elves/rivendell")
+	if err != nil {
+		t.Fatalf("failed to get created article: %v", err)
+	}
+	if got, want := article.Title, "Rivendell"; got != want {
+		t.Errorf("got title %q, want %q", got, want)
+	}
+
+	if got := index.Search("homely", 10, time.Now()); len(got) != 1 {
+		t.Errorf("got %d search results for the created article, want 1", len(got))
+	}
+}
+
+func TestDelete(t *testing.T) {
+	root := t.TempDir()
+	original := "title: Mordor\n=== markdown ===\nOne does not simply walk into Mordor"
+	if err := os.WriteFile(filepath.Join(root, "mordor.md"), []byte(original), 0644); err != nil {
+		t.Fatalf("failed to write document: %v", err)
+	}
+
+	w := &watcher.Watcher{Path: root, Logger: log.NewNop()}
+	if err := w.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+
+	if err := w.Delete("/mordor"); err != nil {
+		t.Fatalf("failed to delete document: %v", err)
+	}
+
+	if _, err := w.Get("/mordor"); !errors.Is(err, content.ErrArticleNotFound) {
+		t.Fatalf("got error %v, want error %v", err, content.ErrArticleNotFound)
+	}
+	if _, err := os.Stat(filepath.Join(root, "mordor.md")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %v, want error %v", err, os.ErrNotExist)
+	}
+
+	revisions, err := w.History("/mordor")
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("got %d revisions, want 1", len(revisions))
+	}
+
+	if err := w.Delete("/mordor"); !errors.Is(err, content.ErrArticleNotFound) {
+		t.Fatalf("got error %v, want error %v", err, content.ErrArticleNotFound)
+	}
+	if err := w.Delete("/../mordor"); !errors.Is(err, content.ErrInvalidKey) {
+		t.Fatalf("got error %v, want error %v", err, content.ErrInvalidKey)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	root := t.TempDir()
+	// Isengard is a draft, so it is never listed whatever the time.
+	documents := map[string]string{
+		"shire.md":     "title: Shire\ntag: places\n=== markdown ===\nHobbits",
+		"rivendell.md": "title: Rivendell\ntag: elves\npublish: 2024-06-01T09:00:00-04:00\n=== markdown ===\nElves",
+		"moria.md":     "title: Moria\ntag: dwarves\nexpires: 2024-06-01T13:00:00Z\n=== markdown ===\nDwarves",
+		"isengard.md":  "title: Isengard\ntag: places\ndraft: true\n=== markdown ===\nWizards",
+	}
+	for name, text := range documents {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(text), 0644); err != nil {
+			t.Fatalf("failed to write document: %v", err)
+		}
+	}
+
+	// The publish time of Rivendell and the expiry of Moria are the same
+	// instant in different timezones.
+	instant := time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC)
+
+	w := &watcher.Watcher{Path: root, Logger: log.NewNop(), Clock: tests.MockClock(instant.Add(-time.Second))}
+	if err := w.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+
+	titles := func(articles []content.Article) []string {
+		list := []string{}
+		for _, article := range articles {
+			list = append(list, article.Title)
+		}
+		sort.Strings(list)
+		return list
+	}
+
+	if got, want := titles(w.GetAll(false)), []string{"Moria", "Shire"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v before publishing, want %v", got, want)
+	}
+	if got := w.GetTagged("elves"); len(got) != 0 {
+		t.Fatalf("got %v tagged before publishing, want none", titles(got))
+	}
+
+	// Scheduled articles can still be edited.
+	if _, err := w.Get("/rivendell"); err != nil {
+		t.Fatalf("failed to get scheduled article: %v", err)
+	}
+
+	view := w.AsOf(instant)
+	if got, want := titles(view.GetAll(false)), []string{"Rivendell", "Shire"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v as of publishing, want %v", got, want)
+	}
+	if got, want := view.Tags(), map[string]int{"places": 1, "elves": 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got tags %v as of publishing, want %v", got, want)
+	}
+
+	w.Clock = tests.MockClock(instant)
+	if got, want := titles(w.GetAll(false)), []string{"Rivendell", "Shire"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v once published, want %v", got, want)
+	}
+}
+
+func TestGetAlias(t *testing.T) {
+	root := t.TempDir()
+	documents := map[string]string{
+		"rivendell.md": "title: Rivendell\nalias: /imladris\nalias: /last-homely-house\n=== markdown ===\nElves",
+		"moria.md":     "title: Moria\nalias: /khazad-dum\n=== markdown ===\nDwarves",
+	}
+	for name, text := range documents {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(text), 0644); err != nil {
+			t.Fatalf("failed to write document: %v", err)
+		}
+	}
+
+	w := &watcher.Watcher{Path: root, Logger: log.NewNop()}
+	if err := w.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+
+	testCases := []struct {
+		name  string
+		route string
+
+		wantRoute string
+		err       error
+	}{
+		{name: "Alias", route: "/imladris", wantRoute: "/rivendell"},
+		{name: "SecondAlias", route: "/last-homely-house", wantRoute: "/rivendell"},
+		{name: "OtherArticle", route: "/khazad-dum", wantRoute: "/moria"},
+		{name: "Route", route: "/rivendell", err: content.ErrArticleNotFound},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			article, err := w.GetAlias(tc.route)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want error %v", err, tc.err)
+			}
+			if got, want := article.Route, tc.wantRoute; got != want {
+				t.Fatalf("got article %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateConflict(t *testing.T) {
+	root := t.TempDir()
+	document := "title: Rivendell\nalias: /imladris\n=== markdown ===\nElves"
+	if err := os.WriteFile(filepath.Join(root, "rivendell.md"), []byte(document), 0644); err != nil {
+		t.Fatalf("failed to write document: %v", err)
+	}
+
+	logger, entries := log.NewRecorder()
+	w := &watcher.Watcher{Path: root, Logger: logger}
+	if err := w.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+
+	if err := w.Create("/moria", mustUnmarshal(t, "title: Moria\n=== markdown ===\nDwarves")); err != nil {
+		t.Fatalf("failed to create document: %v", err)
+	}
+	if got, want := len(*entries), 0; got != want {
+		t.Fatalf("got %d logs creating an article without conflicts, want %d", got, want)
+	}
+
+	if err := w.Create("/imladris", mustUnmarshal(t, "title: Imladris\n=== markdown ===\nElves")); err != nil {
+		t.Fatalf("failed to create document: %v", err)
+	}
+	if got, want := *entries, []log.Entry{{Level: log.Warn, Message: "route conflict"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got logs %v, want %v", got, want)
+	}
+}
@@ -71,6 +71,10 @@ var Is = errors.Is
 // Each call to New returns a distinct error value even if the text is identical.
 var New = errors.New
 
+// Join returns an error that wraps the given errors. Any nil error values are
+// discarded. Join returns nil if every value in errs is nil.
+var Join = errors.Join
+
 // Unwrap returns the result of calling the Unwrap method on err, if err's
 // type contains an Unwrap method returning error.
 // Otherwise, Unwrap returns nil.
@@ -159,8 +163,6 @@ type Note struct {
 
 func getLocation() (string, int) {
 	_, file, line, _ := runtime.Caller(2)
-
-	fmt.Println("Module prefix:", ModulePrefix)
 	file, _ = strings.CutPrefix(file, ModulePrefix)
 
 	return file, line
@@ -0,0 +1,31 @@
+// Package files writes the files Bastion keeps its state in.
+package files
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces the contents of a file all at once, so that the file is
+// never seen partially written, and gives it the permissions perm.
+func WriteFile(name string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,47 @@
+package files_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/toddgaunt/bastion/internal/files"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "keys.json")
+
+	if err := os.WriteFile(name, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := files.WriteFile(name, []byte("new"), 0600); err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if got, want := string(data), "new"; got != want {
+		t.Fatalf("got contents %q, want %q", got, want)
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if got, want := info.Mode().Perm(), os.FileMode(0600); got != want {
+		t.Fatalf("got permissions %v, want %v", got, want)
+	}
+
+	// Nothing is left behind in the directory.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if got, want := len(entries), 1; got != want {
+		t.Fatalf("got %d files in the directory, want %d", got, want)
+	}
+}
@@ -30,19 +30,136 @@ func ArticlePath(next http.Handler) http.Handler {
 	})
 }
 
+// authenticateArticle checks the credentials of a request for an article that
+// requires authentication. Articles that don't require authentication are
+// always allowed. Articles with a group require an access token granting the
+// group's role, and other protected articles require their own username and
+// password, or the cookie issued once they have been given.
+func (env Env) authenticateArticle(w http.ResponseWriter, r *http.Request, article content.Article) errors.Problem {
+	const op = "GetArticle/authenticate"
+
+	if article.Group != "" {
+		claims, prob := env.verifyRequest(r)
+		if prob != nil {
+			w.Header().Set("Www-Authenticate", `Bearer realm="restricted"`)
+			return prob
+		}
+
+		if !claims.HasRole(article.Group) {
+			return errors.Note{
+				Op:         op,
+				Title:      "Forbidden",
+				StatusCode: http.StatusForbidden,
+				Detail:     fmt.Sprintf("only the %s group can view this article", article.Group),
+			}.Wrap(errors.Errorf("%q isn't in the %s group", claims.Username, article.Group))
+		}
+
+		return nil
+	}
+
+	if article.Authenticator == nil {
+		return nil
+	}
+
+	// Checking the cookie avoids hashing the password on every request.
+	if env.verifyArticleCookie(r, article) {
+		return nil
+	}
+
+	username, password, ok := r.BasicAuth()
+
+	if !ok {
+		// Browsers are shown a login form instead of a basic auth dialog.
+		if !acceptsHTML(r) {
+			w.Header().Set("Www-Authenticate", `Basic realm="restricted"`)
+		}
+		return errors.Note{
+			Op:         op,
+			Type:       ErrArticleLogin,
+			Title:      "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+			Detail:     "user must enter basic auth",
+		}.Wrap(errors.New("user must enter basic auth"))
+	}
+
+	keys := env.attemptKeys(r, article.Route, username)
+	if prob := env.allowAttempt(w, keys); prob != nil {
+		return prob
+	}
+
+	_, err := article.Authenticator.Authenticate(username, password)
+	if err != nil {
+		env.failAttempt(keys)
+		w.Header().Set("Www-Authenticate", `Basic realm="restricted"`)
+		return errors.Note{
+			Op:         op,
+			Title:      "Forbidden",
+			StatusCode: http.StatusForbidden,
+			Detail:     "invalid username and password",
+		}.Wrap(err)
+	}
+
+	env.succeedAttempt(keys)
+	env.Logger.Print(log.Info, "authentication success")
+
+	if err := env.setArticleCookie(w, r, article, username); err != nil {
+		env.Logger.With("err", err.Error()).Print(log.Error, "failed to issue article cookie")
+	}
+
+	return nil
+}
+
+// matchETag returns true if a list of entity tags from an If-Match or
+// If-None-Match header contains etag, or is "*". Weak tags only match when
+// weak is true.
+func matchETag(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// alias returns the route of the article a route redirects to, if no article
+// is located at the route but a visible one lists it as an alias.
+func (env Env) alias(r *http.Request, route string) (string, bool) {
+	if _, err := env.Store.Get(route); !errors.Is(err, content.ErrArticleNotFound) {
+		return "", false
+	}
+
+	article, err := env.Store.GetAlias(route)
+	if err != nil {
+		return "", false
+	}
+	if _, err := env.visibleArticle(r, article.Route); err != nil {
+		return "", false
+	}
+
+	return article.Route, true
+}
+
 // GetArticle returns an HTTP handler function to respond to HTTP requests for
 // an article. The handler will write an HTML representation of an article as
 // a response, or a problemjson response if the article does not exist or there
-// was a problem generating it.
+// was a problem generating it. Responses carry an ETag of the article's text,
+// and requests with a matching If-None-Match are answered with 304 Not
+// Modified. A request for an alias of an article is permanently redirected to
+// the article.
 func (env Env) GetArticle(w http.ResponseWriter, r *http.Request) {
 	const op = "GetArticle"
 	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
 		articleID := r.Context().Value(articlesCtxKey).(string)
 
 		var markdown []byte
+		var etag string
 		var vars templateVariables
 		var getArticle = func(articleKey string) errors.Problem {
-			article, err := env.Store.Get(articleKey)
+			article, err := env.visibleArticle(r, articleKey)
 
 			if err != nil {
 				return errors.Note{
@@ -61,57 +178,58 @@ func (env Env) GetArticle(w http.ResponseWriter, r *http.Request) {
 				}.Wrap(article.Err)
 			}
 
-			// Authentication
-			if article.Authenticator != nil {
-				username, password, ok := r.BasicAuth()
-
-				if !ok {
-					w.Header().Set("Www-Authenticate", `Basic realm="restricted"`)
-					return errors.Note{
-						Op:         op + "/authenticate",
-						Title:      "Unauthorized",
-						StatusCode: http.StatusUnauthorized,
-						Detail:     "user must enter basic auth",
-					}.Wrap(errors.New("user must enter basic auth"))
-				}
-
-				_, err := article.Authenticator.Authenticate(username, password)
-				if err != nil {
-					w.Header().Set("Www-Authenticate", `Basic realm="restricted"`)
-					return errors.Note{
-						Op:         op + "/authenticate",
-						Title:      "Forbidden",
-						StatusCode: http.StatusForbidden,
-						Detail:     "invalid username and password",
-					}.Wrap(err)
-				}
+			if prob := env.authenticateArticle(w, r, article); prob != nil {
+				return env.articleLogin(w, r, article, prob)
+			}
 
-				env.Logger.Print(log.Info, "authentication success")
+			// Share links must not be passed on to the sites a draft links
+			// to, or kept by caches.
+			if article.Draft {
+				w.Header().Set("Cache-Control", "no-store")
+				w.Header().Set("Referrer-Policy", "no-referrer")
 			}
 
 			markdown = article.Text
+			etag = article.ETag()
 			vars = templateVariables{
 				Title:       article.Title,
 				Description: article.Description,
 				HTML:        article.HTML,
-				content:     env.Store,
+				Tags:        article.Tags,
+				Route:       article.Route,
+				Protected:   article.Authenticator != nil,
+				content:     env.store(r),
 			}
 
 			return nil
 		}
 
-		if strings.HasSuffix(articleID, ".md") {
-			err := getArticle(strings.TrimSuffix(articleID, ".md"))
-			if err != nil {
-				return err
+		source := strings.HasSuffix(articleID, ".md")
+		if target, ok := env.alias(r, strings.TrimSuffix(articleID, ".md")); ok {
+			if source {
+				target += ".md"
+			}
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
 			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return nil
+		}
+
+		if err := getArticle(strings.TrimSuffix(articleID, ".md")); err != nil {
+			return err
+		}
+
+		w.Header().Set("ETag", etag)
+		if match := r.Header.Get("If-None-Match"); match != "" && matchETag(match, etag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		if source {
 			w.Header().Add("Content-Type", "text")
 			w.Write(markdown)
 		} else {
-			err := getArticle(articleID)
-			if err != nil {
-				return err
-			}
 			buf := &bytes.Buffer{}
 			articleTemplate.Execute(buf, vars)
 
@@ -126,43 +244,189 @@ func (env Env) GetArticle(w http.ResponseWriter, r *http.Request) {
 	handleError(w, err, env.Logger)
 }
 
+// readDocument reads a document from the body of a request.
+func readDocument(r *http.Request) (content.Document, errors.Problem) {
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		return content.Document{}, errors.Note{
+			StatusCode: http.StatusInternalServerError,
+			Detail:     "failed to read request",
+		}.Wrap(err)
+	}
+
+	doc, err := content.UnmarshalDocument(bytes)
+	if err != nil {
+		return content.Document{}, errors.Note{
+			StatusCode: http.StatusBadRequest,
+			Detail:     "failed to parse document",
+		}.Wrap(err)
+	}
+
+	return doc, nil
+}
+
 // UpdateDocument returns an HTTP handler function to respond to HTTP requests
 // to update an article. The handler will update the underlying representation
 // of an article and reply with a 200 OK, or problemjson response if the
-// article does not exist or there was a problem updating it.
+// article does not exist or there was a problem updating it. If the request
+// has an If-Match header, the article is only updated if the header matches
+// the ETag of the article, otherwise the handler replies with 412
+// Precondition Failed.
 func (env Env) UpdateDocument(w http.ResponseWriter, r *http.Request) {
 	const op = "Update"
 	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
 		articleID := r.Context().Value(articlesCtxKey).(string)
 		articleID = strings.TrimSuffix(articleID, ".md")
 
-		bytes, err := io.ReadAll(r.Body)
-		if err != nil {
+		doc, prob := readDocument(r)
+		if prob != nil {
+			return prob
+		}
+
+		notFound := errors.Note{
+			Title:      "Article Not Found",
+			StatusCode: http.StatusNotFound,
+			Detail:     fmt.Sprintf("No article located at %s", articleID),
+		}
+		preconditionFailed := errors.Note{
+			Title:      "Precondition Failed",
+			StatusCode: http.StatusPreconditionFailed,
+			Detail:     "the document was modified since it was read",
+		}
+
+		// A single entity tag is compared by the store against the document
+		// as it is on disk, since the article may not have been regenerated
+		// since the last update. Any other list is compared against the
+		// article to choose the tag it must still match.
+		var etag string
+		if match := r.Header.Get("If-Match"); match != "" {
+			tags := strings.Split(match, ",")
+			if len(tags) == 1 && strings.TrimSpace(match) != "*" {
+				etag = strings.TrimSpace(match)
+			} else {
+				article, err := env.Store.Get(articleID)
+				if err != nil {
+					return notFound.Wrap(err)
+				}
+
+				etag = article.ETag()
+				if !matchETag(match, etag, false) {
+					return preconditionFailed.Wrap(errors.Errorf("%w: %s", content.ErrPreconditionFailed, articleID))
+				}
+			}
+		}
+
+		err := env.Store.Update(articleID, doc, etag)
+		switch {
+		case errors.Is(err, content.ErrArticleNotFound):
+			return notFound.Wrap(err)
+		case errors.Is(err, content.ErrPreconditionFailed):
+			return preconditionFailed.Wrap(err)
+		case err != nil:
 			return errors.Note{
 				StatusCode: http.StatusInternalServerError,
-				Detail:     "failed to read request",
+				Detail:     "failed to update document",
 			}.Wrap(err)
 		}
 
-		doc, err := content.UnmarshalDocument(bytes)
-		if err != nil {
+		env.Logger.With("articleID", articleID).Print(log.Info, "Updated Document")
+
+		if text, err := content.MarshalDocument(doc); err == nil {
+			w.Header().Set("ETag", content.ETag(text))
+		}
+		w.WriteHeader(http.StatusOK)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// CreateDocument returns an HTTP handler function to respond to HTTP requests
+// to create an article. The handler will write a new document for the article
+// and reply with a 201 Created, or problemjson response if an article already
+// exists at the route or the route can't be used for an article.
+func (env Env) CreateDocument(w http.ResponseWriter, r *http.Request) {
+	const op = "Create"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		articleID := r.Context().Value(articlesCtxKey).(string)
+		articleID = strings.TrimSuffix(articleID, ".md")
+
+		doc, prob := readDocument(r)
+		if prob != nil {
+			return prob
+		}
+
+		err := env.Store.Create(articleID, doc)
+		switch {
+		case errors.Is(err, content.ErrInvalidKey):
 			return errors.Note{
+				Op:         op,
+				Title:      "Invalid Route",
 				StatusCode: http.StatusBadRequest,
-				Detail:     "failed to parse document",
+				Detail:     fmt.Sprintf("No article can be located at %s", articleID),
 			}.Wrap(err)
+		case errors.Is(err, content.ErrArticleExists):
+			return errors.Note{
+				Op:         op,
+				Title:      "Article Exists",
+				StatusCode: http.StatusConflict,
+				Detail:     fmt.Sprintf("An article is already located at %s", articleID),
+			}.Wrap(err)
+		case err != nil:
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusInternalServerError,
+				Detail:     "failed to create document",
+			}.Wrap(err)
+		}
+
+		env.Logger.With("articleID", articleID).Print(log.Info, "Created Document")
+
+		if text, err := content.MarshalDocument(doc); err == nil {
+			w.Header().Set("ETag", content.ETag(text))
 		}
+		w.Header().Set("Location", articleID)
+		w.WriteHeader(http.StatusCreated)
+
+		return nil
+	}
 
-		err = env.Store.Update(articleID, doc)
-		if err != nil {
-			errors.Note{
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// DeleteDocument returns an HTTP handler function to respond to HTTP requests
+// to delete an article. The handler will remove the document of the article
+// and reply with a 204 No Content, or problemjson response if the article does
+// not exist.
+func (env Env) DeleteDocument(w http.ResponseWriter, r *http.Request) {
+	const op = "Delete"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		articleID := r.Context().Value(articlesCtxKey).(string)
+		articleID = strings.TrimSuffix(articleID, ".md")
+
+		err := env.Store.Delete(articleID)
+		switch {
+		case errors.Is(err, content.ErrArticleNotFound), errors.Is(err, content.ErrInvalidKey):
+			return errors.Note{
+				Op:         op,
+				Title:      "Article Not Found",
+				StatusCode: http.StatusNotFound,
+				Detail:     fmt.Sprintf("No article located at %s", articleID),
+			}.Wrap(err)
+		case err != nil:
+			return errors.Note{
+				Op:         op,
 				StatusCode: http.StatusInternalServerError,
-				Detail:     "failed to update document",
+				Detail:     "failed to delete document",
 			}.Wrap(err)
 		}
 
-		env.Logger.With("articleID", articleID).Print(log.Info, "Updated Document")
+		env.Logger.With("articleID", articleID).Print(log.Info, "Deleted Document")
 
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(http.StatusNoContent)
 
 		return nil
 	}
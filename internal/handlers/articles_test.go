@@ -0,0 +1,309 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+// serveArticle serves a request for an article route through the
+// ArticlePath middleware.
+func serveArticle(handler http.HandlerFunc, r *http.Request, route string) *http.Response {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("*", strings.TrimPrefix(route, "/"))
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+	w := httptest.NewRecorder()
+	handlers.ArticlePath(handler).ServeHTTP(w, r)
+
+	return w.Result()
+}
+
+func TestGetArticleETag(t *testing.T) {
+	article := content.Article{Route: "/ring", Title: "The Ring", Text: []byte("title: The Ring\n=== markdown ===\nOne ring")}
+	env := handlers.Env{
+		Store:  newMockStore(article),
+		Logger: log.NewNop(),
+	}
+
+	testCases := []struct {
+		name        string
+		route       string
+		ifNoneMatch string
+
+		wantStatusCode int
+	}{
+		{
+			name:           "NoCondition",
+			route:          "/ring",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Matching",
+			route:          "/ring",
+			ifNoneMatch:    article.ETag(),
+			wantStatusCode: http.StatusNotModified,
+		},
+		{
+			name:           "MatchingWeak",
+			route:          "/ring.md",
+			ifNoneMatch:    `"other", W/` + article.ETag(),
+			wantStatusCode: http.StatusNotModified,
+		},
+		{
+			name:           "Stale",
+			route:          "/ring",
+			ifNoneMatch:    `"other"`,
+			wantStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://www.test.com"+tc.route, nil)
+			if tc.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+
+			res := serveArticle(env.GetArticle, r, tc.route)
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if got, want := res.Header.Get("ETag"), article.ETag(); got != want {
+				t.Fatalf("got ETag %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestGetArticleGroup(t *testing.T) {
+	now := time.Now()
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	article := content.Article{Route: "/ring", Title: "The Ring", Group: "fellowship", Text: []byte("title: The Ring\n=== markdown ===\nOne ring")}
+	env := handlers.Env{
+		Store:   newMockStore(article),
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+		SignKey: key,
+	}
+
+	testCases := []struct {
+		name  string
+		token string
+
+		wantStatusCode int
+	}{
+		{
+			name:           "Member",
+			token:          mustSign(t, key, auth.Claims{Username: "samwise", Roles: []string{"fellowship"}}, now, time.Minute),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Admin",
+			token:          mustSign(t, key, auth.Claims{Username: "elrond", Admin: true}, now, time.Minute),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "NotMember",
+			token:          mustSign(t, key, auth.Claims{Username: "gollum", Roles: []string{auth.RoleEditor}}, now, time.Minute),
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "NoToken",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://www.test.com/ring", nil)
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			res := serveArticle(env.GetArticle, r, "/ring")
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestUpdateDocumentIfMatch(t *testing.T) {
+	original := []byte("title: The Ring\n=== markdown ===\nOne ring")
+	update := "title: The Ring\n=== markdown ===\nOne ring to rule them all"
+
+	testCases := []struct {
+		name    string
+		ifMatch string
+
+		wantStatusCode int
+		wantText       string
+	}{
+		{
+			name:           "NoCondition",
+			wantStatusCode: http.StatusOK,
+			wantText:       update,
+		},
+		{
+			name:           "Matching",
+			ifMatch:        content.ETag(original),
+			wantStatusCode: http.StatusOK,
+			wantText:       update,
+		},
+		{
+			name:           "Any",
+			ifMatch:        "*",
+			wantStatusCode: http.StatusOK,
+			wantText:       update,
+		},
+		{
+			name:           "Modified",
+			ifMatch:        `"other"`,
+			wantStatusCode: http.StatusPreconditionFailed,
+			wantText:       string(original),
+		},
+		{
+			name:           "ModifiedList",
+			ifMatch:        `"other", "another"`,
+			wantStatusCode: http.StatusPreconditionFailed,
+			wantText:       string(original),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			store := newMockStore(content.Article{Route: "/ring", Text: original})
+			env := handlers.Env{
+				Store:  store,
+				Logger: log.NewNop(),
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "http://www.test.com/ring", strings.NewReader(update))
+			if tc.ifMatch != "" {
+				r.Header.Set("If-Match", tc.ifMatch)
+			}
+
+			res := serveArticle(env.UpdateDocument, r, "/ring")
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if res.StatusCode == http.StatusPreconditionFailed {
+				if got, want := res.Header.Get("Content-Type"), "application/problem+json"; got != want {
+					t.Errorf("got content type %q, want %q", got, want)
+				}
+			}
+
+			if got, want := string(store.articles["/ring"].Text), tc.wantText; got != want {
+				t.Fatalf("got document %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateDocument(t *testing.T) {
+	store := newMockStore(content.Article{Route: "/ring"})
+	env := handlers.Env{
+		Store:  store,
+		Logger: log.NewNop(),
+	}
+
+	testCases := []struct {
+		name  string
+		route string
+		body  string
+
+		wantStatusCode int
+	}{
+		{
+			name:           "New",
+			route:          "/shire",
+			body:           "title: The Shire\n=== markdown ===\nHobbits",
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name:           "Exists",
+			route:          "/ring",
+			body:           "title: The Ring\n=== markdown ===\nOne ring",
+			wantStatusCode: http.StatusConflict,
+		},
+		{
+			name:           "InvalidDocument",
+			route:          "/mordor",
+			body:           "Mordor",
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "http://www.test.com"+tc.route, strings.NewReader(tc.body))
+
+			res := serveArticle(env.CreateDocument, r, tc.route)
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+		})
+	}
+
+	if _, ok := store.articles["/shire"]; !ok {
+		t.Fatalf("article wasn't created")
+	}
+}
+
+func TestDeleteDocument(t *testing.T) {
+	store := newMockStore(content.Article{Route: "/ring"})
+	env := handlers.Env{
+		Store:  store,
+		Logger: log.NewNop(),
+	}
+
+	testCases := []struct {
+		name  string
+		route string
+
+		wantStatusCode int
+	}{
+		{
+			name:           "Exists",
+			route:          "/ring",
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "AlreadyDeleted",
+			route:          "/ring",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "http://www.test.com"+tc.route, nil)
+
+			res := serveArticle(env.DeleteDocument, r, tc.route)
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+		})
+	}
+}
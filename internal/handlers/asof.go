@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+const asOfKey contextKey = "asOf"
+
+// asOfParameter is the query parameter editors preview the site at another
+// time with.
+const asOfParameter = "as_of"
+
+// AsOf is a middleware that shows editors the site as it is at the time in
+// the as_of parameter of a request, such as 2006-01-02T15:04:05-07:00, so
+// that they can preview articles before they are published. Requests without
+// the parameter continue unchanged, while those with it must be authorized
+// with the editor role.
+func (env Env) AsOf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.URL.Query().Get(asOfParameter)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			prob := errors.Note{
+				StatusCode: http.StatusBadRequest,
+				Detail:     "as_of must be a date and time with a timezone, such as 2006-01-02T15:04:05-07:00",
+			}.Wrap(err)
+			handleError(w, prob, env.Logger)
+			return
+		}
+
+		claims, prob := env.verifyRequest(r)
+		if prob != nil {
+			handleError(w, prob, env.Logger)
+			return
+		}
+		if !claims.HasRole(auth.RoleEditor) {
+			prob := errors.Note{
+				StatusCode: http.StatusForbidden,
+				Detail:     "the editor role is required to preview the site",
+			}.Wrap(errors.Errorf("%q doesn't have the %s role", claims.Username, auth.RoleEditor))
+			handleError(w, prob, env.Logger)
+			return
+		}
+
+		// A preview must never be served to anybody else from a cache.
+		w.Header().Set("Cache-Control", "no-store")
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), asOfKey, t)))
+	})
+}
+
+// now returns the time a request sees the site at, which is the time being
+// previewed if there is one. The local clock is used if the environment has
+// none.
+func (env Env) now(r *http.Request) time.Time {
+	if t, ok := r.Context().Value(asOfKey).(time.Time); ok {
+		return t
+	}
+	if env.Clock == nil {
+		return time.Now()
+	}
+	return env.Clock.Now()
+}
+
+// store returns the content as a request sees it, which is as of the time
+// being previewed if there is one.
+func (env Env) store(r *http.Request) content.Store {
+	if t, ok := r.Context().Value(asOfKey).(time.Time); ok {
+		return env.Store.AsOf(t)
+	}
+	return env.Store
+}
+
+// visibleArticle returns the article with a key if it is visible to a
+// request, which it isn't before it is published or after it expires. Drafts
+// are only visible to requests that may read them.
+func (env Env) visibleArticle(r *http.Request, key string) (content.Article, error) {
+	article, err := env.Store.Get(key)
+	switch {
+	case err != nil:
+		return content.Article{}, err
+	case !article.Visible(env.now(r)):
+		return content.Article{}, errors.Errorf("%w: %s isn't published", content.ErrArticleNotFound, key)
+	case article.Draft && !env.readsDraft(r, article):
+		return content.Article{}, errors.Errorf("%w: %s is a draft", content.ErrArticleNotFound, key)
+	}
+	return article, nil
+}
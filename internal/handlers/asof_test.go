@@ -0,0 +1,136 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func TestAsOf(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	publish := now.Add(time.Hour)
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	store := newMockStore(
+		content.Article{Route: "/ring", Title: "The Ring", HTML: "<p>One ring</p>", Publish: publish},
+		content.Article{Route: "/shire", Title: "The Shire", HTML: "<p>Hobbits</p>"},
+	)
+	store.now = now
+
+	env := handlers.Env{
+		Store:   store,
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+		SignKey: key,
+	}
+
+	editor := mustSign(t, key, auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor}}, now, time.Minute)
+	reader := mustSign(t, key, auth.Claims{Username: "pippin", Roles: []string{}}, now, time.Minute)
+
+	testCases := []struct {
+		name  string
+		asOf  string
+		token string
+
+		wantStatusCode int
+	}{
+		{
+			name:           "NotPublished",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Preview",
+			asOf:           "2024-06-01T06:00:00-04:00",
+			token:          editor,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "PreviewBeforePublish",
+			asOf:           "2024-06-01T09:59:59Z",
+			token:          editor,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "PreviewWithoutCredentials",
+			asOf:           "2024-06-01T10:00:00Z",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "PreviewWithoutEditorRole",
+			asOf:           "2024-06-01T10:00:00Z",
+			token:          reader,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "InvalidTime",
+			asOf:           "tomorrow",
+			token:          editor,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			target := "http://www.test.com/ring"
+			if tc.asOf != "" {
+				target += "?as_of=" + url.QueryEscape(tc.asOf)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, target, nil)
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			res := serveArticle(env.AsOf(http.HandlerFunc(env.GetArticle)).ServeHTTP, r, "/ring")
+
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if got, want := res.Header.Get("Cache-Control"), "no-store"; tc.wantStatusCode == http.StatusOK && got != want {
+				t.Fatalf("got Cache-Control %q for a preview, want %q", got, want)
+			}
+		})
+	}
+
+	// index returns the index page as of a time, if it isn't empty.
+	index := func(asOf string) string {
+		target := "http://www.test.com/"
+		if asOf != "" {
+			target += "?as_of=" + url.QueryEscape(asOf)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Authorization", "Bearer "+editor)
+
+		env.AsOf(http.HandlerFunc(env.Index)).ServeHTTP(w, r)
+
+		body, err := io.ReadAll(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+		return string(body)
+	}
+
+	if body := index(""); strings.Contains(body, `href="/ring"`) || !strings.Contains(body, `href="/shire"`) {
+		t.Fatalf("got index listing the wrong articles before publishing:\n%s", body)
+	}
+	if body := index("2024-06-01T10:00:00Z"); !strings.Contains(body, `href="/ring"`) {
+		t.Fatalf("got index without the previewed article:\n%s", body)
+	}
+}
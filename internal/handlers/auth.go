@@ -3,8 +3,9 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/toddgaunt/bastion/internal/auth"
@@ -14,56 +15,117 @@ import (
 
 const claimsKey contextKey = "claims"
 
-var tokensMutex = sync.Mutex{}
-var tokens = make(map[auth.BearerToken]auth.Claims)
-
+// duration is the lifetime of an access token.
 var duration = time.Duration(time.Second * 5)
 
-// GetClaims retrieves the claims associated with a bearer token.
-func GetClaims(token auth.BearerToken) (auth.Claims, bool) {
-	tokensMutex.Lock()
-	claims, ok := tokens[token]
-	delete(tokens, token)
-	tokensMutex.Unlock()
-
-	return claims, ok
-}
-
-// AddClaims associates a bearer token with authentication claims.
-func AddClaims(token auth.BearerToken, claims auth.Claims) {
-	tokensMutex.Lock()
-	tokens[token] = claims
-	tokensMutex.Unlock()
-}
+// refreshDuration is the lifetime of a refresh token. Refreshing doesn't
+// extend it, so users must log in again once it has passed.
+var refreshDuration = time.Duration(time.Hour * 24)
 
 var invalidCredentials = errors.Note{
 	StatusCode: http.StatusUnauthorized,
 	Detail:     "enter a valid username and password",
 }
 
+// codeDuration is how long a user has to give their one-time code after
+// giving their password.
+var codeDuration = time.Duration(time.Minute * 5)
+
+// codeAudience is the audience of the tokens that prove a user gave their
+// password, and may give their one-time code.
+const codeAudience = "code"
+
+// accessAudience is the audience of access tokens, so that no other token
+// signed with the same keys is mistaken for one.
+const accessAudience = "access"
+
 // authResponse is the payload containing the tokens that the authentication endpoints return.
 type authResponse struct {
 	RefreshToken string `json:"refresh_token"`
 	AccessToken  string `json:"access_token"`
 }
 
-// Authorize is a middleware that verifies the authorization token provided by
-// a request, and continues to the next handler if successful.
+// codeResponse is the payload returned by Login to users that must also give
+// a one-time code.
+type codeResponse struct {
+	CodeToken string `json:"code_token"`
+}
+
+// codeRequest is the payload of a one-time code given after logging in.
+type codeRequest struct {
+	CodeToken string `json:"code_token"`
+	Code      string `json:"code"`
+}
+
+// bearerToken returns the token of the Authorization header of a request. The
+// "Bearer" scheme is optional.
+func bearerToken(r *http.Request) auth.JWT {
+	token := r.Header.Get("Authorization")
+	if scheme, credentials, ok := strings.Cut(token, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		token = credentials
+	}
+	return auth.JWT(strings.TrimSpace(token))
+}
+
+// verifyRequest returns the claims of the access token or API token of a
+// request, or of its session cookie if it has no Authorization header, if they
+// are valid.
+func (env Env) verifyRequest(r *http.Request) (auth.Claims, errors.Problem) {
+	if hasSessionCookie(r) {
+		session, prob := env.verifySession(r)
+		return session.Claims, prob
+	}
+
+	token := bearerToken(r)
+	if auth.IsAPIToken(string(token)) {
+		return env.verifyAPIToken(auth.BearerToken(token))
+	}
+
+	claims, err := env.SignKey.Verify(token)
+	if err != nil {
+		return auth.Claims{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "couldn't verify token"}.Wrap(err)
+	}
+
+	if !claims.IsValid(env.Clock.Now()) {
+		return auth.Claims{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "expired token"}.Wrap(errors.New("expired claims"))
+	}
+
+	if claims.Audience != accessAudience {
+		return auth.Claims{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "not an access token"}.Wrap(errors.Errorf("token is for %q", claims.Audience))
+	}
+
+	return claims, nil
+}
+
+// Authorize is a middleware that verifies the authorization token or session
+// cookie provided by a request, and continues to the next handler if
+// successful. Requests authenticated by a session cookie that change
+// something must also send the CSRF token of the session.
 func (env Env) Authorize(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		claims, err := env.SignKey.Verify(auth.JWT(token))
-		if err != nil {
-			prob := errors.Note{StatusCode: http.StatusUnauthorized, Detail: "couldn't verify token"}.Wrap(err)
-			handleError(w, prob, env.Logger)
-
+		if hasSessionCookie(r) {
+			session, prob := env.verifySession(r)
+			if prob == nil && !safeMethod(r.Method) && !session.CheckCSRFToken(csrfToken(r)) {
+				prob = errors.Note{
+					StatusCode: http.StatusForbidden,
+					Detail:     "missing or invalid CSRF token",
+				}.Wrap(errors.Errorf("no CSRF token for the session of %q", session.Claims.Username))
+			}
+			if prob != nil {
+				handleError(w, prob, env.Logger)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsKey, session.Claims)
+			ctx = context.WithValue(ctx, sessionKey, session)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 
-		if !claims.IsValid(env.Clock.Now()) {
-			prob := errors.Note{}.Wrap(errors.New("expired claims"))
+		claims, prob := env.verifyRequest(r)
+		if prob != nil {
 			handleError(w, prob, env.Logger)
-
 			return
 		}
 
@@ -73,7 +135,30 @@ func (env Env) Authorize(next http.Handler) http.Handler {
 	})
 }
 
+// RequireRole returns a middleware that only continues to the next handler if
+// the claims of the request grant a role. It must follow Authorize.
+func (env Env) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+			if !ok || !claims.HasRole(role) {
+				prob := errors.Note{
+					StatusCode: http.StatusForbidden,
+					Detail:     fmt.Sprintf("the %s role is required", role),
+				}.Wrap(errors.Errorf("%q doesn't have the %s role", claims.Username, role))
+				handleError(w, prob, env.Logger)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // Login authenticates a user and returns to them an access token and a refresh token.
+// Users that enrolled a second factor are instead given a token to send along
+// with their one-time code to LoginCode.
 func (env Env) Login(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
 		username, password, ok := r.BasicAuth()
@@ -82,47 +167,123 @@ func (env Env) Login(w http.ResponseWriter, r *http.Request) {
 			return invalidCredentials.Wrap(errors.New("user must enter basic auth"))
 		}
 
+		keys := env.attemptKeys(r, "", username)
+		if prob := env.allowAttempt(w, keys); prob != nil {
+			return prob
+		}
+
 		claims, err := env.Auth.Authenticate(username, password)
 		if err != nil {
+			env.failAttempt(keys)
 			w.Header().Set("Www-Authenticate", `Basic realm="restricted"`)
 			return invalidCredentials.Wrap(err)
 		}
+		env.succeedAttempt(keys)
+
+		if env.Auth.SecondFactor(username) {
+			claims.Audience = codeAudience
+			codeToken, err := env.SignKey.Sign(claims, env.Clock.Now(), codeDuration)
+			if err != nil {
+				return statusInternal.Wrap(err)
+			}
+
+			env.Logger.With("username", username).Print(log.Info, "Authenticated user, waiting for their code")
+
+			return writeJSON(w, codeResponse{CodeToken: string(codeToken)})
+		}
 
 		// Log successful authentications.
 		env.Logger.With("username", username).Print(log.Info, "Authenticated user")
 
-		refreshToken, err := auth.NewBearerToken()
-		if err != nil {
-			return statusInternal.Wrap(err)
+		return env.issueTokens(w, claims)
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// LoginCode completes logging in for a user that enrolled a second factor. It
+// accepts the token returned by Login along with a one-time code or recovery
+// code, and returns an access token and a refresh token.
+func (env Env) LoginCode(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		var req codeRequest
+
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil {
+			return errors.Note{
+				StatusCode: http.StatusBadRequest,
+				Detail:     "expected a code token and code",
+			}.Wrap(err)
 		}
 
-		AddClaims(refreshToken, claims)
+		now := env.Clock.Now()
 
-		accessToken, err := env.SignKey.Sign(claims, env.Clock.Now(), duration)
+		claims, err := env.SignKey.Verify(auth.JWT(req.CodeToken))
+		if err == nil && (!claims.IsValid(now) || claims.Audience != codeAudience) {
+			err = errors.New("not a valid code token")
+		}
 		if err != nil {
-			return statusInternal.Wrap(err)
+			return errors.Note{
+				StatusCode: http.StatusUnauthorized,
+				Detail:     "log in with your username and password again",
+			}.Wrap(err)
 		}
 
-		resp := authResponse{
-			RefreshToken: string(refreshToken),
-			AccessToken:  string(accessToken),
+		keys := env.attemptKeys(r, codeAudience, claims.Username)
+		if prob := env.allowAttempt(w, keys); prob != nil {
+			return prob
 		}
 
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		enc := json.NewEncoder(w)
-
-		if err := enc.Encode(resp); err != nil {
-			return statusInternal.Wrap(enc.Encode(resp))
+		if err := env.Auth.VerifyCode(claims.Username, strings.TrimSpace(req.Code), now); err != nil {
+			env.failAttempt(keys)
+			return errors.Note{
+				StatusCode: http.StatusUnauthorized,
+				Detail:     "enter a valid code",
+			}.Wrap(err)
 		}
+		env.succeedAttempt(keys)
 
-		return nil
+		env.Logger.With("username", claims.Username).Print(log.Info, "Authenticated user")
+
+		claims.Audience = ""
+
+		return env.issueTokens(w, claims)
 	}
 
 	err := fn(w, r)
 	handleError(w, err, env.Logger)
 }
 
+// issueTokens starts a new session for an authenticated user, and writes its
+// refresh token and first access token.
+func (env Env) issueTokens(w http.ResponseWriter, claims auth.Claims) errors.Problem {
+	refreshToken, err := env.Refresh.Issue(claims, env.Clock.Now(), refreshDuration)
+	if err != nil {
+		return statusInternal.Wrap(err)
+	}
+
+	resp, prob := env.generateTokens(refreshToken, claims)
+	if prob != nil {
+		return prob
+	}
+
+	return writeJSON(w, resp)
+}
+
+// writeJSON writes a successful response with a JSON payload.
+func writeJSON(w http.ResponseWriter, v any) errors.Problem {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(v); err != nil {
+		return statusInternal.Wrap(err)
+	}
+
+	return nil
+}
+
 // Token accepts a refresh Token from the request and sends back a new refresh and access Token.
 func (env Env) Token(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
@@ -137,32 +298,34 @@ func (env Env) Token(w http.ResponseWriter, r *http.Request) {
 			}.Wrap(err)
 		}
 
-		claims, ok := GetClaims(refreshToken)
-
-		if !ok {
+		next, claims, err := env.Refresh.Rotate(refreshToken, env.Clock.Now())
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			env.Logger.With("err", err.Error()).Print(log.Warn, "Refresh token was reused")
+		}
+		if err != nil {
 			return errors.Note{
 				Op:         "Auth",
 				StatusCode: http.StatusUnauthorized,
 				Detail:     "invalid refresh token",
-			}.Wrap(errors.Errorf("refresh token %s not found", refreshToken))
-			//w.Header().Set("WWW-Authenticate", `Basic realm="restricted"`)
-			//w.Header().Add("Location", "/auth/login")
-			//w.WriteHeader(http.StatusTemporaryRedirect)
-			//return nil
+			}.Wrap(err)
 		}
 
-		if !claims.IsValid(env.Clock.Now()) {
+		// The user may have been removed, or had their roles changed, since
+		// they logged in.
+		claims, err = env.Auth.Lookup(claims.Username)
+		if err != nil {
+			env.Refresh.Revoke(next)
 			return errors.Note{
 				Op:         "Auth",
 				StatusCode: http.StatusUnauthorized,
 				Detail:     "invalid refresh token",
-			}.Wrap(errors.New("refresh token claims are expired"))
+			}.Wrap(err)
 		}
 
 		// Log successful authentications.
-		env.Logger.With("token", refreshToken, "claims", claims).Print(log.Info, "Refreshed authentication")
+		env.Logger.With("username", claims.Username).Print(log.Info, "Refreshed authentication")
 
-		resp, prob := env.generateTokens(claims)
+		resp, prob := env.generateTokens(next, claims)
 		if prob != nil {
 			return prob
 		}
@@ -184,14 +347,10 @@ func (env Env) Token(w http.ResponseWriter, r *http.Request) {
 	handleError(w, err, env.Logger)
 }
 
-func (env Env) generateTokens(claims auth.Claims) (*authResponse, errors.Error) {
-	refreshToken, err := auth.NewBearerToken()
-	if err != nil {
-		return nil, statusInternal.Wrap(err)
-	}
-
-	AddClaims(refreshToken, claims)
-
+// generateTokens signs a new access token to respond with alongside a refresh
+// token.
+func (env Env) generateTokens(refreshToken auth.BearerToken, claims auth.Claims) (*authResponse, errors.Error) {
+	claims.Audience = accessAudience
 	accessToken, err := env.SignKey.Sign(claims, env.Clock.Now(), duration)
 	if err != nil {
 		return nil, statusInternal.Wrap(err)
@@ -204,3 +363,110 @@ func (env Env) generateTokens(claims auth.Claims) (*authResponse, errors.Error)
 
 	return &resp, nil
 }
+
+// Logout revokes the refresh token in the request, along with every other
+// refresh token issued since the user logged in.
+func (env Env) Logout(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		var refreshToken auth.BearerToken
+
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&refreshToken); err != nil {
+			return errors.Note{
+				StatusCode: http.StatusBadRequest,
+				Detail:     "failed to decode token",
+			}.Wrap(err)
+		}
+
+		err := env.Refresh.Revoke(refreshToken)
+		if err != nil && !errors.Is(err, auth.ErrRefreshTokenNotFound) {
+			return statusInternal.Wrap(err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// RevokeUser revokes every refresh token, session and API token issued to the
+// user named in the request. Only administrators may revoke the tokens of a user.
+func (env Env) RevokeUser(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+		if !ok || !claims.HasRole(auth.RoleAdmin) {
+			return errors.Note{
+				StatusCode: http.StatusForbidden,
+				Detail:     "only administrators can revoke tokens",
+			}.Wrap(errors.Errorf("%q isn't an administrator", claims.Username))
+		}
+
+		var req struct {
+			Username string `json:"username"`
+		}
+
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil || req.Username == "" {
+			return errors.Note{
+				StatusCode: http.StatusBadRequest,
+				Detail:     "expected a username",
+			}.Wrap(errors.Join(errors.New("no username"), err))
+		}
+
+		n, err := env.Refresh.RevokeUser(req.Username)
+		if err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		var sessions int
+		if env.Sessions != nil {
+			sessions, err = env.Sessions.RevokeUser(req.Username)
+			if err != nil {
+				return statusInternal.Wrap(err)
+			}
+		}
+
+		var tokens int
+		if env.Tokens != nil {
+			tokens, err = env.Tokens.RevokeUser(req.Username)
+			if err != nil {
+				return statusInternal.Wrap(err)
+			}
+		}
+
+		env.Logger.With("username", req.Username, "by", claims.Username, "refresh_tokens", n, "sessions", sessions, "api_tokens", tokens).Print(log.Info, "Revoked tokens")
+
+		return writeJSON(w, struct {
+			Revoked   int `json:"revoked"`
+			Sessions  int `json:"sessions"`
+			APITokens int `json:"api_tokens"`
+		}{n, sessions, tokens})
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// JWKS responds with the public keys that tokens issued by Login and Token can
+// be verified with, so that other services can trust them without sharing a
+// secret.
+func (env Env) JWKS(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		w.Header().Add("Content-Type", "application/jwk-set+json")
+		w.Header().Add("Cache-Control", "max-age=300")
+		w.WriteHeader(http.StatusOK)
+
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(env.SignKey.PublicKeys()); err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
@@ -1,15 +1,20 @@
 package handlers_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
 	"github.com/toddgaunt/bastion/internal/handlers"
 	"github.com/toddgaunt/bastion/internal/log"
 	"github.com/toddgaunt/bastion/internal/tests"
@@ -17,6 +22,8 @@ import (
 
 var noopHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
+// mustSign signs an access token with the claims, unless they have another
+// audience.
 func mustSign(
 	t *testing.T,
 	key auth.SymmetricKey,
@@ -24,6 +31,9 @@ func mustSign(
 	now time.Time,
 	lifetime time.Duration,
 ) string {
+	if claims.Audience == "" {
+		claims.Audience = "access"
+	}
 	jwt, err := key.Sign(claims, now, lifetime)
 	if err != nil {
 		t.Fatalf("failed to sign claims: %v", err)
@@ -45,6 +55,11 @@ func TestAuthorize(t *testing.T) {
 		Clock:   tests.MockClock(now),
 	}
 
+	noAudience, err := key.Sign(auth.Claims{}, now, time.Second)
+	if err != nil {
+		t.Fatalf("failed to sign claims: %v", err)
+	}
+
 	testCases := []struct {
 		name  string
 		token string
@@ -85,6 +100,30 @@ func TestAuthorize(t *testing.T) {
 			wantHeader:     nil,
 			wantBody:       nil,
 		},
+		{
+			name:  "BearerScheme",
+			token: "Bearer " + mustSign(t, key, auth.Claims{}, now, time.Second),
+
+			wantAuthorized: true,
+			wantHeader:     nil,
+			wantBody:       nil,
+		},
+		{
+			name:  "NoAudience",
+			token: string(noAudience),
+
+			wantAuthorized: false,
+			wantHeader:     nil,
+			wantBody:       nil,
+		},
+		{
+			name:  "ShareToken",
+			token: mustSign(t, key, auth.Claims{Audience: "share:/ring"}, now, time.Second),
+
+			wantAuthorized: false,
+			wantHeader:     nil,
+			wantBody:       nil,
+		},
 		{
 			name:  "EmptyHeader",
 			token: "",
@@ -122,6 +161,64 @@ func TestAuthorize(t *testing.T) {
 	}
 }
 
+func TestRequireRole(t *testing.T) {
+	now := time.Now()
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	env := handlers.Env{
+		SignKey: key,
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+	}
+
+	testCases := []struct {
+		name   string
+		claims auth.Claims
+
+		wantStatusCode int
+	}{
+		{
+			name:           "Editor",
+			claims:         auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor}},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Admin",
+			claims:         auth.Claims{Username: "gandalf", Roles: []string{auth.RoleAdmin}, Admin: true},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "OtherRole",
+			claims:         auth.Claims{Username: "pippin", Roles: []string{"staff"}},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "NoRoles",
+			claims:         auth.Claims{Username: "merry"},
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "http://www.test.com/ring", nil)
+			r.Header.Add("Authorization", "Bearer "+mustSign(t, key, tc.claims, now, time.Minute))
+
+			env.Authorize(env.RequireRole(auth.RoleEditor)(noopHandler)).ServeHTTP(w, r)
+
+			if got, want := w.Result().StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+		})
+	}
+}
+
 func TestLogin(t *testing.T) {
 	now := time.Now()
 	username := "test username"
@@ -140,6 +237,7 @@ func TestLogin(t *testing.T) {
 	env := handlers.Env{
 		Auth:    authenticator,
 		SignKey: key,
+		Refresh: auth.NewMemoryRefreshStore(),
 		Logger:  log.NewNop(),
 		Clock:   tests.MockClock(now),
 	}
@@ -242,8 +340,8 @@ func TestLogin(t *testing.T) {
 
 				// These numbers are just for sanity checking, they aren't
 				// important other than capturing what is currently expected.
-				base64RefreshTokenLength := 24
-				savedAccessTokenLength := 179
+				base64RefreshTokenLength := 49
+				savedAccessTokenLength := 223
 
 				if got, want := len(response.RefreshToken), base64RefreshTokenLength; got != want {
 					t.Errorf("Got refresh token of size %d, want %d", got, want)
@@ -265,19 +363,413 @@ func TestLogin(t *testing.T) {
 	}
 }
 
+func TestLoginCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	user := auth.User{Username: "samwise", Name: "Samwise Gamgee", Roles: []string{auth.RoleEditor}, TOTPSecret: secret}
+	if err := user.SetPassword("potatoes"); err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	user.RecoveryCodes = []string{auth.HashRecoveryCode("ABCD-EFGH-IJKL-MNOP")}
+
+	data, err := auth.MarshalUsers([]auth.User{user})
+	if err != nil {
+		t.Fatalf("failed to encode users: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write users: %v", err)
+	}
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	env := handlers.Env{
+		Auth:    &auth.UserFile{Path: path},
+		SignKey: key,
+		Refresh: auth.NewMemoryRefreshStore(),
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+	}
+
+	// Logging in with a password alone only returns a code token.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/login", nil)
+	r.SetBasicAuth("samwise", "potatoes")
+
+	env.Login(w, r)
+
+	res := w.Result()
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+
+	var login map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&login); err != nil {
+		t.Fatalf("couldn't unmarshal JSON response: %v", err)
+	}
+	if _, ok := login["access_token"]; ok {
+		t.Fatalf("got an access token before the code was given")
+	}
+	codeToken := login["code_token"]
+
+	// The code token isn't accepted as an access token.
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "http://www.test.com", nil)
+	r.Header.Set("Authorization", "Bearer "+codeToken)
+
+	env.Authorize(noopHandler).ServeHTTP(w, r)
+
+	if got, want := w.Result().StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d using the code token, want %d", got, want)
+	}
+
+	code, err := auth.TOTPCode(secret, now)
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	testCases := []struct {
+		name      string
+		codeToken string
+		code      string
+
+		wantStatusCode int
+	}{
+		{name: "WrongCode", codeToken: codeToken, code: "000000", wantStatusCode: http.StatusUnauthorized},
+		{name: "InvalidCodeToken", codeToken: "bananas", code: code, wantStatusCode: http.StatusUnauthorized},
+		{name: "Code", codeToken: codeToken, code: code, wantStatusCode: http.StatusOK},
+		{name: "CodeReused", codeToken: codeToken, code: code, wantStatusCode: http.StatusUnauthorized},
+		{name: "RecoveryCode", codeToken: codeToken, code: "abcd-efgh-ijkl-mnop", wantStatusCode: http.StatusOK},
+	}
+
+	// The cases run in order, since each code can only be used once.
+	for _, tc := range testCases {
+		body, err := json.Marshal(map[string]string{"code_token": tc.codeToken, "code": tc.code})
+		if err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/login/code", bytes.NewReader(body))
+
+		env.LoginCode(w, r)
+
+		res := w.Result()
+		if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+			t.Fatalf("%s: got status code %d, want %d", tc.name, got, want)
+		}
+		if res.StatusCode != http.StatusOK {
+			continue
+		}
+
+		var response struct {
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+			t.Fatalf("couldn't unmarshal JSON response: %v", err)
+		}
+
+		// The access token issued after the code is accepted is a regular one.
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "http://www.test.com", nil)
+		r.Header.Set("Authorization", "Bearer "+response.AccessToken)
+
+		env.Authorize(noopHandler).ServeHTTP(w, r)
+
+		if got, want := w.Result().StatusCode, http.StatusOK; got != want {
+			t.Fatalf("%s: got status code %d using the access token, want %d", tc.name, got, want)
+		}
+	}
+}
+
 func TestToken(t *testing.T) {
+	now := time.Now()
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	authenticator, err := auth.NewSimple("samwise", "potatoes")
+	if err != nil {
+		t.Fatalf("failed to initialize simple auth: %v", err)
+	}
+
+	refresh := auth.NewMemoryRefreshStore()
+	env := handlers.Env{
+		Auth:    authenticator,
+		SignKey: key,
+		Refresh: refresh,
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+	}
+
+	token := func(t *testing.T, refreshToken auth.BearerToken) (*http.Response, auth.BearerToken) {
+		body, err := json.Marshal(refreshToken)
+		if err != nil {
+			t.Fatalf("failed to encode token: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/token", bytes.NewReader(body))
+
+		env.Token(w, r)
+
+		res := w.Result()
+
+		var response struct {
+			RefreshToken auth.BearerToken `json:"refresh_token"`
+		}
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+				t.Fatalf("couldn't unmarshal JSON response: %v", err)
+			}
+		}
+
+		return res, response.RefreshToken
+	}
+
+	first, err := refresh.Issue(auth.Claims{Username: "samwise"}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to issue refresh token: %v", err)
+	}
+
+	res, second := token(t, first)
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+
+	// Using the first token again means it was stolen, so every token issued
+	// since is revoked.
+	res, _ = token(t, first)
+	if got, want := res.StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d for a reused token, want %d", got, want)
+	}
+
+	res, _ = token(t, second)
+	if got, want := res.StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d for a token in a revoked family, want %d", got, want)
+	}
+}
+
+func TestTokenFollowsUser(t *testing.T) {
+	now := time.Now()
+
+	// writeUsers replaces the user file.
+	path := filepath.Join(t.TempDir(), "users")
+	writeUsers := func(users ...auth.User) {
+		data, err := auth.MarshalUsers(users)
+		if err != nil {
+			t.Fatalf("failed to encode users: %v", err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("failed to write users: %v", err)
+		}
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatalf("failed to modify users: %v", err)
+		}
+	}
+
+	samwise := auth.User{Username: "samwise", Roles: []string{auth.RoleEditor}}
+	if err := samwise.SetPassword("potatoes"); err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	writeUsers(samwise)
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	refresh := auth.NewMemoryRefreshStore()
+	env := handlers.Env{
+		Auth:    &auth.UserFile{Path: path},
+		SignKey: key,
+		Refresh: refresh,
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+	}
+
+	token := func(refreshToken auth.BearerToken) (*http.Response, auth.Claims) {
+		body, err := json.Marshal(refreshToken)
+		if err != nil {
+			t.Fatalf("failed to encode token: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/token", bytes.NewReader(body))
+
+		env.Token(w, r)
+
+		res := w.Result()
+
+		var response struct {
+			AccessToken auth.JWT `json:"access_token"`
+		}
+		var claims auth.Claims
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+				t.Fatalf("couldn't unmarshal JSON response: %v", err)
+			}
+			if claims, err = key.Verify(response.AccessToken); err != nil {
+				t.Fatalf("failed to verify access token: %v", err)
+			}
+		}
+
+		return res, claims
+	}
+
+	// The roles samwise had when logging in are replaced by those he has now.
+	refreshToken, err := refresh.Issue(auth.Claims{Username: "samwise", Roles: []string{auth.RoleAdmin}, Admin: true}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to issue refresh token: %v", err)
+	}
+
+	res, claims := token(refreshToken)
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+	if claims.HasRole(auth.RoleAdmin) || !claims.HasRole(auth.RoleEditor) {
+		t.Fatalf("got roles %v, want only the roles in the user file", claims.Roles)
+	}
+
+	// Removed users can't refresh their tokens.
+	refreshToken, err = refresh.Issue(auth.Claims{Username: "samwise"}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to issue refresh token: %v", err)
+	}
+
+	writeUsers()
+
+	if res, _ := token(refreshToken); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got status code %d for a removed user, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	now := time.Now()
+	refresh := auth.NewMemoryRefreshStore()
+	env := handlers.Env{
+		Refresh: refresh,
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+	}
+
+	refreshToken, err := refresh.Issue(auth.Claims{Username: "samwise"}, now, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to issue refresh token: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/logout", strings.NewReader(`"`+string(refreshToken)+`"`))
+
+	env.Logout(w, r)
+
+	if got, want := w.Result().StatusCode, http.StatusNoContent; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+
+	if _, _, err := refresh.Rotate(refreshToken, now); !errors.Is(err, auth.ErrRefreshTokenNotFound) {
+		t.Fatalf("got error %v, want error %v", err, auth.ErrRefreshTokenNotFound)
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	now := time.Now()
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
 	testCases := []struct {
-		name string
+		name   string
+		claims auth.Claims
+
+		wantStatusCode int
+		wantRevoked    bool
 	}{
 		{
-			name: "test name",
+			name:           "Admin",
+			claims:         auth.Claims{Username: "gandalf", Admin: true},
+			wantStatusCode: http.StatusOK,
+			wantRevoked:    true,
+		},
+		{
+			name:           "NotAdmin",
+			claims:         auth.Claims{Username: "pippin"},
+			wantStatusCode: http.StatusForbidden,
 		},
 	}
 
 	for _, tc := range testCases {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
+			refresh := auth.NewMemoryRefreshStore()
+			env := handlers.Env{
+				SignKey: key,
+				Refresh: refresh,
+				Logger:  log.NewNop(),
+				Clock:   tests.MockClock(now),
+			}
+
+			refreshToken, err := refresh.Issue(auth.Claims{Username: "saruman"}, now, time.Hour)
+			if err != nil {
+				t.Fatalf("failed to issue refresh token: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/revoke", strings.NewReader(`{"username":"saruman"}`))
+			r.Header.Add("Authorization", mustSign(t, key, tc.claims, now, time.Minute))
+
+			env.Authorize(http.HandlerFunc(env.RevokeUser)).ServeHTTP(w, r)
+
+			if got, want := w.Result().StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
 
+			_, _, err = refresh.Rotate(refreshToken, now)
+			if got := errors.Is(err, auth.ErrRefreshTokenNotFound); got != tc.wantRevoked {
+				t.Fatalf("got error %v after revoking, want revoked %v", err, tc.wantRevoked)
+			}
 		})
 	}
 }
+
+func TestJWKS(t *testing.T) {
+	keyring, err := auth.NewKeyring(time.Now(), auth.ES256)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	env := handlers.Env{
+		SignKey: keyring,
+		Logger:  log.NewNop(),
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.auth/jwks.json", nil)
+
+	env.JWKS(w, r)
+
+	res := w.Result()
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("Content-Type"), "application/jwk-set+json"; got != want {
+		t.Fatalf("got content type %q, want %q", got, want)
+	}
+
+	var set auth.JWKSet
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		t.Fatalf("couldn't decode key set: %v", err)
+	}
+	if want := keyring.PublicKeys(); !reflect.DeepEqual(set, want) {
+		t.Fatalf("got key set %v, want %v", set, want)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+// Edit returns an HTTP handler function to respond to HTTP requests for the
+// editor of an article. The editor page itself contains no content. It asks
+// for credentials, and then loads the document through Source and saves it
+// through UpdateDocument, or CreateDocument if the article doesn't exist yet.
+func (env Env) Edit(w http.ResponseWriter, r *http.Request) {
+	articleID := r.Context().Value(articlesCtxKey).(string)
+	articleID = strings.TrimSuffix(articleID, ".md")
+
+	vars := templateVariables{
+		Title:       fmt.Sprintf("Editing %s", articleID),
+		Description: fmt.Sprintf("Edit the document of %s", articleID),
+		Route:       articleID,
+		content:     env.store(r),
+	}
+
+	buf := &bytes.Buffer{}
+	editTemplate.Execute(buf, vars)
+
+	w.Header().Add("Content-Type", "text/html")
+	w.Write(buf.Bytes())
+}
+
+// Source returns an HTTP handler function to respond to HTTP requests for the
+// document of an article. Unlike requesting the document through GetArticle,
+// the article's own authentication isn't required, so this must only be
+// served to authorized users.
+func (env Env) Source(w http.ResponseWriter, r *http.Request) {
+	const op = "Source"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		articleID := r.Context().Value(articlesCtxKey).(string)
+		articleID = strings.TrimSuffix(articleID, ".md")
+
+		article, err := env.Store.Get(articleID)
+		if err != nil {
+			return errors.Note{
+				Op:         op,
+				Title:      "Article Not Found",
+				StatusCode: http.StatusNotFound,
+				Detail:     fmt.Sprintf("No article located at %s", articleID),
+			}.Wrap(err)
+		}
+
+		// Documents that fail to generate are still returned, so that they
+		// can be fixed.
+		if article.Text == nil {
+			return errors.Note{
+				Op:         op,
+				Title:      "Article Generation Error",
+				StatusCode: http.StatusInternalServerError,
+			}.Wrap(article.Err)
+		}
+
+		w.Header().Set("ETag", article.ETag())
+		w.Header().Add("Content-Type", "text")
+		w.Write(article.Text)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
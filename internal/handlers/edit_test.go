@@ -0,0 +1,104 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+func TestSource(t *testing.T) {
+	authenticator, err := auth.NewSimple("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to initialize simple auth: %v", err)
+	}
+
+	text := "title: Secret\nusername: user\npassword: pass\n=== markdown ===\nSpeak friend and enter"
+	env := handlers.Env{
+		Store:  newMockStore(content.Article{Route: "/secret", Text: []byte(text), Authenticator: authenticator}),
+		Logger: log.NewNop(),
+	}
+
+	testCases := []struct {
+		name  string
+		route string
+
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "ProtectedArticle",
+			route:          "/secret",
+			wantStatusCode: http.StatusOK,
+			wantBody:       text,
+		},
+		{
+			name:           "MissingArticle",
+			route:          "/moria",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.source"+tc.route, nil)
+
+			res := serveArticle(env.Source, r, tc.route)
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if res.StatusCode != http.StatusOK {
+				return
+			}
+
+			if got, want := res.Header.Get("ETag"), content.ETag([]byte(text)); got != want {
+				t.Errorf("got ETag %s, want %s", got, want)
+			}
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("failed to read response body: %v", err)
+			}
+			if got := string(body); got != tc.wantBody {
+				t.Fatalf("got body %q, want %q", got, tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestEdit(t *testing.T) {
+	env := handlers.Env{
+		Store:  newMockStore(content.Article{Route: "/ring", Text: []byte("Secret text")}),
+		Logger: log.NewNop(),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.edit/ring", nil)
+
+	res := serveArticle(env.Edit, r, "/ring")
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	if strings.Contains(string(body), "Secret text") {
+		t.Fatalf("editor page contains the document, which requires authorization")
+	}
+	if !strings.Contains(string(body), `const route = "/ring"`) {
+		t.Fatalf("editor page doesn't refer to the article route")
+	}
+
+	// Scripts in a document must not run alongside the tokens of the editor.
+	if !strings.Contains(string(body), `<iframe id="preview" title="Preview" sandbox="">`) {
+		t.Fatalf("editor page doesn't sandbox the preview")
+	}
+}
@@ -1,21 +1,46 @@
 package handlers
 
 import (
+	"net"
+
 	"github.com/toddgaunt/bastion/internal/auth"
 	"github.com/toddgaunt/bastion/internal/clock"
 	"github.com/toddgaunt/bastion/internal/content"
 	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/search"
 )
 
 // Env stores all application state that isn't request specific. All fields
 // must be safe for concurrent use.
 type Env struct {
-	Store  content.Store
-	Logger log.Logger
-	Clock  clock.Provider
+	Store content.Store
+	// Searcher finds the articles matching a query. The site has no search
+	// if it is nil.
+	Searcher *search.Index
+	Logger   log.Logger
+	Clock    clock.Provider
+	// Redirects are the routes the Redirect middleware sends elsewhere.
+	Redirects []content.Redirect
 
 	// TODO: split these fields into a separate environment for auth-only endpoints.
 	// type AuthEnv struct
 	Auth    auth.Authenticator
-	SignKey auth.SymmetricKey
+	SignKey auth.Signer
+	Refresh auth.RefreshStore
+	// Tokens keeps the API tokens of users. API tokens aren't accepted if it
+	// is nil.
+	Tokens auth.TokenStore
+	// Sessions keeps the sessions of users logged in from a browser. Session
+	// cookies aren't accepted if it is nil.
+	Sessions auth.SessionStore
+	// OIDC logs users in with the single sign-on of an OpenID Connect
+	// provider, which starts a session. It is disabled if it is nil.
+	OIDC *auth.OIDC
+	// Limiter slows down attempts to guess passwords. Attempts aren't limited
+	// if it is nil.
+	Limiter *auth.Limiter
+	// TrustedProxies are the networks of the reverse proxies in front of the
+	// server, whose X-Forwarded-For headers are trusted to give the address
+	// of the client. The header is ignored if there are none.
+	TrustedProxies []*net.IPNet
 }
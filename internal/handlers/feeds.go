@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+// rssFeed is the root element of an RSS 2.0 document.
+type rssFeed struct {
+	XMLName    xml.Name   `xml:"rss"`
+	Version    string     `xml:"version,attr"`
+	AtomNS     string     `xml:"xmlns:atom,attr"`
+	ContentNS  string     `xml:"xmlns:content,attr"`
+	DublinCore string     `xml:"xmlns:dc,attr"`
+	Channel    rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	Description string  `xml:"description,omitempty"`
+	Creator     string  `xml:"dc:creator,omitempty"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+	Content     string  `xml:"content:encoded"`
+}
+
+// atomFeed is the root element of an Atom document, defined by IETF RFC 4287.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published,omitempty"`
+	Updated   string      `xml:"updated"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Summary   *atomText   `xml:"summary,omitempty"`
+	Content   atomText    `xml:"content"`
+}
+
+// siteURL returns the absolute URL of the site root without a trailing
+// slash. The configured URL is preferred, otherwise it is derived from the
+// request. The scheme a proxy forwarded the request from is only believed if
+// it is a trusted proxy, since any client can send the header.
+func (env Env) siteURL(r *http.Request, details content.Details) string {
+	if details.URL != "" {
+		return strings.TrimSuffix(details.URL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" && env.fromTrustedProxy(r) {
+		scheme = proto
+	}
+
+	return scheme + "://" + r.Host
+}
+
+// feedArticles returns the articles that are suitable for syndication, which
+// excludes any article that requires authentication or failed to generate.
+func feedArticles(store content.Store) []content.Article {
+	var list []content.Article
+	for _, article := range store.GetAll(false) {
+		if article.Err != nil || article.Protected() {
+			continue
+		}
+		list = append(list, article)
+	}
+
+	return list
+}
+
+// lastUpdated returns when an article was last modified, falling back to its
+// creation date if it was never updated.
+func lastUpdated(article content.Article) time.Time {
+	if article.Updated.After(article.Created) {
+		return article.Updated
+	}
+	return article.Created
+}
+
+func writeXML(w http.ResponseWriter, contentType string, v any) errors.Problem {
+	body, err := xml.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return statusInternal.Wrap(err)
+	}
+
+	w.Header().Add("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+
+	return nil
+}
+
+// RSS responds with an RSS 2.0 feed of the listed articles.
+func (env Env) RSS(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		details := env.Store.GetDetails()
+		base := env.siteURL(r, details)
+
+		feed := rssFeed{
+			Version:    "2.0",
+			AtomNS:     "http://www.w3.org/2005/Atom",
+			ContentNS:  "http://purl.org/rss/1.0/modules/content/",
+			DublinCore: "http://purl.org/dc/elements/1.1/",
+			Channel: rssChannel{
+				Title:       details.Name,
+				Link:        base + "/",
+				Description: details.Description,
+				Self: atomLink{
+					Href: base + "/.feeds/rss.xml",
+					Rel:  "self",
+					Type: "application/rss+xml",
+				},
+			},
+		}
+
+		var latest time.Time
+		for _, article := range feedArticles(env.store(r)) {
+			link := base + article.Route
+			item := rssItem{
+				Title:       article.Title,
+				Link:        link,
+				GUID:        rssGUID{IsPermaLink: true, Value: link},
+				Description: article.Description,
+				Creator:     article.Author,
+				Content:     string(article.HTML),
+			}
+			if !article.Created.IsZero() {
+				item.PubDate = article.Created.Format(time.RFC1123Z)
+			}
+			if updated := lastUpdated(article); updated.After(latest) {
+				latest = updated
+			}
+			feed.Channel.Items = append(feed.Channel.Items, item)
+		}
+
+		if !latest.IsZero() {
+			feed.Channel.LastBuildDate = latest.Format(time.RFC1123Z)
+		}
+
+		return writeXML(w, "application/rss+xml", feed)
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// Atom responds with an Atom feed of the listed articles.
+func (env Env) Atom(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		details := env.Store.GetDetails()
+		base := env.siteURL(r, details)
+
+		// Every entry must have an author, so the site is the author of the
+		// feed for entries that don't name one.
+		author := details.Name
+		if author == "" {
+			author = base
+		}
+
+		feed := atomFeed{
+			ID:     base + "/",
+			Title:  details.Name,
+			Author: atomPerson{Name: author},
+			Links: []atomLink{
+				{Href: base + "/.feeds/atom.xml", Rel: "self", Type: "application/atom+xml"},
+				{Href: base + "/", Rel: "alternate", Type: "text/html"},
+			},
+		}
+
+		var latest time.Time
+		for _, article := range feedArticles(env.store(r)) {
+			link := base + article.Route
+			updated := lastUpdated(article)
+			entry := atomEntry{
+				ID:      link,
+				Title:   article.Title,
+				Link:    atomLink{Href: link, Rel: "alternate", Type: "text/html"},
+				Updated: updated.Format(time.RFC3339),
+				Content: atomText{Type: "html", Value: string(article.HTML)},
+			}
+			if !article.Created.IsZero() {
+				entry.Published = article.Created.Format(time.RFC3339)
+			}
+			if article.Author != "" {
+				entry.Author = &atomPerson{Name: article.Author}
+			}
+			if article.Description != "" {
+				entry.Summary = &atomText{Type: "text", Value: article.Description}
+			}
+			if updated.After(latest) {
+				latest = updated
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+
+		// An empty feed has still been updated, even if nothing says when.
+		if latest.IsZero() {
+			latest = env.now(r)
+		}
+		feed.Updated = latest.Format(time.RFC3339)
+
+		return writeXML(w, "application/atom+xml", feed)
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
@@ -0,0 +1,230 @@
+package handlers_test
+
+import (
+	"encoding/xml"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func feedStore(t *testing.T) *mockStore {
+	authenticator, err := auth.NewSimple("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to initialize simple auth: %v", err)
+	}
+
+	store := newMockStore(
+		content.Article{
+			Route:       "/first",
+			Title:       "First",
+			Description: "The first article",
+			Author:      "Samwise",
+			Created:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			HTML:        "<p>first</p>",
+		},
+		content.Article{
+			Route:   "/second",
+			Title:   "Second",
+			Created: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC),
+			Updated: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+			HTML:    "<p>second</p>",
+		},
+		content.Article{Route: "/unlisted", Title: "Unlisted", Unlisted: true},
+		content.Article{Route: "/protected", Title: "Protected", Authenticator: authenticator},
+		content.Article{Route: "/about", Title: "About", Pinned: true},
+	)
+	store.details.URL = "https://www.example.com/"
+
+	return store
+}
+
+func TestRSS(t *testing.T) {
+	env := handlers.Env{
+		Store:  feedStore(t),
+		Logger: log.NewNop(),
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.feeds/rss.xml", nil)
+
+	env.RSS(w, r)
+
+	res := w.Result()
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("Content-Type"), "application/rss+xml"; got != want {
+		t.Fatalf("got content type %q, want %q", got, want)
+	}
+
+	var feed struct {
+		Channel struct {
+			// The atom:link element shares the local name "link".
+			Links         []string `xml:"link"`
+			LastBuildDate string   `xml:"lastBuildDate"`
+			Items         []struct {
+				Title   string `xml:"title"`
+				Link    string `xml:"link"`
+				Creator string `xml:"creator"`
+				PubDate string `xml:"pubDate"`
+				Content string `xml:"encoded"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&feed); err != nil {
+		t.Fatalf("couldn't decode feed: %v", err)
+	}
+
+	if got, want := feed.Channel.Links[0], "https://www.example.com/"; got != want {
+		t.Errorf("got channel link %q, want %q", got, want)
+	}
+	if got, want := feed.Channel.LastBuildDate, "Sun, 01 Mar 2020 00:00:00 +0000"; got != want {
+		t.Errorf("got last build date %q, want %q", got, want)
+	}
+
+	var links []string
+	for _, item := range feed.Channel.Items {
+		links = append(links, item.Link)
+	}
+	if want := []string{"https://www.example.com/first", "https://www.example.com/second"}; !reflect.DeepEqual(links, want) {
+		t.Fatalf("got items %v, want %v", links, want)
+	}
+
+	first := feed.Channel.Items[0]
+	if got, want := first.Creator, "Samwise"; got != want {
+		t.Errorf("got creator %q, want %q", got, want)
+	}
+	if got, want := first.Content, "<p>first</p>"; got != want {
+		t.Errorf("got content %q, want %q", got, want)
+	}
+}
+
+func TestAtomEmpty(t *testing.T) {
+	now := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	env := handlers.Env{
+		Store:  newMockStore(),
+		Logger: log.NewNop(),
+		Clock:  tests.MockClock(now),
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.feeds/atom.xml", nil)
+
+	env.Atom(w, r)
+
+	var feed struct {
+		Updated string `xml:"updated"`
+	}
+	if err := xml.NewDecoder(w.Result().Body).Decode(&feed); err != nil {
+		t.Fatalf("couldn't decode feed: %v", err)
+	}
+
+	if got, want := feed.Updated, now.Format(time.RFC3339); got != want {
+		t.Errorf("got feed updated %q, want %q", got, want)
+	}
+}
+
+func TestAtom(t *testing.T) {
+	env := handlers.Env{
+		Store:  feedStore(t),
+		Logger: log.NewNop(),
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.feeds/atom.xml", nil)
+
+	env.Atom(w, r)
+
+	res := w.Result()
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+
+	var feed struct {
+		Updated string `xml:"updated"`
+		Author  string `xml:"author>name"`
+		Entries []struct {
+			ID        string `xml:"id"`
+			Published string `xml:"published"`
+			Updated   string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&feed); err != nil {
+		t.Fatalf("couldn't decode feed: %v", err)
+	}
+
+	if got, want := feed.Updated, "2020-03-01T00:00:00Z"; got != want {
+		t.Errorf("got feed updated %q, want %q", got, want)
+	}
+	if got, want := feed.Author, "Test"; got != want {
+		t.Errorf("got feed author %q, want %q", got, want)
+	}
+	if got, want := len(feed.Entries), 2; got != want {
+		t.Fatalf("got %d entries, want %d", got, want)
+	}
+
+	second := feed.Entries[1]
+	if got, want := second.ID, "https://www.example.com/second"; got != want {
+		t.Errorf("got entry id %q, want %q", got, want)
+	}
+	if got, want := second.Published, "2020-02-01T00:00:00Z"; got != want {
+		t.Errorf("got entry published %q, want %q", got, want)
+	}
+	if got, want := second.Updated, "2020-03-01T00:00:00Z"; got != want {
+		t.Errorf("got entry updated %q, want %q", got, want)
+	}
+}
+
+func TestFeedForwardedProto(t *testing.T) {
+	_, proxy, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("failed to parse network: %v", err)
+	}
+
+	env := handlers.Env{
+		Store:          newMockStore(content.Article{Route: "/shire", Title: "Shire"}),
+		Logger:         log.NewNop(),
+		TrustedProxies: []*net.IPNet{proxy},
+	}
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+
+		wantID string
+	}{
+		{name: "Client", remoteAddr: "192.0.2.1:1234", wantID: "http://www.test.com/"},
+		{name: "TrustedProxy", remoteAddr: "10.0.0.1:1234", wantID: "https://www.test.com/"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.feeds/atom.xml", nil)
+			r.RemoteAddr = tc.remoteAddr
+			r.Header.Set("X-Forwarded-Proto", "https")
+
+			env.Atom(w, r)
+
+			var feed struct {
+				ID string `xml:"id"`
+			}
+			if err := xml.NewDecoder(w.Result().Body).Decode(&feed); err != nil {
+				t.Fatalf("couldn't decode feed: %v", err)
+			}
+			if got, want := feed.ID, tc.wantID; got != want {
+				t.Fatalf("got feed id %q, want %q", got, want)
+			}
+		})
+	}
+}
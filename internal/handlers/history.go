@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+// currentRevision refers to the current source of a document wherever a
+// revision ID is expected.
+const currentRevision = "current"
+
+// diffLines returns an HTML line by line diff between two texts.
+func diffLines(a, b string) template.HTML {
+	dmp := diffmatchpatch.New()
+	charsA, charsB, lines := dmp.DiffLinesToChars(a, b)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(charsA, charsB, false), lines)
+
+	buf := &strings.Builder{}
+	for _, diff := range diffs {
+		class, prefix := "diff-equal", "  "
+		switch diff.Type {
+		case diffmatchpatch.DiffInsert:
+			class, prefix = "diff-insert", "+ "
+		case diffmatchpatch.DiffDelete:
+			class, prefix = "diff-delete", "- "
+		}
+
+		for _, line := range strings.SplitAfter(diff.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			fmt.Fprintf(buf, `<span class="%s">%s%s</span>`, class, prefix, html.EscapeString(line))
+		}
+	}
+
+	return template.HTML(buf.String())
+}
+
+// revisionProblem converts an error from retrieving a revision to a problem.
+func revisionProblem(op errors.Op, err error) errors.Problem {
+	switch {
+	case errors.Is(err, content.ErrArticleNotFound):
+		return errors.Note{
+			Op:         op,
+			Title:      "Article Not Found",
+			StatusCode: http.StatusNotFound,
+		}.Wrap(err)
+	case errors.Is(err, content.ErrRevisionNotFound):
+		return errors.Note{
+			Op:         op,
+			Title:      "Revision Not Found",
+			StatusCode: http.StatusNotFound,
+		}.Wrap(err)
+	}
+
+	return statusInternal.Wrap(err)
+}
+
+// History returns an HTTP handler that responds with the revisions kept of an
+// article. If the rev parameter is given, the source of the document at that
+// revision is returned instead. If the from parameter is given, a diff
+// between the from and to revisions is returned, where the revision
+// "current" refers to the document as it is now, and is the default for to.
+// Revisions may hold credentials the article no longer has, so like Source,
+// this must only be served to authorized users.
+func (env Env) History(w http.ResponseWriter, r *http.Request) {
+	const op = "History"
+
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		articleID := r.Context().Value(articlesCtxKey).(string)
+
+		// The revisions of a deleted document are still kept, so that it
+		// can be restored. Its current source is then empty.
+		article, err := env.Store.Get(articleID)
+		if err != nil && !errors.Is(err, content.ErrArticleNotFound) {
+			return revisionProblem(op, err)
+		}
+
+		getRevision := func(id string) ([]byte, error) {
+			if id == currentRevision {
+				return article.Text, nil
+			}
+			return env.Store.GetRevision(articleID, id)
+		}
+
+		query := r.URL.Query()
+
+		vars := templateVariables{
+			Title:       fmt.Sprintf("History of %s", articleID),
+			Description: article.Title,
+			Route:       articleID,
+			content:     env.store(r),
+		}
+
+		switch {
+		case query.Has("rev"):
+			data, err := getRevision(query.Get("rev"))
+			if err != nil {
+				return revisionProblem(op, err)
+			}
+
+			w.Header().Add("Content-Type", "text")
+			w.Write(data)
+
+			return nil
+		case query.Has("from"):
+			to := query.Get("to")
+			if to == "" {
+				to = currentRevision
+			}
+
+			from, err := getRevision(query.Get("from"))
+			if err != nil {
+				return revisionProblem(op, err)
+			}
+
+			dest, err := getRevision(to)
+			if err != nil {
+				return revisionProblem(op, err)
+			}
+
+			vars.Title = fmt.Sprintf("Changes to %s from %s to %s", articleID, query.Get("from"), to)
+			vars.Diff = diffLines(string(from), string(dest))
+		default:
+			vars.Revisions, err = env.Store.History(articleID)
+			if err != nil {
+				return revisionProblem(op, err)
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		historyTemplate.Execute(buf, vars)
+
+		w.Header().Add("Content-Type", "text/html")
+		w.Write(buf.Bytes())
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// RestoreRevision returns an HTTP handler that replaces the document of an
+// article with its source at the revision given by the rev parameter.
+func (env Env) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	const op = "RestoreRevision"
+
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		articleID := r.Context().Value(articlesCtxKey).(string)
+		id := r.URL.Query().Get("rev")
+
+		if err := env.Store.Restore(articleID, id); err != nil {
+			return revisionProblem(op, err)
+		}
+
+		env.Logger.With("articleID", articleID, "revision", id).Print(log.Info, "Restored Document")
+
+		w.WriteHeader(http.StatusOK)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
@@ -0,0 +1,80 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/content/watcher"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func TestHistoryDeleted(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := t.TempDir()
+	original := "Title: Ring\n=== markdown ===\nOne ring to rule them all"
+	if err := os.WriteFile(filepath.Join(root, "ring.md"), []byte(original), 0644); err != nil {
+		t.Fatalf("failed to write document: %v", err)
+	}
+
+	store := &watcher.Watcher{Path: root, Logger: log.NewNop(), Clock: tests.MockClock(now)}
+	if err := store.Load(); err != nil {
+		t.Fatalf("failed to load documents: %v", err)
+	}
+	if err := store.Delete("/ring"); err != nil {
+		t.Fatalf("failed to delete document: %v", err)
+	}
+
+	env := handlers.Env{
+		Store:  store,
+		Logger: log.NewNop(),
+		Clock:  tests.MockClock(now),
+	}
+
+	// The revision kept when the document was deleted is still listed.
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.history/ring", nil)
+	res := serveArticle(env.History, r, "/ring")
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d listing revisions, want %d", got, want)
+	}
+
+	id := now.Format("20060102T150405.000000000Z")
+	body, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(body), id) {
+		t.Fatalf("revision %s isn't listed in:\n%s", id, body)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://www.test.com/.history/ring?rev="+id, nil)
+	res = serveArticle(env.History, r, "/ring")
+	body, _ = io.ReadAll(res.Body)
+	if got, want := string(body), original; got != want {
+		t.Fatalf("got revision %q, want %q", got, want)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "http://www.test.com/.history/ring?rev="+id, nil)
+	res = serveArticle(env.RestoreRevision, r, "/ring")
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d restoring the revision, want %d", got, want)
+	}
+
+	article, err := store.Get("/ring")
+	if err != nil {
+		t.Fatalf("failed to get restored article: %v", err)
+	}
+	if got, want := article.Title, "Ring"; got != want {
+		t.Fatalf("got restored article %q, want %q", got, want)
+	}
+
+	// A route that never had a document has no history.
+	r = httptest.NewRequest(http.MethodGet, "http://www.test.com/.history/mordor", nil)
+	if got, want := serveArticle(env.History, r, "/mordor").StatusCode, http.StatusNotFound; got != want {
+		t.Fatalf("got status code %d for the history of a missing article, want %d", got, want)
+	}
+}
@@ -18,7 +18,8 @@ func (env Env) Index(w http.ResponseWriter, r *http.Request) {
 		vars := templateVariables{
 			Title:       details.Name,
 			Description: details.Description,
-			content:     env.Store,
+			Searchable:  env.Searcher != nil,
+			content:     env.store(r),
 		}
 
 		buf := &bytes.Buffer{}
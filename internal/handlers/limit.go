@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+// ErrTooManyAttempts is the type of problem returned when a client is locked
+// out after failing to log in too many times.
+const ErrTooManyAttempts = errors.Type("too-many-attempts")
+
+// trustedProxy returns true if an address is one of the trusted proxies.
+func (env Env) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range env.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromTrustedProxy returns true if a request was made by a trusted proxy.
+func (env Env) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return env.trustedProxy(host)
+}
+
+// clientIP returns the address of the client that made a request. Requests
+// from a trusted proxy are made on behalf of the last address in their
+// X-Forwarded-For header that isn't another trusted proxy, since the
+// addresses before it could have been sent by the client itself.
+func (env Env) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !env.trustedProxy(host) {
+		return host
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(addr))
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if net.ParseIP(forwarded[i]) == nil {
+			break
+		}
+		host = forwarded[i]
+		if !env.trustedProxy(host) {
+			break
+		}
+	}
+
+	return host
+}
+
+// attemptKeys returns the keys the limiter counts failed logins against, which
+// are the address of the client and the username within a realm, such as the
+// site or the route of an article.
+func (env Env) attemptKeys(r *http.Request, realm, username string) []string {
+	return []string{"ip:" + env.clientIP(r), "user:" + realm + ":" + username}
+}
+
+// tooManyAttempts returns the problem for a client that has to wait before
+// trying to log in again, or nil if it doesn't.
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) errors.Problem {
+	if wait <= 0 {
+		return nil
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	return errors.Note{
+		Type:       ErrTooManyAttempts,
+		Title:      "Too Many Requests",
+		StatusCode: http.StatusTooManyRequests,
+		Detail:     fmt.Sprintf("too many failed attempts to log in, try again in %d seconds", seconds),
+	}.Wrap(errors.Errorf("locked out for %v", wait))
+}
+
+// allowAttempt returns a problem if the keys are locked out. Attempts are
+// always allowed if there is no limiter.
+func (env Env) allowAttempt(w http.ResponseWriter, keys []string) errors.Problem {
+	if env.Limiter == nil {
+		return nil
+	}
+	return tooManyAttempts(w, env.Limiter.Allow(keys...))
+}
+
+// failAttempt counts a failed login against the keys, and logs when they are
+// locked out.
+func (env Env) failAttempt(keys []string) {
+	if env.Limiter == nil {
+		return
+	}
+
+	if wait := env.Limiter.Fail(keys...); wait > 0 {
+		env.Logger.With("keys", keys, "duration", wait.String()).Print(log.Warn, "Locked out after failed logins")
+	}
+}
+
+// succeedAttempt forgets the failed logins of the username of the keys. The
+// failures of the client's address are kept, so that logging in to one
+// account can't be used to keep guessing the passwords of others.
+func (env Env) succeedAttempt(keys []string) {
+	if env.Limiter == nil {
+		return
+	}
+	env.Limiter.Succeed(keys[1:]...)
+}
@@ -0,0 +1,130 @@
+package handlers_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func TestLoginLimit(t *testing.T) {
+	now := time.Now()
+
+	authenticator, err := auth.NewSimple("samwise", "potatoes")
+	if err != nil {
+		t.Fatalf("failed to initialize simple auth: %v", err)
+	}
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	limiter := auth.NewLimiter(tests.MockClock(now))
+	limiter.Free = 1
+
+	env := handlers.Env{
+		Auth:    authenticator,
+		SignKey: key,
+		Refresh: auth.NewMemoryRefreshStore(),
+		Limiter: limiter,
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+	}
+
+	login := func(password string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.auth/login", nil)
+		r.SetBasicAuth("samwise", password)
+		env.Login(w, r)
+		return w.Result()
+	}
+
+	if got, want := login("fireworks").StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d for the first failure, want %d", got, want)
+	}
+	if got, want := login("fireworks").StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d for the second failure, want %d", got, want)
+	}
+
+	// Even the right password is refused while locked out.
+	res := login("potatoes")
+	if got, want := res.StatusCode, http.StatusTooManyRequests; got != want {
+		t.Fatalf("got status code %d while locked out, want %d", got, want)
+	}
+	if got, want := res.Header.Get("Retry-After"), "1"; got != want {
+		t.Fatalf("got Retry-After %q, want %q", got, want)
+	}
+
+	limiter.Clock = tests.MockClock(now.Add(time.Second))
+	if got, want := login("potatoes").StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d after the lockout, want %d", got, want)
+	}
+}
+
+func TestArticleLimit(t *testing.T) {
+	now := time.Now()
+	env := newProtectedEnv(t, now)
+	env.Limiter = auth.NewLimiter(tests.MockClock(now))
+	env.Limiter.Free = 0
+
+	get := func(password string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "http://www.test.com/ring", nil)
+		r.SetBasicAuth("samwise", password)
+		return serveArticle(env.GetArticle, r, "/ring")
+	}
+
+	if got, want := get("fireworks").StatusCode, http.StatusForbidden; got != want {
+		t.Fatalf("got status code %d for a failure, want %d", got, want)
+	}
+	if got, want := get("potatoes").StatusCode, http.StatusTooManyRequests; got != want {
+		t.Fatalf("got status code %d while locked out, want %d", got, want)
+	}
+}
+
+func TestProxyLimit(t *testing.T) {
+	now := time.Now()
+	env := newProtectedEnv(t, now)
+	env.Limiter = auth.NewLimiter(tests.MockClock(now))
+	env.Limiter.Free = 0
+
+	_, proxy, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("failed to parse network: %v", err)
+	}
+	env.TrustedProxies = []*net.IPNet{proxy}
+
+	get := func(remote, forwarded, username, password string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "http://www.test.com/ring", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", forwarded)
+		r.SetBasicAuth(username, password)
+		return serveArticle(env.GetArticle, r, "/ring")
+	}
+
+	// Clients behind a trusted proxy are told apart by the address it
+	// forwards.
+	if got, want := get("10.0.0.1:1234", "203.0.113.1", "pippin", "fireworks").StatusCode, http.StatusForbidden; got != want {
+		t.Fatalf("got status code %d for a failure, want %d", got, want)
+	}
+	if got, want := get("10.0.0.1:1234", "203.0.113.2", "samwise", "potatoes").StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d for another client of the proxy, want %d", got, want)
+	}
+	if got, want := get("10.0.0.1:1234", "203.0.113.2, 203.0.113.1", "merry", "potatoes").StatusCode, http.StatusTooManyRequests; got != want {
+		t.Fatalf("got status code %d for a client claiming another address, want %d", got, want)
+	}
+
+	// Anybody else can't choose their address.
+	if got, want := get("192.0.2.1:1234", "203.0.113.3", "frodo", "fireworks").StatusCode, http.StatusForbidden; got != want {
+		t.Fatalf("got status code %d for a failure, want %d", got, want)
+	}
+	if got, want := get("192.0.2.1:1234", "203.0.113.4", "samwise", "potatoes").StatusCode, http.StatusTooManyRequests; got != want {
+		t.Fatalf("got status code %d for a client forging its address, want %d", got, want)
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+// ErrArticleLogin is the type of problem returned when a request for an
+// article doesn't carry the article's credentials.
+const ErrArticleLogin = errors.Type("article-login-required")
+
+// articleSessionDuration is how long the cookie issued after logging in to an
+// article is valid.
+var articleSessionDuration = time.Duration(time.Hour * 24)
+
+// articleAudience returns the audience of the tokens that grant access to an
+// article, so that they can't be used for anything else.
+func articleAudience(route string) string {
+	return "article:" + route
+}
+
+// articleCookieName returns the name of the cookie that grants access to an
+// article. Every article has its own cookie, so that logging in to one
+// article doesn't replace the cookies of others.
+func articleCookieName(route string) string {
+	sum := sha256.Sum256([]byte(route))
+	return "bastion-article-" + hex.EncodeToString(sum[:8])
+}
+
+// secureRequest returns true if a request was made over HTTPS, either to
+// Bastion or to a proxy in front of it.
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// acceptsHTML returns true if a request was made by a browser, which is shown
+// a login form rather than asked for HTTP basic auth.
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// verifyArticleCookie returns true if a request carries a valid cookie for
+// an article, which was issued for the credentials the article has now.
+func (env Env) verifyArticleCookie(r *http.Request, article content.Article) bool {
+	cookie, err := r.Cookie(articleCookieName(article.Route))
+	if err != nil {
+		return false
+	}
+
+	claims, err := env.SignKey.Verify(auth.JWT(cookie.Value))
+	if err != nil {
+		return false
+	}
+
+	return claims.IsValid(env.Clock.Now()) &&
+		claims.Audience == articleAudience(article.Route) &&
+		claims.Credential == article.Credential
+}
+
+// setArticleCookie issues a cookie that grants access to an article without
+// its credentials until it expires, or until the credentials change. The
+// cookie is only sent with requests for the article.
+func (env Env) setArticleCookie(w http.ResponseWriter, r *http.Request, article content.Article, username string) error {
+	claims := auth.Claims{
+		Username:   username,
+		Audience:   articleAudience(article.Route),
+		Credential: article.Credential,
+	}
+
+	token, err := env.SignKey.Sign(claims, env.Clock.Now(), articleSessionDuration)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     articleCookieName(article.Route),
+		Value:    string(token),
+		Path:     article.Route,
+		MaxAge:   int(articleSessionDuration.Seconds()),
+		Secure:   secureRequest(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// localPath returns next if it is a path on this site, or fallback otherwise,
+// so that logging in can't redirect to another site.
+func localPath(next, fallback string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+	return next
+}
+
+// renderArticleLogin writes the login form of an article.
+func (env Env) renderArticleLogin(w http.ResponseWriter, article content.Article, next, message string, status int) {
+	vars := templateVariables{
+		Title:       fmt.Sprintf("Log in to %s", article.Title),
+		Description: article.Description,
+		Route:       article.Route,
+		Next:        next,
+		Message:     message,
+		content:     env.Store,
+	}
+
+	buf := &bytes.Buffer{}
+	loginTemplate.Execute(buf, vars)
+
+	w.Header().Add("Content-Type", "text/html")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// articleLogin shows browsers the login form of an article in place of a
+// problem asking for the article's credentials. Any other problem is
+// returned unchanged.
+func (env Env) articleLogin(w http.ResponseWriter, r *http.Request, article content.Article, prob errors.Problem) errors.Problem {
+	if !errors.Is(prob, ErrArticleLogin) || !acceptsHTML(r) {
+		return prob
+	}
+
+	env.renderArticleLogin(w, article, r.URL.RequestURI(), "", http.StatusUnauthorized)
+
+	return nil
+}
+
+// ArticleLogin returns an HTTP handler that logs in to an article from a
+// form. A GET of the route given by the route parameter shows the form, while
+// a POST of the form checks the article's credentials. If they are correct, a
+// cookie granting access to the article is issued and the user is redirected
+// to the path in the next field.
+func (env Env) ArticleLogin(w http.ResponseWriter, r *http.Request) {
+	const op = "ArticleLogin"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if err := r.ParseForm(); err != nil {
+			return statusBadRequest.Wrap(err)
+		}
+
+		route := r.Form.Get("route")
+		next := localPath(r.Form.Get("next"), route)
+
+		article, err := env.visibleArticle(r, route)
+		if err != nil || article.Authenticator == nil {
+			return errors.Note{
+				Op:         op,
+				Title:      "Article Not Found",
+				StatusCode: http.StatusNotFound,
+				Detail:     fmt.Sprintf("No article that requires a login located at %s", route),
+			}.Wrap(errors.Join(errors.New("no protected article"), err))
+		}
+
+		if r.Method == http.MethodGet {
+			env.renderArticleLogin(w, article, next, "", http.StatusOK)
+			return nil
+		}
+
+		username := r.PostForm.Get("username")
+
+		keys := env.attemptKeys(r, article.Route, username)
+		if prob := env.allowAttempt(w, keys); prob != nil {
+			env.renderArticleLogin(w, article, next, "Too many failed attempts to log in, try again later.", http.StatusTooManyRequests)
+			return nil
+		}
+
+		if _, err := article.Authenticator.Authenticate(username, r.PostForm.Get("password")); err != nil {
+			env.failAttempt(keys)
+			env.Logger.With("op", op, "route", route, "err", err.Error()).Print(log.Info, "article login failed")
+			env.renderArticleLogin(w, article, next, "Invalid username or password.", http.StatusUnauthorized)
+			return nil
+		}
+		env.succeedAttempt(keys)
+
+		if err := env.setArticleCookie(w, r, article, username); err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		http.Redirect(w, r, next, http.StatusSeeOther)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// ArticleLogout returns an HTTP handler that removes the cookie granting
+// access to the article given by the route field of a form.
+func (env Env) ArticleLogout(w http.ResponseWriter, r *http.Request) {
+	route := r.PostFormValue("route")
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     articleCookieName(route),
+		Value:    "",
+		Path:     route,
+		MaxAge:   -1,
+		Secure:   secureRequest(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, localPath(route, "/"), http.StatusSeeOther)
+}
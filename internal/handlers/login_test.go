@@ -0,0 +1,231 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+// newProtectedEnv returns an environment serving a single article that
+// requires the username samwise and password potatoes.
+func newProtectedEnv(t *testing.T, now time.Time) handlers.Env {
+	t.Helper()
+
+	authenticator, err := auth.NewSimple("samwise", "potatoes")
+	if err != nil {
+		t.Fatalf("failed to initialize simple auth: %v", err)
+	}
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	article := content.Article{
+		Route:         "/ring",
+		Title:         "The Ring",
+		Authenticator: authenticator,
+		Credential:    "potatoes",
+		Text:          []byte("title: The Ring\n=== markdown ===\nOne ring"),
+	}
+
+	return handlers.Env{
+		Store:   newMockStore(article),
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+		SignKey: key,
+	}
+}
+
+func TestGetArticleCookie(t *testing.T) {
+	now := time.Now()
+	env := newProtectedEnv(t, now)
+
+	// Basic auth is answered with a cookie that replaces it.
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/ring", nil)
+	r.SetBasicAuth("samwise", "potatoes")
+
+	res := serveArticle(env.GetArticle, r, "/ring")
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+
+	cookies := res.Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("got cookies %v, want a single HttpOnly SameSite cookie", cookies)
+	}
+	if got, want := cookies[0].Path, "/ring"; got != want {
+		t.Fatalf("got cookie path %q, want %q", got, want)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://www.test.com/ring", nil)
+	r.AddCookie(cookies[0])
+
+	res = serveArticle(env.GetArticle, r, "/ring")
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d with the cookie, want %d", got, want)
+	}
+
+	// The cookie of an article can't be used as an access token.
+	r = httptest.NewRequest(http.MethodPost, "http://www.test.com/ring", nil)
+	r.Header.Set("Authorization", "Bearer "+cookies[0].Value)
+	w := httptest.NewRecorder()
+	env.Authorize(noopHandler).ServeHTTP(w, r)
+	if got, want := w.Result().StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d using the cookie as an access token, want %d", got, want)
+	}
+
+	// The cookie expires.
+	later := env
+	later.Clock = tests.MockClock(now.Add(48 * time.Hour))
+
+	r = httptest.NewRequest(http.MethodGet, "http://www.test.com/ring", nil)
+	r.AddCookie(cookies[0])
+
+	res = serveArticle(later.GetArticle, r, "/ring")
+	if got, want := res.StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d with an expired cookie, want %d", got, want)
+	}
+
+	// Changing the credentials of the article revokes the cookie.
+	store := env.Store.(*mockStore)
+	article := store.articles["/ring"]
+	article.Credential = "lembas"
+	store.articles["/ring"] = article
+
+	r = httptest.NewRequest(http.MethodGet, "http://www.test.com/ring", nil)
+	r.AddCookie(cookies[0])
+
+	res = serveArticle(env.GetArticle, r, "/ring")
+	if got, want := res.StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d with the cookie of old credentials, want %d", got, want)
+	}
+}
+
+func TestGetArticleLoginForm(t *testing.T) {
+	env := newProtectedEnv(t, time.Now())
+
+	testCases := []struct {
+		name   string
+		accept string
+
+		wantContentType  string
+		wantAuthenticate bool
+		wantStatusCode   int
+	}{
+		{
+			name:             "Browser",
+			accept:           "text/html,application/xhtml+xml",
+			wantContentType:  "text/html",
+			wantAuthenticate: false,
+			wantStatusCode:   http.StatusUnauthorized,
+		},
+		{
+			name:             "Client",
+			accept:           "",
+			wantContentType:  "application/problem+json",
+			wantAuthenticate: true,
+			wantStatusCode:   http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://www.test.com/ring", nil)
+			r.Header.Set("Accept", tc.accept)
+
+			res := serveArticle(env.GetArticle, r, "/ring")
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if got, want := res.Header.Get("Content-Type"), tc.wantContentType; !strings.HasPrefix(got, want) {
+				t.Fatalf("got content type %q, want %q", got, want)
+			}
+			if got, want := res.Header.Get("Www-Authenticate") != "", tc.wantAuthenticate; got != want {
+				t.Fatalf("got Www-Authenticate %v, want %v", got, want)
+			}
+
+			body, _ := io.ReadAll(res.Body)
+			if got, want := strings.Contains(string(body), `action="/.auth/article"`), !tc.wantAuthenticate; got != want {
+				t.Fatalf("got login form %v, want %v: %s", got, want, body)
+			}
+		})
+	}
+}
+
+func TestArticleLogin(t *testing.T) {
+	env := newProtectedEnv(t, time.Now())
+
+	testCases := []struct {
+		name     string
+		password string
+		next     string
+
+		wantStatusCode int
+		wantLocation   string
+		wantCookie     bool
+	}{
+		{
+			name:           "Valid",
+			password:       "potatoes",
+			next:           "/ring.md",
+			wantStatusCode: http.StatusSeeOther,
+			wantLocation:   "/ring.md",
+			wantCookie:     true,
+		},
+		{
+			name:           "WrongPassword",
+			password:       "fireworks",
+			next:           "/ring",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "OtherSite",
+			password:       "potatoes",
+			next:           "//www.example.com/",
+			wantStatusCode: http.StatusSeeOther,
+			wantLocation:   "/ring",
+			wantCookie:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{
+				"route":    {"/ring"},
+				"next":     {tc.next},
+				"username": {"samwise"},
+				"password": {tc.password},
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/article", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			env.ArticleLogin(w, r)
+
+			res := w.Result()
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if got, want := res.Header.Get("Location"), tc.wantLocation; got != want {
+				t.Fatalf("got location %q, want %q", got, want)
+			}
+			if got, want := len(res.Cookies()) == 1, tc.wantCookie; got != want {
+				t.Fatalf("got cookies %v, want a cookie %v", res.Cookies(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+// oidcCookieName is the name of the cookie that ties a login at the provider
+// to the browser that started it.
+const oidcCookieName = "bastion-oidc"
+
+// oidcCookiePath limits the cookie of a login to the endpoints of single
+// sign-on.
+const oidcCookiePath = "/.auth/oidc"
+
+// setOIDCCookie sets the cookie of the state of a login, or removes it if the
+// state is empty.
+func setOIDCCookie(w http.ResponseWriter, r *http.Request, state string) {
+	cookie := &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    state,
+		Path:     oidcCookiePath,
+		MaxAge:   int(auth.OIDCLoginDuration.Seconds()),
+		Secure:   secureRequest(r),
+		HttpOnly: true,
+		// The provider redirects back with a top-level GET, which carries
+		// the cookie in lax mode.
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if state == "" {
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+// OIDCLogin returns an HTTP handler that sends the user to log in at the
+// OpenID Connect provider, which sends them back to OIDCCallback.
+func (env Env) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	const op = "OIDCLogin"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if env.OIDC == nil || env.Sessions == nil {
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusNotFound,
+				Detail:     "single sign-on isn't enabled",
+			}.Wrap(errors.New("no OpenID Connect provider"))
+		}
+
+		next := localPath(r.URL.Query().Get("next"), "/.auth/sessions")
+
+		u, state, err := env.OIDC.AuthCodeURL(r.Context(), next, env.Clock.Now())
+		if err != nil {
+			env.Logger.With("op", op, "err", err.Error()).Print(log.Error, "single sign-on failed")
+			env.renderSessionLogin(w, next, "Single sign-on is unavailable, try again later.", http.StatusBadGateway)
+			return nil
+		}
+
+		setOIDCCookie(w, r, state)
+
+		http.Redirect(w, r, u, http.StatusFound)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// OIDCCallback returns an HTTP handler that finishes logging in a user who
+// was sent back by the OpenID Connect provider. If their ID token is valid, a
+// session is started, its cookie is issued and the user is redirected to the
+// path they were logging in to.
+func (env Env) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	const op = "OIDCCallback"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if env.OIDC == nil || env.Sessions == nil {
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusNotFound,
+				Detail:     "single sign-on isn't enabled",
+			}.Wrap(errors.New("no OpenID Connect provider"))
+		}
+
+		query := r.URL.Query()
+		state := query.Get("state")
+
+		// The state must have been issued to this browser, so that nobody
+		// can log a user in to the account of somebody else.
+		cookie, err := r.Cookie(oidcCookieName)
+		if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			env.renderSessionLogin(w, "/.auth/sessions", "The login expired, try again.", http.StatusBadRequest)
+			return nil
+		}
+		setOIDCCookie(w, r, "")
+
+		if e := query.Get("error"); e != "" {
+			env.Logger.With("op", op, "err", e, "description", query.Get("error_description")).Print(log.Info, "login failed")
+			env.renderSessionLogin(w, "/.auth/sessions", "The provider didn't log you in.", http.StatusUnauthorized)
+			return nil
+		}
+
+		claims, next, err := env.OIDC.Exchange(r.Context(), state, query.Get("code"), env.Clock.Now())
+		switch {
+		case errors.Is(err, auth.ErrOIDCState):
+			env.renderSessionLogin(w, "/.auth/sessions", "The login expired, try again.", http.StatusBadRequest)
+			return nil
+		case errors.Is(err, auth.ErrOIDCProvider):
+			env.Logger.With("op", op, "err", err.Error()).Print(log.Error, "single sign-on failed")
+			env.renderSessionLogin(w, "/.auth/sessions", "Single sign-on is unavailable, try again later.", http.StatusBadGateway)
+			return nil
+		case err != nil:
+			env.Logger.With("op", op, "err", err.Error()).Print(log.Info, "login failed")
+			env.renderSessionLogin(w, "/.auth/sessions", "The provider didn't log you in.", http.StatusUnauthorized)
+			return nil
+		}
+
+		session, err := env.Sessions.Create(claims, r.UserAgent(), env.clientIP(r), env.Clock.Now(), sessionDuration)
+		if err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		if err := env.setSessionCookie(w, r, &session); err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		env.Logger.With("username", claims.Username, "roles", claims.Roles).Print(log.Info, "Started session with single sign-on")
+
+		http.Redirect(w, r, next, http.StatusSeeOther)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
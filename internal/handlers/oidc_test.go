@@ -0,0 +1,132 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	now := time.Now()
+
+	provider, err := tests.NewMockOIDCProvider("bastion", "potatoes")
+	if err != nil {
+		t.Fatalf("failed to start provider: %v", err)
+	}
+	defer provider.Close()
+	provider.Claims = map[string]any{"email": "samwise@shire.test", "groups": []string{"gardeners"}}
+
+	oidc, err := auth.NewOIDC(auth.OIDCConfig{
+		Issuer:       provider.URL,
+		ClientID:     "bastion",
+		ClientSecret: "potatoes",
+		RedirectURL:  "http://www.test.com/.auth/oidc/callback",
+		Roles:        map[string][]string{"gardeners": {auth.RoleEditor}},
+	}, provider.Client())
+	if err != nil {
+		t.Fatalf("failed to create relying party: %v", err)
+	}
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	env := handlers.Env{
+		Store:    newMockStore(),
+		Logger:   log.NewNop(),
+		Clock:    tests.MockClock(now),
+		Auth:     auth.NewDisabled(),
+		SignKey:  key,
+		Sessions: auth.NewMemorySessionStore(),
+		OIDC:     oidc,
+	}
+
+	// login starts logging in, and returns the cookie of the login along with
+	// the URL the provider sends the user back to.
+	login := func() (*http.Cookie, string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.auth/oidc/login?next=/hello", nil)
+
+		env.OIDCLogin(w, r)
+
+		res := w.Result()
+		if got, want := res.StatusCode, http.StatusFound; got != want {
+			t.Fatalf("got status code %d, want %d", got, want)
+		}
+		cookies := res.Cookies()
+		if got, want := len(cookies), 1; got != want {
+			t.Fatalf("got %d cookies, want %d", got, want)
+		}
+
+		callback, err := provider.Authorize(res.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("failed to log in at provider: %v", err)
+		}
+
+		return cookies[0], callback
+	}
+
+	callback := func(cookie *http.Cookie, u string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, u, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+
+		env.OIDCCallback(w, r)
+
+		return w.Result()
+	}
+
+	// A login started by somebody else's browser isn't finished.
+	_, u := login()
+	other, _ := login()
+	if got, want := callback(other, u).StatusCode, http.StatusBadRequest; got != want {
+		t.Fatalf("got status code %d for another login, want %d", got, want)
+	}
+	if got, want := callback(nil, u).StatusCode, http.StatusBadRequest; got != want {
+		t.Fatalf("got status code %d without a cookie, want %d", got, want)
+	}
+
+	cookie, u := login()
+	res := callback(cookie, u)
+	if got, want := res.StatusCode, http.StatusSeeOther; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("Location"), "/hello"; got != want {
+		t.Fatalf("got redirect to %s, want %s", got, want)
+	}
+
+	var session *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == "bastion-session" {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatalf("got no session cookie")
+	}
+
+	// The session grants the roles of the groups of the user.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/hello", nil)
+	r.AddCookie(session)
+
+	env.Authorize(env.RequireRole(auth.RoleEditor)(noopHandler)).ServeHTTP(w, r)
+
+	if got, want := w.Result().StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d with the session, want %d", got, want)
+	}
+
+	// The code can't be used again.
+	if got, want := callback(cookie, u).StatusCode, http.StatusBadRequest; got != want {
+		t.Fatalf("got status code %d logging in again, want %d", got, want)
+	}
+}
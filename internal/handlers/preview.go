@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+// Preview returns an HTTP handler function to respond to HTTP requests to
+// preview a document. The handler will write an HTML representation of the
+// document in the request body, exactly as the document would be shown as an
+// article, without saving it. If the document has problems, a problemjson
+// response is written with the line of each problem in the detail.
+func (env Env) Preview(w http.ResponseWriter, r *http.Request) {
+	const op = "Preview"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusInternalServerError,
+				Detail:     "failed to read request",
+			}.Wrap(err)
+		}
+
+		invalid := errors.Note{
+			Op:         op,
+			Title:      "Invalid Document",
+			StatusCode: http.StatusBadRequest,
+		}
+
+		doc, err := content.UnmarshalDocument(data)
+		if err != nil {
+			invalid.Detail = err.Error()
+			return invalid.Wrap(err)
+		}
+
+		article := content.NewArticle(doc)
+		if article.Err != nil {
+			invalid.Detail = article.Err.Error()
+			return invalid.Wrap(article.Err)
+		}
+
+		vars := templateVariables{
+			Title:       article.Title,
+			Description: article.Description,
+			HTML:        article.HTML,
+			Tags:        article.Tags,
+			content:     env.store(r),
+		}
+
+		buf := &bytes.Buffer{}
+		articleTemplate.Execute(buf, vars)
+
+		w.Header().Add("Content-Type", "text/html")
+		w.Write(buf.Bytes())
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
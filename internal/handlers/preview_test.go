@@ -0,0 +1,93 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+func TestPreview(t *testing.T) {
+	store := newMockStore()
+	env := handlers.Env{
+		Store:  store,
+		Logger: log.NewNop(),
+	}
+
+	testCases := []struct {
+		name string
+		body string
+
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "Valid",
+			body:           "title: The Shire\n=== markdown ===\nIn a hole in the ground there lived a *hobbit*.",
+			wantStatusCode: http.StatusOK,
+			wantBody:       "<em>hobbit</em>",
+		},
+		{
+			name:           "MissingDelimiter",
+			body:           "title: The Shire\nIn a hole in the ground",
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       "content delimiter",
+		},
+		{
+			name:           "InvalidProperty",
+			body:           "title: The Shire\npinned: maybe\n=== markdown ===\nIn a hole in the ground",
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       "line 2: article property 'Pinned' must be true or false",
+		},
+		{
+			name:           "UnknownFormat",
+			body:           "title: The Shire\n=== latex ===\nIn a hole in the ground",
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       "line 2: unknown format",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.preview", strings.NewReader(tc.body))
+
+			env.Preview(w, r)
+
+			res := w.Result()
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("failed to read response body: %v", err)
+			}
+
+			got := string(body)
+			if res.StatusCode != http.StatusOK {
+				var problem struct {
+					Detail string `json:"detail"`
+				}
+				if err := json.Unmarshal(body, &problem); err != nil {
+					t.Fatalf("failed to decode problem: %v", err)
+				}
+				got = problem.Detail
+			}
+
+			if !strings.Contains(got, tc.wantBody) {
+				t.Fatalf("got %q, want it to contain %q", got, tc.wantBody)
+			}
+		})
+	}
+
+	if len(store.articles) != 0 {
+		t.Fatalf("preview modified the store")
+	}
+}
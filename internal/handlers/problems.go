@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 
 	"github.com/go-chi/chi"
 	"github.com/toddgaunt/bastion/internal/errors"
@@ -12,6 +13,24 @@ import (
 
 const problemsCtxKey = contextKey("problemID")
 
+// problemDescriptions documents every problem that has a page describing it.
+var problemDescriptions = map[string]string{
+	"article-not-found":     `This article does not exist`,
+	"not-found":             `There was no content available`,
+	"internal-server-error": `The server experienced an error which was no fault of the client`,
+}
+
+// ProblemIDs returns the ID of every problem that has a page describing it.
+func ProblemIDs() []string {
+	ids := make([]string, 0, len(problemDescriptions))
+	for id := range problemDescriptions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
 // ProblemID extracts the problem ID from the request URL
 func ProblemID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -29,16 +48,8 @@ func (env Env) Problems(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
 		problemID := r.Context().Value(problemsCtxKey).(string)
 
-		description := ""
-
-		switch problemID {
-		case "article-not-found":
-			description = `This article does not exist`
-		case "not-found":
-			description = `There was no content available`
-		case "internal-server-error":
-			description = `The server experienced an error which was no fault of the client`
-		default:
+		description, ok := problemDescriptions[problemID]
+		if !ok {
 			return errors.Note{
 				Op:         op,
 				StatusCode: http.StatusNotFound,
@@ -49,7 +60,7 @@ func (env Env) Problems(w http.ResponseWriter, r *http.Request) {
 		vars := templateVariables{
 			Title:       problemID,
 			Description: description,
-			content:     env.Store,
+			content:     env.store(r),
 		}
 
 		buf := &bytes.Buffer{}
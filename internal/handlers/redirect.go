@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/toddgaunt/bastion/internal/content"
+)
+
+// Redirect is a middleware that redirects GET and HEAD requests for the
+// routes in the redirect table of the environment. The query of a request is
+// kept, and other requests continue unchanged so that the documents at those
+// routes can still be edited.
+func (env Env) Redirect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(env.Redirects) == 0 || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		redirect, location, ok := content.FindRedirect(env.Redirects, path.Clean(r.URL.Path))
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.URL.RawQuery != "" {
+			if strings.Contains(location, "?") {
+				location += "&" + r.URL.RawQuery
+			} else {
+				location += "?" + r.URL.RawQuery
+			}
+		}
+
+		http.Redirect(w, r, location, redirect.Code())
+	})
+}
@@ -0,0 +1,160 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func TestAliasRedirect(t *testing.T) {
+	now := time.Now()
+
+	store := newMockStore(
+		content.Article{Route: "/rivendell", Title: "Rivendell", HTML: "<p>Elves</p>", Aliases: []string{"/imladris"}},
+		content.Article{Route: "/moria", Title: "Moria", HTML: "<p>Dwarves</p>", Aliases: []string{"/khazad-dum"}, Publish: now.Add(time.Hour)},
+		content.Article{Route: "/isengard", Title: "Isengard", HTML: "<p>Wizards</p>", Aliases: []string{"/orthanc"}, Draft: true},
+		content.Article{Route: "/shire", Title: "Shire", HTML: "<p>Hobbits</p>", Aliases: []string{"/rivendell"}},
+	)
+	store.now = now
+
+	env := handlers.Env{
+		Store:  store,
+		Logger: log.NewNop(),
+		Clock:  tests.MockClock(now),
+	}
+
+	testCases := []struct {
+		name   string
+		target string
+		route  string
+
+		wantStatusCode int
+		wantLocation   string
+	}{
+		{
+			name:           "Alias",
+			target:         "/imladris",
+			route:          "/imladris",
+			wantStatusCode: http.StatusMovedPermanently,
+			wantLocation:   "/rivendell",
+		},
+		{
+			name:           "Source",
+			target:         "/imladris.md",
+			route:          "/imladris.md",
+			wantStatusCode: http.StatusMovedPermanently,
+			wantLocation:   "/rivendell.md",
+		},
+		{
+			name:           "Query",
+			target:         "/imladris?ring=one",
+			route:          "/imladris",
+			wantStatusCode: http.StatusMovedPermanently,
+			wantLocation:   "/rivendell?ring=one",
+		},
+		{
+			name:           "ArticleBeforeAlias",
+			target:         "/rivendell",
+			route:          "/rivendell",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "NotPublished",
+			target:         "/khazad-dum",
+			route:          "/khazad-dum",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Draft",
+			target:         "/orthanc",
+			route:          "/orthanc",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://www.test.com"+tc.target, nil)
+
+			res := serveArticle(env.GetArticle, r, tc.route)
+
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if got, want := res.Header.Get("Location"), tc.wantLocation; got != want {
+				t.Fatalf("got location %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	env := handlers.Env{
+		Logger: log.NewNop(),
+		Redirects: []content.Redirect{
+			{From: "/blog", To: "/posts", Prefix: true},
+			{From: "/mordor", To: "https://www.example.com/mordor", Status: http.StatusFound},
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+
+		wantStatusCode int
+		wantLocation   string
+	}{
+		{
+			name:           "Exact",
+			method:         http.MethodGet,
+			target:         "/mordor",
+			wantStatusCode: http.StatusFound,
+			wantLocation:   "https://www.example.com/mordor",
+		},
+		{
+			name:           "Prefix",
+			method:         http.MethodGet,
+			target:         "/blog/shire?page=2",
+			wantStatusCode: http.StatusMovedPermanently,
+			wantLocation:   "/posts/shire?page=2",
+		},
+		{
+			name:           "Unmatched",
+			method:         http.MethodGet,
+			target:         "/shire",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Edit",
+			method:         http.MethodPost,
+			target:         "/blog/shire",
+			wantStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tc.method, "http://www.test.com"+tc.target, nil)
+
+			env.Redirect(noopHandler).ServeHTTP(w, r)
+
+			res := w.Result()
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if got, want := res.Header.Get("Location"), tc.wantLocation; got != want {
+				t.Fatalf("got location %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/search"
+)
+
+// maxSearchResults is the most results returned for a single query.
+const maxSearchResults = 50
+
+// searchResponse is the payload returned by the JSON search endpoint.
+type searchResponse struct {
+	Query   string          `json:"query"`
+	Results []search.Result `json:"results"`
+}
+
+// noSearch is the problem the search handlers respond with if the site has no
+// search.
+var noSearch = errors.Note{
+	Title:      "Search Not Found",
+	StatusCode: http.StatusNotFound,
+	Detail:     "this site can't be searched",
+}
+
+// Search returns an HTTP handler that responds with an HTML page of the
+// articles matching the query in the q parameter.
+func (env Env) Search(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if env.Searcher == nil {
+			return noSearch.Wrap(errors.New("no searcher"))
+		}
+
+		query := r.URL.Query().Get("q")
+		details := env.Store.GetDetails()
+
+		vars := templateVariables{
+			Title:       "Search",
+			Description: fmt.Sprintf("Search articles on %s", details.Name),
+			Query:       query,
+			content:     env.store(r),
+		}
+		if query != "" {
+			vars.Results = env.Searcher.Search(query, maxSearchResults, env.now(r))
+		}
+
+		buf := &bytes.Buffer{}
+		searchTemplate.Execute(buf, vars)
+
+		w.Header().Add("Content-Type", "text/html")
+		w.Write(buf.Bytes())
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// SearchJSON returns an HTTP handler that responds with the articles matching
+// the query in the q parameter as JSON.
+func (env Env) SearchJSON(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if env.Searcher == nil {
+			return noSearch.Wrap(errors.New("no searcher"))
+		}
+
+		query := r.URL.Query().Get("q")
+		if query == "" {
+			return errors.Note{
+				StatusCode: http.StatusBadRequest,
+				Detail:     "the q parameter is required",
+			}.Wrap(errors.New("empty query"))
+		}
+
+		resp := searchResponse{
+			Query:   query,
+			Results: env.Searcher.Search(query, maxSearchResults, env.now(r)),
+		}
+		if resp.Results == nil {
+			resp.Results = []search.Result{}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(resp); err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+const sessionKey contextKey = "session"
+
+// sessionCookieName is the name of the cookie of a session.
+const sessionCookieName = "bastion-session"
+
+// sessionAudience is the audience of the tokens in session cookies, so that
+// they can't be used as access tokens.
+const sessionAudience = "session"
+
+// csrfHeader and csrfField are where requests authenticated by a session
+// cookie send the CSRF token of the session.
+const (
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf_token"
+)
+
+// sessionDuration is how long a session lasts after logging in from a browser.
+var sessionDuration = time.Duration(time.Hour * 24)
+
+// hasSessionCookie returns true if a request is authenticated by a session
+// cookie rather than an Authorization header.
+func hasSessionCookie(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	_, err := r.Cookie(sessionCookieName)
+	return err == nil
+}
+
+// safeMethod returns true if a request method doesn't change anything, so it
+// doesn't need a CSRF token.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// csrfToken returns the CSRF token sent with a request, from either its
+// header or a form field.
+func csrfToken(r *http.Request) string {
+	if token := r.Header.Get(csrfHeader); token != "" {
+		return token
+	}
+	return r.PostFormValue(csrfField)
+}
+
+// verifySession returns the session of the session cookie of a request, if it
+// is valid.
+func (env Env) verifySession(r *http.Request) (auth.Session, errors.Problem) {
+	if env.Sessions == nil {
+		return auth.Session{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "sessions aren't accepted"}.Wrap(errors.New("no session store"))
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return auth.Session{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "log in again"}.Wrap(err)
+	}
+
+	now := env.Clock.Now()
+
+	claims, err := env.SignKey.Verify(auth.JWT(cookie.Value))
+	if err == nil && (!claims.IsValid(now) || claims.Audience != sessionAudience) {
+		err = errors.New("not a valid session cookie")
+	}
+	if err != nil {
+		return auth.Session{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "log in again"}.Wrap(err)
+	}
+
+	session, err := env.Sessions.Get(claims.SessionID, now)
+	switch {
+	case errors.Is(err, auth.ErrSessionNotFound), errors.Is(err, auth.ErrSessionExpired):
+		return auth.Session{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "log in again"}.Wrap(err)
+	case err != nil:
+		return auth.Session{}, statusInternal.Wrap(err)
+	}
+
+	// A session grants the roles its user has now, so that removing a user
+	// or one of their roles also applies to their sessions. The users of a
+	// single sign-on provider can't be looked up, so they keep the roles
+	// they logged in with until the session ends.
+	if env.Auth != nil && !auth.IsOIDCUser(session.Claims.Username) {
+		claims, err := env.Auth.Lookup(session.Claims.Username)
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			if err := env.Sessions.Revoke(session.ID); err != nil {
+				env.Logger.With("err", err.Error()).Print(log.Error, "failed to revoke session")
+			}
+			return auth.Session{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "log in again"}.Wrap(err)
+		case err != nil:
+			return auth.Session{}, statusInternal.Wrap(err)
+		}
+		session.Claims = claims
+	}
+
+	return session, nil
+}
+
+// setSessionCookie sets the cookie of a session, or removes it if the session
+// is nil.
+func (env Env) setSessionCookie(w http.ResponseWriter, r *http.Request, session *auth.Session) error {
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   secureRequest(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if session != nil {
+		claims := auth.Claims{Username: session.Claims.Username, SessionID: session.ID, Audience: sessionAudience}
+
+		token, err := env.SignKey.Sign(claims, env.Clock.Now(), session.Expires.Sub(env.Clock.Now()))
+		if err != nil {
+			return err
+		}
+
+		cookie.Value = string(token)
+		cookie.MaxAge = int(session.Expires.Sub(env.Clock.Now()).Seconds())
+	}
+
+	http.SetCookie(w, cookie)
+
+	return nil
+}
+
+// renderSessionLogin writes the login form of the site.
+func (env Env) renderSessionLogin(w http.ResponseWriter, next, message string, status int) {
+	vars := templateVariables{
+		Title:   "Log in",
+		Next:    next,
+		Message: message,
+		SSO:     env.OIDC != nil,
+		content: env.Store,
+	}
+
+	buf := &bytes.Buffer{}
+	signinTemplate.Execute(buf, vars)
+
+	w.Header().Add("Content-Type", "text/html")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// SessionLogin returns an HTTP handler that logs in to the site from a form.
+// A GET shows the form, while a POST of the form checks the user's
+// credentials, along with their one-time code if they enrolled a second
+// factor. If they are correct, a session is started, its cookie is issued and
+// the user is redirected to the path in the next field.
+func (env Env) SessionLogin(w http.ResponseWriter, r *http.Request) {
+	const op = "SessionLogin"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if env.Sessions == nil {
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusNotFound,
+				Detail:     "logging in from a browser isn't enabled",
+			}.Wrap(errors.New("no session store"))
+		}
+
+		if err := r.ParseForm(); err != nil {
+			return statusBadRequest.Wrap(err)
+		}
+
+		next := localPath(r.Form.Get("next"), "/.auth/sessions")
+
+		if r.Method == http.MethodGet {
+			env.renderSessionLogin(w, next, "", http.StatusOK)
+			return nil
+		}
+
+		username := r.PostForm.Get("username")
+
+		keys := env.attemptKeys(r, "", username)
+		if prob := env.allowAttempt(w, keys); prob != nil {
+			env.renderSessionLogin(w, next, "Too many failed attempts to log in, try again later.", http.StatusTooManyRequests)
+			return nil
+		}
+
+		claims, err := env.Auth.Authenticate(username, r.PostForm.Get("password"))
+		if err != nil {
+			env.failAttempt(keys)
+			env.Logger.With("op", op, "err", err.Error()).Print(log.Info, "login failed")
+			env.renderSessionLogin(w, next, "Invalid username or password.", http.StatusUnauthorized)
+			return nil
+		}
+		env.succeedAttempt(keys)
+
+		if env.Auth.SecondFactor(username) {
+			code := r.PostForm.Get("code")
+			if code == "" {
+				env.renderSessionLogin(w, next, "Enter the code from your authenticator app.", http.StatusUnauthorized)
+				return nil
+			}
+
+			keys := env.attemptKeys(r, codeAudience, username)
+			if prob := env.allowAttempt(w, keys); prob != nil {
+				env.renderSessionLogin(w, next, "Too many failed attempts to log in, try again later.", http.StatusTooManyRequests)
+				return nil
+			}
+
+			if err := env.Auth.VerifyCode(username, code, env.Clock.Now()); err != nil {
+				env.failAttempt(keys)
+				env.Logger.With("op", op, "err", err.Error()).Print(log.Info, "login failed")
+				env.renderSessionLogin(w, next, "Invalid code.", http.StatusUnauthorized)
+				return nil
+			}
+			env.succeedAttempt(keys)
+		}
+
+		session, err := env.Sessions.Create(claims, r.UserAgent(), env.clientIP(r), env.Clock.Now(), sessionDuration)
+		if err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		if err := env.setSessionCookie(w, r, &session); err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		env.Logger.With("username", username).Print(log.Info, "Started session")
+
+		http.Redirect(w, r, next, http.StatusSeeOther)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// SessionLogout returns an HTTP handler that ends the session of the request
+// and removes its cookie. It must follow Authorize.
+func (env Env) SessionLogout(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if session, ok := r.Context().Value(sessionKey).(auth.Session); ok {
+			if err := env.Sessions.Revoke(session.ID); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+				return statusInternal.Wrap(err)
+			}
+		}
+
+		if err := env.setSessionCookie(w, r, nil); err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// ListSessions returns an HTTP handler that lists the sessions of the
+// authorized user, each of which can be ended. It must follow Authorize.
+func (env Env) ListSessions(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if env.Sessions == nil {
+			return errors.Note{
+				StatusCode: http.StatusNotFound,
+				Detail:     "logging in from a browser isn't enabled",
+			}.Wrap(errors.New("no session store"))
+		}
+
+		claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+		if !ok {
+			return statusUnauthorized.Wrap(errors.New("no claims"))
+		}
+		session, _ := r.Context().Value(sessionKey).(auth.Session)
+
+		sessions, err := env.Sessions.List(claims.Username)
+		if err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		vars := templateVariables{
+			Title:    "Sessions",
+			Session:  session,
+			Sessions: sessions,
+			content:  env.Store,
+		}
+
+		buf := &bytes.Buffer{}
+		sessionsTemplate.Execute(buf, vars)
+
+		w.Header().Add("Content-Type", "text/html")
+		w.Write(buf.Bytes())
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// RevokeSession returns an HTTP handler that ends the session of the
+// authorized user given by the id field of a form. It must follow Authorize.
+func (env Env) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+		if !ok || env.Sessions == nil {
+			return statusUnauthorized.Wrap(errors.New("no claims"))
+		}
+
+		id := r.PostFormValue("id")
+
+		sessions, err := env.Sessions.List(claims.Username)
+		if err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		found := false
+		for _, session := range sessions {
+			found = found || session.ID == id
+		}
+		if found {
+			err = env.Sessions.Revoke(id)
+		} else {
+			err = auth.ErrSessionNotFound
+		}
+
+		switch {
+		case errors.Is(err, auth.ErrSessionNotFound):
+			return errors.Note{
+				StatusCode: http.StatusNotFound,
+				Detail:     "no session with that ID",
+			}.Wrap(err)
+		case err != nil:
+			return statusInternal.Wrap(err)
+		}
+
+		env.Logger.With("username", claims.Username).Print(log.Info, "Ended session")
+
+		if session, ok := r.Context().Value(sessionKey).(auth.Session); ok && session.ID == id {
+			if err := env.setSessionCookie(w, r, nil); err != nil {
+				return statusInternal.Wrap(err)
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return nil
+		}
+
+		http.Redirect(w, r, "/.auth/sessions", http.StatusSeeOther)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
@@ -0,0 +1,277 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+// sessionLogin logs in from the form of the site and returns the response.
+func sessionLogin(env handlers.Env, username, password string) *http.Response {
+	form := url.Values{"username": {username}, "password": {password}, "next": {"/hello"}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/session", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	env.SessionLogin(w, r)
+
+	return w.Result()
+}
+
+func TestSessionLogin(t *testing.T) {
+	now := time.Now()
+
+	authenticator, err := auth.NewSimple("samwise", "potatoes")
+	if err != nil {
+		t.Fatalf("failed to initialize simple auth: %v", err)
+	}
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	env := handlers.Env{
+		Store:    newMockStore(),
+		Logger:   log.NewNop(),
+		Clock:    tests.MockClock(now),
+		Auth:     authenticator,
+		SignKey:  key,
+		Sessions: auth.NewMemorySessionStore(),
+	}
+
+	res := sessionLogin(env, "samwise", "fireworks")
+	if got, want := res.StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d for the wrong password, want %d", got, want)
+	}
+	if got := res.Cookies(); len(got) != 0 {
+		t.Fatalf("got cookies %v for the wrong password, want none", got)
+	}
+
+	res = sessionLogin(env, "samwise", "potatoes")
+	if got, want := res.StatusCode, http.StatusSeeOther; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("Location"), "/hello"; got != want {
+		t.Fatalf("got redirect to %s, want %s", got, want)
+	}
+
+	cookies := res.Cookies()
+	if got, want := len(cookies), 1; got != want {
+		t.Fatalf("got %d cookies, want %d", got, want)
+	}
+	cookie := cookies[0]
+	if !cookie.HttpOnly {
+		t.Fatalf("got a session cookie that scripts can read")
+	}
+
+	sessions, err := env.Sessions.List("samwise")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("got sessions %v and err %v, want one session", sessions, err)
+	}
+	csrf := sessions[0].CSRFToken
+
+	testCases := []struct {
+		name    string
+		method  string
+		header  http.Header
+		form    url.Values
+		cookie  bool
+		handler http.Handler
+
+		wantStatusCode int
+	}{
+		{
+			name:           "Read",
+			method:         http.MethodGet,
+			cookie:         true,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "WriteWithoutCSRFToken",
+			method:         http.MethodPost,
+			cookie:         true,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "WriteWithWrongCSRFToken",
+			method:         http.MethodPost,
+			header:         http.Header{"X-Csrf-Token": {"bananas"}},
+			cookie:         true,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "WriteWithCSRFHeader",
+			method:         http.MethodPost,
+			header:         http.Header{"X-Csrf-Token": {csrf}},
+			cookie:         true,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "WriteWithCSRFField",
+			method:         http.MethodPost,
+			form:           url.Values{"csrf_token": {csrf}},
+			cookie:         true,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "CookieAsAccessToken",
+			method:         http.MethodGet,
+			header:         http.Header{"Authorization": {"Bearer " + cookie.Value}},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "RequiresRole",
+			method:         http.MethodPost,
+			header:         http.Header{"X-Csrf-Token": {csrf}},
+			cookie:         true,
+			handler:        env.RequireRole(auth.RoleEditor)(noopHandler),
+			wantStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tc.method, "http://www.test.com/hello", strings.NewReader(tc.form.Encode()))
+			if tc.form != nil {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+			if tc.cookie {
+				r.AddCookie(cookie)
+			}
+
+			handler := tc.handler
+			if handler == nil {
+				handler = noopHandler
+			}
+
+			env.Authorize(handler).ServeHTTP(w, r)
+
+			if got, want := w.Result().StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+		})
+	}
+
+	// The sessions page offers to log out.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.auth/sessions", nil)
+	r.AddCookie(cookie)
+
+	env.Authorize(http.HandlerFunc(env.ListSessions)).ServeHTTP(w, r)
+
+	body, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	if !strings.Contains(string(body), `name="csrf_token" value="`+csrf+`"`) {
+		t.Fatalf("got sessions page without the CSRF token:\n%s", body)
+	}
+
+	// Logging out ends the session, so its cookie is no longer accepted.
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/session/logout", strings.NewReader("csrf_token="+csrf))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(cookie)
+
+	env.Authorize(http.HandlerFunc(env.SessionLogout)).ServeHTTP(w, r)
+
+	if got, want := w.Result().StatusCode, http.StatusSeeOther; got != want {
+		t.Fatalf("got status code %d logging out, want %d", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "http://www.test.com/hello", nil)
+	r.AddCookie(cookie)
+
+	env.Authorize(noopHandler).ServeHTTP(w, r)
+
+	if got, want := w.Result().StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d after logging out, want %d", got, want)
+	}
+}
+
+func TestSessionFollowsUser(t *testing.T) {
+	now := time.Now()
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	samwise := auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor}}
+	users := newUserFile(t, samwise)
+
+	env := handlers.Env{
+		Store:    newMockStore(),
+		Logger:   log.NewNop(),
+		Clock:    tests.MockClock(now),
+		Auth:     users,
+		SignKey:  key,
+		Sessions: auth.NewMemorySessionStore(),
+	}
+
+	res := sessionLogin(env, "samwise", "potatoes")
+	if got, want := res.StatusCode, http.StatusSeeOther; got != want {
+		t.Fatalf("got status code %d logging in, want %d", got, want)
+	}
+	cookie := res.Cookies()[0]
+
+	edit := func() int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://www.test.com/hello", nil)
+		r.AddCookie(cookie)
+
+		env.Authorize(env.RequireRole(auth.RoleEditor)(noopHandler)).ServeHTTP(w, r)
+
+		return w.Result().StatusCode
+	}
+
+	// replaceUsers replaces the users of the user file.
+	replaceUsers := func(claims ...auth.Claims) {
+		data, err := os.ReadFile(newUserFile(t, claims...).Path)
+		if err != nil {
+			t.Fatalf("failed to read users: %v", err)
+		}
+		if err := os.WriteFile(users.Path, data, 0600); err != nil {
+			t.Fatalf("failed to write users: %v", err)
+		}
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(users.Path, later, later); err != nil {
+			t.Fatalf("failed to modify users: %v", err)
+		}
+	}
+
+	if got, want := edit(), http.StatusOK; got != want {
+		t.Fatalf("got status code %d as an editor, want %d", got, want)
+	}
+
+	replaceUsers(auth.Claims{Username: "samwise"}, auth.Claims{Username: "frodo"})
+	if got, want := edit(), http.StatusForbidden; got != want {
+		t.Fatalf("got status code %d once the editor role was taken away, want %d", got, want)
+	}
+
+	replaceUsers(auth.Claims{Username: "frodo"})
+	if got, want := edit(), http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d once the user was removed, want %d", got, want)
+	}
+	if sessions, _ := env.Sessions.List("samwise"); len(sessions) != 0 {
+		t.Fatalf("got %d sessions of a removed user, want 0", len(sessions))
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+// shareDuration is the lifetime of a share link if none is requested, and
+// maxShareDuration is the longest lifetime one can have.
+var (
+	shareDuration    = time.Duration(time.Hour * 24 * 7)
+	maxShareDuration = time.Duration(time.Hour * 24 * 30)
+)
+
+// shareParameter is the query parameter that carries the token of a share
+// link.
+const shareParameter = "share"
+
+// shareAudience returns the audience of the tokens in the share links of the
+// draft with a route, so that they can't be used for any other article, or as
+// access tokens.
+func shareAudience(route string) string {
+	return "share:" + route
+}
+
+// shareRequest is the payload of a request to share a draft.
+type shareRequest struct {
+	Route string `json:"route"`
+	// ExpiresIn is the lifetime of the share link in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// shareResponse is the payload returned once a draft is shared.
+type shareResponse struct {
+	URL     string    `json:"url"`
+	Route   string    `json:"route"`
+	Expires time.Time `json:"expires"`
+}
+
+// readsDraft returns true if a request may view a draft, which requires
+// either its share link or the credentials of an editor.
+func (env Env) readsDraft(r *http.Request, article content.Article) bool {
+	if env.SignKey == nil {
+		return false
+	}
+
+	if token := r.URL.Query().Get(shareParameter); token != "" {
+		claims, err := env.SignKey.Verify(auth.JWT(token))
+		if err == nil && claims.IsValid(env.Clock.Now()) && claims.Audience == shareAudience(article.Route) {
+			return true
+		}
+	}
+
+	claims, prob := env.verifyRequest(r)
+	return prob == nil && claims.HasRole(auth.RoleEditor)
+}
+
+// ShareDraft returns an HTTP handler that creates a share link for the draft
+// at the route given by the request, which lets anybody with the link view
+// the draft until it expires after the lifetime in seconds given by the
+// request. It must follow Authorize and RequireRole.
+func (env Env) ShareDraft(w http.ResponseWriter, r *http.Request) {
+	const op = "ShareDraft"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+		if !ok {
+			return statusUnauthorized.Wrap(errors.New("no claims"))
+		}
+
+		var req shareRequest
+
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil {
+			return errors.Note{
+				StatusCode: http.StatusBadRequest,
+				Detail:     "expected a route",
+			}.Wrap(err)
+		}
+
+		// The lifetime is checked in seconds, so that it can't overflow.
+		if req.ExpiresIn < 0 || req.ExpiresIn > int64(maxShareDuration/time.Second) {
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusBadRequest,
+				Detail:     "a share link must expire within 30 days",
+			}.Wrap(errors.Errorf("invalid lifetime of %d seconds", req.ExpiresIn))
+		}
+
+		lifetime := shareDuration
+		if req.ExpiresIn != 0 {
+			lifetime = time.Duration(req.ExpiresIn) * time.Second
+		}
+
+		article, err := env.Store.Get(req.Route)
+		if err != nil {
+			return errors.Note{
+				Op:         op,
+				Title:      "Article Not Found",
+				StatusCode: http.StatusNotFound,
+				Detail:     "no article located at " + req.Route,
+			}.Wrap(err)
+		}
+		if !article.Draft {
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusBadRequest,
+				Detail:     "only drafts can be shared",
+			}.Wrap(errors.Errorf("%s isn't a draft", article.Route))
+		}
+
+		now := env.Clock.Now()
+		shareClaims := auth.Claims{Username: claims.Username, Audience: shareAudience(article.Route)}
+
+		token, err := env.SignKey.Sign(shareClaims, now, lifetime)
+		if err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		query := url.Values{shareParameter: {string(token)}}
+
+		env.Logger.With("username", claims.Username, "route", article.Route, "lifetime", lifetime.String()).Print(log.Info, "Shared draft")
+
+		return writeJSON(w, shareResponse{
+			URL:     env.siteURL(r, env.Store.GetDetails()) + article.Route + "?" + query.Encode(),
+			Route:   article.Route,
+			Expires: time.Unix(now.Add(lifetime).Unix(), 0).UTC(),
+		})
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
@@ -0,0 +1,179 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+func TestShareDraft(t *testing.T) {
+	now := time.Now()
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	store := newMockStore(
+		content.Article{Route: "/draft", Title: "Draft", HTML: "<p>Second breakfast</p>", Draft: true},
+		content.Article{Route: "/other", Title: "Other draft", HTML: "<p>Elevenses</p>", Draft: true},
+		content.Article{Route: "/ring", Title: "The Ring", HTML: "<p>One ring</p>"},
+	)
+	store.details.URL = "https://www.example.com/"
+
+	env := handlers.Env{
+		Store:   store,
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+		SignKey: key,
+	}
+
+	editor := mustSign(t, key, auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor}}, now, time.Minute)
+
+	// share asks for a share link and returns the response.
+	share := func(body string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/share", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+editor)
+
+		env.Authorize(env.RequireRole(auth.RoleEditor)(http.HandlerFunc(env.ShareDraft))).ServeHTTP(w, r)
+
+		return w.Result()
+	}
+
+	res := share(`{"route": "/draft", "expires_in": 3600}`)
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status code %d sharing a draft, want %d", got, want)
+	}
+
+	var shared struct {
+		URL     string    `json:"url"`
+		Expires time.Time `json:"expires"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&shared); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !strings.HasPrefix(shared.URL, "https://www.example.com/draft?share=") {
+		t.Fatalf("got share link %s, want one for the draft", shared.URL)
+	}
+	if got, want := shared.Expires, now.Add(time.Hour).Truncate(time.Second); !got.Equal(want) {
+		t.Fatalf("got expiry %v, want %v", got, want)
+	}
+
+	u, err := url.Parse(shared.URL)
+	if err != nil {
+		t.Fatalf("failed to parse share link: %v", err)
+	}
+	token := u.Query().Get("share")
+
+	testCases := []struct {
+		name  string
+		route string
+		query string
+		token string
+		now   time.Time
+
+		wantStatusCode int
+	}{
+		{
+			name:           "Listed",
+			route:          "/ring",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Draft",
+			route:          "/draft",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Editor",
+			route:          "/draft",
+			token:          editor,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "ShareLink",
+			route:          "/draft",
+			query:          u.RawQuery,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "ShareLinkOfAnotherDraft",
+			route:          "/other",
+			query:          u.RawQuery,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "ExpiredShareLink",
+			route:          "/draft",
+			query:          u.RawQuery,
+			now:            now.Add(time.Hour),
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "ForgedShareLink",
+			route:          "/draft",
+			query:          "share=" + mustSign(t, auth.SymmetricKey{}, auth.Claims{Audience: "share:/draft"}, now, time.Hour),
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			env := env
+			if !tc.now.IsZero() {
+				env.Clock = tests.MockClock(tc.now)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://www.test.com"+tc.route+"?"+tc.query, nil)
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			res := serveArticle(env.GetArticle, r, tc.route)
+
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if got, want := res.Header.Get("Referrer-Policy"), "no-referrer"; tc.route == "/draft" && res.StatusCode == http.StatusOK && got != want {
+				t.Fatalf("got Referrer-Policy %q for a draft, want %q", got, want)
+			}
+		})
+	}
+
+	// A share link isn't an access token.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://www.test.com/hello", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	env.Authorize(noopHandler).ServeHTTP(w, r)
+
+	if got, want := w.Result().StatusCode, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status code %d using a share link as an access token, want %d", got, want)
+	}
+
+	if got, want := share(`{"route": "/ring"}`).StatusCode, http.StatusBadRequest; got != want {
+		t.Fatalf("got status code %d sharing a listed article, want %d", got, want)
+	}
+	if got, want := share(`{"route": "/draft", "expires_in": 31536000}`).StatusCode, http.StatusBadRequest; got != want {
+		t.Fatalf("got status code %d sharing a draft for a year, want %d", got, want)
+	}
+	// A lifetime whose nanoseconds wrap around to a fraction of a second.
+	if got, want := share(`{"route": "/draft", "expires_in": 18446744074}`).StatusCode, http.StatusBadRequest; got != want {
+		t.Fatalf("got status code %d sharing a draft with an overflowing lifetime, want %d", got, want)
+	}
+	if got, want := share(`{"route": "/mordor"}`).StatusCode, http.StatusNotFound; got != want {
+		t.Fatalf("got status code %d sharing a missing article, want %d", got, want)
+	}
+}
@@ -0,0 +1,158 @@
+package handlers_test
+
+import (
+	"sort"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/content"
+)
+
+// mockStore is an in-memory content.Store keyed by article route. Articles
+// are listed if they are visible at now.
+type mockStore struct {
+	details  content.Details
+	articles map[string]content.Article
+	now      time.Time
+}
+
+func newMockStore(articles ...content.Article) *mockStore {
+	s := &mockStore{
+		details:  content.Details{Name: "Test", Description: "A test site", Style: "default"},
+		articles: make(map[string]content.Article),
+		now:      time.Now(),
+	}
+	for _, article := range articles {
+		s.articles[article.Route] = article
+	}
+	return s
+}
+
+func (s *mockStore) GetDetails() content.Details {
+	return s.details
+}
+
+func (s *mockStore) Get(key string) (content.Article, error) {
+	article, ok := s.articles[key]
+	if !ok {
+		return content.Article{}, content.ErrArticleNotFound
+	}
+	return article, nil
+}
+
+func (s *mockStore) GetAlias(route string) (content.Article, error) {
+	for _, article := range s.articles {
+		for _, alias := range article.Aliases {
+			if alias == route {
+				return article, nil
+			}
+		}
+	}
+	return content.Article{}, content.ErrArticleNotFound
+}
+
+func (s *mockStore) GetAll(pinned bool) []content.Article {
+	list := []content.Article{}
+	for _, v := range s.articles {
+		if v.Pinned == pinned && !v.Unlisted && !v.Draft && v.Visible(s.now) {
+			list = append(list, v)
+		}
+	}
+
+	sort.Slice(list, func(i int, j int) bool {
+		return list[i].Route < list[j].Route
+	})
+
+	return list
+}
+
+func (s *mockStore) GetTagged(tag string) []content.Article {
+	list := []content.Article{}
+	for _, v := range s.articles {
+		for _, t := range v.Tags {
+			if t == tag && !v.Unlisted && !v.Draft && v.Visible(s.now) {
+				list = append(list, v)
+			}
+		}
+	}
+
+	sort.Slice(list, func(i int, j int) bool {
+		return list[i].Route < list[j].Route
+	})
+
+	return list
+}
+
+func (s *mockStore) Tags() map[string]int {
+	tags := make(map[string]int)
+	for _, v := range s.articles {
+		for _, t := range v.Tags {
+			if !v.Unlisted && !v.Draft && v.Visible(s.now) {
+				tags[t]++
+			}
+		}
+	}
+	return tags
+}
+
+func (s *mockStore) AsOf(t time.Time) content.Store {
+	view := *s
+	view.now = t
+	return &view
+}
+
+func (s *mockStore) Update(key string, doc content.Document, etag string) error {
+	article, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if etag != "" && etag != article.ETag() {
+		return content.ErrPreconditionFailed
+	}
+
+	article.Text, err = content.MarshalDocument(doc)
+	if err != nil {
+		return err
+	}
+	s.articles[key] = article
+
+	return nil
+}
+
+func (s *mockStore) Create(key string, doc content.Document) error {
+	if _, ok := s.articles[key]; ok {
+		return content.ErrArticleExists
+	}
+
+	text, err := content.MarshalDocument(doc)
+	if err != nil {
+		return err
+	}
+	s.articles[key] = content.Article{Route: key, Text: text}
+
+	return nil
+}
+
+func (s *mockStore) Delete(key string) error {
+	if _, err := s.Get(key); err != nil {
+		return err
+	}
+	delete(s.articles, key)
+
+	return nil
+}
+
+func (s *mockStore) History(key string) ([]content.Revision, error) {
+	if _, err := s.Get(key); err != nil {
+		return nil, err
+	}
+	return []content.Revision{}, nil
+}
+
+func (s *mockStore) GetRevision(key string, id string) ([]byte, error) {
+	return nil, content.ErrRevisionNotFound
+}
+
+func (s *mockStore) Restore(key string, id string) error {
+	return content.ErrRevisionNotFound
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/toddgaunt/bastion/internal/errors"
+)
+
+const tagsCtxKey = contextKey("tag")
+
+// TagName extracts the tag name from the request URL
+func TagName(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tag := chi.URLParam(r, "tag")
+		ctx := context.WithValue(r.Context(), tagsCtxKey, tag)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Tags returns an HTTP handler that responds with a list of every tag and the
+// number of articles using it.
+func (env Env) Tags(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, _ *http.Request) errors.Problem {
+		details := env.Store.GetDetails()
+		vars := templateVariables{
+			Title:       "Tags",
+			Description: fmt.Sprintf("Tags used by articles on %s", details.Name),
+			content:     env.store(r),
+		}
+
+		buf := &bytes.Buffer{}
+		tagsTemplate.Execute(buf, vars)
+
+		w.Header().Add("Content-Type", "text/html")
+		w.Write(buf.Bytes())
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// Tagged returns an HTTP handler that responds with a list of the articles
+// with a particular tag.
+func (env Env) Tagged(w http.ResponseWriter, r *http.Request) {
+	const op = "Tagged"
+
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		tag := r.Context().Value(tagsCtxKey).(string)
+
+		articles := env.store(r).GetTagged(tag)
+		if len(articles) == 0 {
+			return errors.Note{
+				Op:         op,
+				Title:      "Tag Not Found",
+				StatusCode: http.StatusNotFound,
+				Detail:     fmt.Sprintf("No articles are tagged %s", tag),
+			}.Wrap(errors.New("tag has no articles"))
+		}
+
+		vars := templateVariables{
+			Title:       tag,
+			Description: fmt.Sprintf("Articles tagged %s", tag),
+			Articles:    articles,
+			content:     env.store(r),
+		}
+
+		buf := &bytes.Buffer{}
+		taggedTemplate.Execute(buf, vars)
+
+		w.Header().Add("Content-Type", "text/html")
+		w.Write(buf.Bytes())
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
@@ -0,0 +1,89 @@
+package handlers_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+func TestTagged(t *testing.T) {
+	env := handlers.Env{
+		Store: newMockStore(
+			content.Article{Route: "/ring", Title: "The Ring", Tags: []string{"Fantasy", "Myth"}},
+			content.Article{Route: "/shire", Title: "The Shire", Tags: []string{"Fantasy"}},
+			content.Article{Route: "/secret", Title: "Secret", Tags: []string{"Myth"}, Unlisted: true},
+		),
+		Logger: log.NewNop(),
+	}
+
+	testCases := []struct {
+		name string
+		tag  string
+
+		wantStatusCode int
+		wantRoutes     []string
+		wantMissing    []string
+	}{
+		{
+			name:           "SeveralArticles",
+			tag:            "Fantasy",
+			wantStatusCode: http.StatusOK,
+			wantRoutes:     []string{"/ring", "/shire"},
+		},
+		{
+			name:           "UnlistedOmitted",
+			tag:            "Myth",
+			wantStatusCode: http.StatusOK,
+			wantRoutes:     []string{"/ring"},
+			wantMissing:    []string{"/secret"},
+		},
+		{
+			name:           "UnknownTag",
+			tag:            "Science",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.tags/"+tc.tag, nil)
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("tag", tc.tag)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+			handlers.TagName(http.HandlerFunc(env.Tagged)).ServeHTTP(w, r)
+
+			res := w.Result()
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("failed to read response body: %v", err)
+			}
+
+			for _, route := range tc.wantRoutes {
+				if !strings.Contains(string(body), `href="`+route+`"`) {
+					t.Errorf("response is missing a link to %s", route)
+				}
+			}
+			for _, route := range tc.wantMissing {
+				if strings.Contains(string(body), `href="`+route+`"`) {
+					t.Errorf("response contains a link to %s", route)
+				}
+			}
+		})
+	}
+}
@@ -4,14 +4,36 @@ import (
 	_ "embed"
 	"html/template"
 
+	"github.com/toddgaunt/bastion/internal/auth"
 	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/search"
 )
 
 type templateVariables struct {
 	Title       string
 	Description string
 	HTML        template.HTML
-	content     content.Store
+	Tags        []string
+	Articles    []content.Article
+	Query       string
+	Results     []search.Result
+	Route       string
+	Revisions   []content.Revision
+	Diff        template.HTML
+	// Protected is true for articles that users log in to, which offer to
+	// log out.
+	Protected bool
+	Next      string
+	Message   string
+	// Session is the session of the user viewing the page, if they logged
+	// in from a browser, and Sessions every session of that user.
+	Session  auth.Session
+	Sessions []auth.Session
+	// SSO is true if users can log in with single sign-on.
+	SSO bool
+	// Searchable is true if the site has a search to link to.
+	Searchable bool
+	content    content.Store
 }
 
 func (vars templateVariables) Details() content.Details {
@@ -26,6 +48,10 @@ func (vars templateVariables) Index() []content.Article {
 	return vars.content.GetAll(false)
 }
 
+func (vars templateVariables) TagCounts() map[string]int {
+	return vars.content.Tags()
+}
+
 var (
 	//go:embed templates/problems.html
 	problemTemplateString string
@@ -33,10 +59,34 @@ var (
 	indexTemplateString string
 	//go:embed templates/articles.html
 	articleTemplateString string
+	//go:embed templates/tags.html
+	tagsTemplateString string
+	//go:embed templates/tagged.html
+	taggedTemplateString string
+	//go:embed templates/search.html
+	searchTemplateString string
+	//go:embed templates/history.html
+	historyTemplateString string
+	//go:embed templates/edit.html
+	editTemplateString string
+	//go:embed templates/login.html
+	loginTemplateString string
+	//go:embed templates/signin.html
+	signinTemplateString string
+	//go:embed templates/sessions.html
+	sessionsTemplateString string
 )
 
 var (
-	indexTemplate   = template.Must(template.New("index").Parse(indexTemplateString))
-	articleTemplate = template.Must(template.New("article").Parse(articleTemplateString))
-	problemTemplate = template.Must(template.New("problem").Parse(problemTemplateString))
+	indexTemplate    = template.Must(template.New("index").Parse(indexTemplateString))
+	articleTemplate  = template.Must(template.New("article").Parse(articleTemplateString))
+	problemTemplate  = template.Must(template.New("problem").Parse(problemTemplateString))
+	tagsTemplate     = template.Must(template.New("tags").Parse(tagsTemplateString))
+	taggedTemplate   = template.Must(template.New("tagged").Parse(taggedTemplateString))
+	searchTemplate   = template.Must(template.New("search").Parse(searchTemplateString))
+	historyTemplate  = template.Must(template.New("history").Parse(historyTemplateString))
+	editTemplate     = template.Must(template.New("edit").Parse(editTemplateString))
+	loginTemplate    = template.Must(template.New("login").Parse(loginTemplateString))
+	signinTemplate   = template.Must(template.New("signin").Parse(signinTemplateString))
+	sessionsTemplate = template.Must(template.New("sessions").Parse(sessionsTemplateString))
 )
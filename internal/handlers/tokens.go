@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/errors"
+	"github.com/toddgaunt/bastion/internal/log"
+)
+
+// apiTokenDuration is the lifetime of an API token if none is requested, and
+// maxAPITokenDuration is the longest lifetime one can have.
+var (
+	apiTokenDuration    = time.Duration(time.Hour * 24 * 90)
+	maxAPITokenDuration = time.Duration(time.Hour * 24 * 365)
+)
+
+// tokenRequest is the payload of a request to create an API token.
+type tokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is the lifetime of the token in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// tokenResponse describes an API token. The token itself is only included
+// when it is created.
+type tokenResponse struct {
+	Token    string    `json:"token,omitempty"`
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Username string    `json:"username"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"last_used,omitzero"`
+}
+
+func newTokenResponse(token auth.APIToken) tokenResponse {
+	return tokenResponse{
+		ID:       token.ID,
+		Name:     token.Name,
+		Username: token.User.Username,
+		Scopes:   token.Scopes,
+		Created:  token.Created,
+		Expires:  token.Expires,
+		LastUsed: token.LastUsed,
+	}
+}
+
+var apiTokensDisabled = errors.Note{
+	StatusCode: http.StatusNotFound,
+	Detail:     "API tokens aren't enabled",
+}
+
+var notAuthorized = errors.Note{
+	StatusCode: http.StatusUnauthorized,
+	Detail:     "log in to manage API tokens",
+}
+
+// verifyAPIToken returns the claims granted by an API token, if it is valid.
+func (env Env) verifyAPIToken(token auth.BearerToken) (auth.Claims, errors.Problem) {
+	if env.Tokens == nil || env.Auth == nil {
+		return auth.Claims{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "API tokens aren't accepted"}.Wrap(errors.New("no token store"))
+	}
+
+	claims, err := env.Tokens.Verify(token, env.Auth, env.Clock.Now())
+	switch {
+	case errors.Is(err, auth.ErrAPITokenExpired):
+		return auth.Claims{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "expired token"}.Wrap(err)
+	case errors.Is(err, auth.ErrAPITokenNotFound), errors.Is(err, auth.ErrUserNotFound):
+		return auth.Claims{}, errors.Note{StatusCode: http.StatusUnauthorized, Detail: "couldn't verify token"}.Wrap(err)
+	case err != nil:
+		return auth.Claims{}, statusInternal.Wrap(err)
+	}
+
+	return claims, nil
+}
+
+// CreateToken returns an HTTP handler that creates an API token for the
+// authorized user, with the name, scopes and lifetime in seconds given by the
+// request. A token can't have a scope that requires a role the user doesn't
+// have.
+func (env Env) CreateToken(w http.ResponseWriter, r *http.Request) {
+	const op = "CreateToken"
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if env.Tokens == nil {
+			return apiTokensDisabled.Wrap(errors.New("no token store"))
+		}
+
+		claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+		if !ok {
+			return notAuthorized.Wrap(errors.New("no claims"))
+		}
+
+		// The users of a single sign-on provider aren't known once they
+		// have logged in, so a token would grant nothing.
+		if auth.IsOIDCUser(claims.Username) {
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusForbidden,
+				Detail:     "users of single sign-on can't create API tokens",
+			}.Wrap(errors.Errorf("%q tried to create a token", claims.Username))
+		}
+
+		// A token could otherwise keep replacing itself, and never expire.
+		if !hasSessionCookie(r) && auth.IsAPIToken(string(bearerToken(r))) {
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusForbidden,
+				Detail:     "API tokens can't create API tokens",
+			}.Wrap(errors.Errorf("%q tried to create a token with an API token", claims.Username))
+		}
+
+		var req tokenRequest
+
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil {
+			return errors.Note{
+				StatusCode: http.StatusBadRequest,
+				Detail:     "expected a name and scopes",
+			}.Wrap(err)
+		}
+
+		// The lifetime is checked in seconds, so that it can't overflow.
+		if req.ExpiresIn < 0 || req.ExpiresIn > int64(maxAPITokenDuration/time.Second) {
+			return errors.Note{
+				Op:         op,
+				StatusCode: http.StatusBadRequest,
+				Detail:     "a token must expire within 365 days",
+			}.Wrap(errors.Errorf("invalid lifetime of %d seconds", req.ExpiresIn))
+		}
+
+		lifetime := apiTokenDuration
+		if req.ExpiresIn != 0 {
+			lifetime = time.Duration(req.ExpiresIn) * time.Second
+		}
+
+		token, record, err := env.Tokens.Create(req.Name, claims, req.Scopes, env.Clock.Now(), lifetime)
+		switch {
+		case errors.Is(err, auth.ErrInvalidAPIToken):
+			return errors.Note{Op: op, StatusCode: http.StatusBadRequest, Detail: err.Error()}.Wrap(err)
+		case errors.Is(err, auth.ErrScopeNotGranted):
+			return errors.Note{Op: op, StatusCode: http.StatusForbidden, Detail: err.Error()}.Wrap(err)
+		case err != nil:
+			return statusInternal.Wrap(err)
+		}
+
+		env.Logger.With("username", claims.Username, "id", record.ID, "scopes", record.Scopes).Print(log.Info, "Created API token")
+
+		resp := newTokenResponse(record)
+		resp.Token = string(token)
+
+		return writeJSON(w, resp)
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// ListTokens returns an HTTP handler that lists the API tokens of the
+// authorized user. Administrators are shown the tokens of every user.
+func (env Env) ListTokens(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if env.Tokens == nil {
+			return apiTokensDisabled.Wrap(errors.New("no token store"))
+		}
+
+		claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+		if !ok {
+			return notAuthorized.Wrap(errors.New("no claims"))
+		}
+
+		tokens, err := env.Tokens.List(tokenOwner(claims))
+		if err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		resp := make([]tokenResponse, len(tokens))
+		for i, token := range tokens {
+			resp[i] = newTokenResponse(token)
+		}
+
+		return writeJSON(w, resp)
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// RevokeToken returns an HTTP handler that revokes the API token with the ID
+// given by the id route parameter. Users can only revoke their own tokens,
+// except for administrators.
+func (env Env) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) errors.Problem {
+		if env.Tokens == nil {
+			return apiTokensDisabled.Wrap(errors.New("no token store"))
+		}
+
+		claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+		if !ok {
+			return notAuthorized.Wrap(errors.New("no claims"))
+		}
+
+		id := chi.URLParam(r, "id")
+
+		tokens, err := env.Tokens.List(tokenOwner(claims))
+		if err != nil {
+			return statusInternal.Wrap(err)
+		}
+
+		found := false
+		for _, token := range tokens {
+			found = found || token.ID == id
+		}
+		if found {
+			err = env.Tokens.Revoke(id)
+		} else {
+			err = auth.ErrAPITokenNotFound
+		}
+
+		switch {
+		case errors.Is(err, auth.ErrAPITokenNotFound):
+			return errors.Note{
+				StatusCode: http.StatusNotFound,
+				Detail:     "no API token with that ID",
+			}.Wrap(err)
+		case err != nil:
+			return statusInternal.Wrap(err)
+		}
+
+		env.Logger.With("id", id, "by", claims.Username).Print(log.Info, "Revoked API token")
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return nil
+	}
+
+	err := fn(w, r)
+	handleError(w, err, env.Logger)
+}
+
+// tokenOwner returns the username whose API tokens a user may manage, which
+// is empty for administrators, who may manage every token.
+func tokenOwner(claims auth.Claims) string {
+	if claims.HasRole(auth.RoleAdmin) {
+		return ""
+	}
+	return claims.Username
+}
@@ -0,0 +1,291 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/handlers"
+	"github.com/toddgaunt/bastion/internal/log"
+	"github.com/toddgaunt/bastion/internal/tests"
+)
+
+// newUserFile returns a user file of users with the claims, whose passwords
+// are all potatoes.
+func newUserFile(t *testing.T, claims ...auth.Claims) *auth.UserFile {
+	t.Helper()
+
+	users := []auth.User{}
+	for _, c := range claims {
+		user := auth.User{Username: c.Username, Name: c.Name, Roles: c.Roles}
+		if err := user.SetPassword("potatoes"); err != nil {
+			t.Fatalf("failed to hash password: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	data, err := auth.MarshalUsers(users)
+	if err != nil {
+		t.Fatalf("failed to encode users: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write users: %v", err)
+	}
+
+	return &auth.UserFile{Path: path}
+}
+
+func TestCreateToken(t *testing.T) {
+	now := time.Now()
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	editor := auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor}}
+
+	env := handlers.Env{
+		Auth:    newUserFile(t, editor),
+		SignKey: key,
+		Tokens:  auth.NewMemoryTokenStore(),
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+	}
+
+	testCases := []struct {
+		name string
+		body string
+
+		wantStatusCode int
+		// wantEditor is true if the token created can be used to edit.
+		wantEditor bool
+	}{
+		{
+			name:           "WriteContent",
+			body:           `{"name":"ci","scopes":["write-content"]}`,
+			wantStatusCode: http.StatusOK,
+			wantEditor:     true,
+		},
+		{
+			name:           "ReadProtected",
+			body:           `{"name":"ci","scopes":["read-protected"],"expires_in":60}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "NotGranted",
+			body:           `{"name":"ci","scopes":["admin"]}`,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "UnknownScope",
+			body:           `{"name":"ci","scopes":["everything"]}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "NoName",
+			body:           `{"scopes":["write-content"]}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "TooLong",
+			body:           `{"name":"ci","scopes":["write-content"],"expires_in":31536001}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Overflow",
+			body:           `{"name":"ci","scopes":["write-content"],"expires_in":9223372036854775807}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Negative",
+			body:           `{"name":"ci","scopes":["write-content"],"expires_in":-60}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/tokens", strings.NewReader(tc.body))
+			r.Header.Add("Authorization", mustSign(t, key, editor, now, time.Minute))
+
+			env.Authorize(http.HandlerFunc(env.CreateToken)).ServeHTTP(w, r)
+
+			res := w.Result()
+			if got, want := res.StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+			if res.StatusCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Token    string `json:"token"`
+				Username string `json:"username"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+				t.Fatalf("couldn't unmarshal JSON response: %v", err)
+			}
+			if got, want := response.Username, editor.Username; got != want {
+				t.Fatalf("got username %q, want %q", got, want)
+			}
+
+			// The token is accepted in place of an access token, but only
+			// grants its scopes.
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodPost, "http://www.test.com/hello", nil)
+			r.Header.Set("Authorization", "Bearer "+response.Token)
+
+			env.Authorize(env.RequireRole(auth.RoleEditor)(noopHandler)).ServeHTTP(w, r)
+
+			wantStatusCode := http.StatusForbidden
+			if tc.wantEditor {
+				wantStatusCode = http.StatusOK
+			}
+			if got, want := w.Result().StatusCode, wantStatusCode; got != want {
+				t.Fatalf("got status code %d using the token, want %d", got, want)
+			}
+
+			// The token can't create another token to outlive itself.
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/tokens", strings.NewReader(tc.body))
+			r.Header.Set("Authorization", "Bearer "+response.Token)
+
+			env.Authorize(http.HandlerFunc(env.CreateToken)).ServeHTTP(w, r)
+
+			if got, want := w.Result().StatusCode, http.StatusForbidden; got != want {
+				t.Fatalf("got status code %d creating a token with a token, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateTokenOIDC(t *testing.T) {
+	now := time.Now()
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	// A local user of the same name doesn't lend their roles to the user of
+	// the provider.
+	samwise := auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor}}
+	env := handlers.Env{
+		Auth:    newUserFile(t, samwise),
+		SignKey: key,
+		Tokens:  auth.NewMemoryTokenStore(),
+		Logger:  log.NewNop(),
+		Clock:   tests.MockClock(now),
+	}
+
+	claims := auth.Claims{Username: auth.OIDCUserPrefix + "samwise", Roles: []string{auth.RoleEditor}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "http://www.test.com/.auth/tokens", strings.NewReader(`{"name":"ci","scopes":["write-content"]}`))
+	r.Header.Add("Authorization", mustSign(t, key, claims, now, time.Minute))
+
+	env.Authorize(http.HandlerFunc(env.CreateToken)).ServeHTTP(w, r)
+
+	if got, want := w.Result().StatusCode, http.StatusForbidden; got != want {
+		t.Fatalf("got status code %d, want %d", got, want)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	now := time.Now()
+
+	key, err := auth.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("failed to initialize signing key: %v", err)
+	}
+
+	samwise := auth.Claims{Username: "samwise", Roles: []string{auth.RoleEditor}}
+	pippin := auth.Claims{Username: "pippin", Roles: []string{auth.RoleEditor}}
+	gandalf := auth.Claims{Username: "gandalf", Roles: []string{auth.RoleAdmin}, Admin: true}
+	users := newUserFile(t, samwise, pippin, gandalf)
+
+	testCases := []struct {
+		name   string
+		claims auth.Claims
+
+		wantListed     int
+		wantStatusCode int
+	}{
+		{name: "Owner", claims: samwise, wantListed: 1, wantStatusCode: http.StatusNoContent},
+		{name: "OtherUser", claims: pippin, wantListed: 0, wantStatusCode: http.StatusNotFound},
+		{name: "Admin", claims: gandalf, wantListed: 1, wantStatusCode: http.StatusNoContent},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tokens := auth.NewMemoryTokenStore()
+			env := handlers.Env{
+				Auth:    users,
+				SignKey: key,
+				Tokens:  tokens,
+				Logger:  log.NewNop(),
+				Clock:   tests.MockClock(now),
+			}
+
+			token, record, err := tokens.Create("ci", samwise, []string{auth.ScopeWriteContent}, now, time.Hour)
+			if err != nil {
+				t.Fatalf("failed to create token: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://www.test.com/.auth/tokens", nil)
+			r.Header.Add("Authorization", mustSign(t, key, tc.claims, now, time.Minute))
+
+			env.Authorize(http.HandlerFunc(env.ListTokens)).ServeHTTP(w, r)
+
+			var listed []json.RawMessage
+			if err := json.NewDecoder(w.Result().Body).Decode(&listed); err != nil {
+				t.Fatalf("couldn't unmarshal JSON response: %v", err)
+			}
+			if got, want := len(listed), tc.wantListed; got != want {
+				t.Fatalf("got %d tokens listed, want %d", got, want)
+			}
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodDelete, "http://www.test.com/.auth/tokens/"+record.ID, nil)
+			r.Header.Add("Authorization", mustSign(t, key, tc.claims, now, time.Minute))
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", record.ID)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+			env.Authorize(http.HandlerFunc(env.RevokeToken)).ServeHTTP(w, r)
+
+			if got, want := w.Result().StatusCode, tc.wantStatusCode; got != want {
+				t.Fatalf("got status code %d, want %d", got, want)
+			}
+
+			// Revoked tokens are no longer accepted.
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, "http://www.test.com", nil)
+			r.Header.Set("Authorization", "Bearer "+string(token))
+
+			env.Authorize(noopHandler).ServeHTTP(w, r)
+
+			wantStatusCode := http.StatusOK
+			if tc.wantStatusCode == http.StatusNoContent {
+				wantStatusCode = http.StatusUnauthorized
+			}
+			if got, want := w.Result().StatusCode, wantStatusCode; got != want {
+				t.Fatalf("got status code %d using the token, want %d", got, want)
+			}
+		})
+	}
+}
@@ -30,7 +30,7 @@ func (r *recorder) With(keyValues ...any) Logger {
 		panic("With requires an even number of args")
 	}
 
-	n := &recorder{}
+	n := &recorder{keyValues: make(map[any]any), entries: r.entries}
 	for k, v := range r.keyValues {
 		n.keyValues[k] = v
 	}
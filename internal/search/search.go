@@ -0,0 +1,328 @@
+// Package search provides an in-memory full-text index of articles ranked
+// with Okapi BM25.
+package search
+
+import (
+	"html"
+	"html/template"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/toddgaunt/bastion/internal/content"
+)
+
+// BM25 tuning parameters.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Terms found in these fields are weighted more heavily than terms found in
+// the body of an article.
+const (
+	titleWeight       = 3
+	descriptionWeight = 2
+	bodyWeight        = 1
+)
+
+// snippetRadius is roughly how many bytes of context surround a match in a
+// snippet.
+const snippetRadius = 80
+
+var (
+	scriptPattern = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)>`)
+	tagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+	spacePattern  = regexp.MustCompile(`\s+`)
+)
+
+// Result is a single article matching a query.
+type Result struct {
+	Route       string        `json:"route"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Score       float64       `json:"score"`
+	Snippet     template.HTML `json:"snippet"`
+}
+
+type document struct {
+	route       string
+	title       string
+	description string
+	text        string
+	publish     time.Time
+	expires     time.Time
+	length      int
+	terms       map[string]int
+}
+
+// Index is an inverted index of articles. It is safe for concurrent use.
+type Index struct {
+	mutex       sync.RWMutex
+	documents   map[string]*document
+	postings    map[string]map[string]int
+	totalLength int
+}
+
+// New creates an empty index.
+func New() *Index {
+	return &Index{
+		documents: make(map[string]*document),
+		postings:  make(map[string]map[string]int),
+	}
+}
+
+// Searchable returns true if an article may appear in search results.
+func Searchable(article content.Article) bool {
+	return article.Err == nil && !article.Unlisted && !article.Draft && !article.Protected()
+}
+
+// Add indexes an article under its path, replacing any article previously
+// indexed under the same path. Articles that are not searchable are removed
+// from the index instead.
+func (idx *Index) Add(article content.Article) {
+	if !Searchable(article) {
+		idx.Remove(article.Path)
+		return
+	}
+
+	doc := &document{
+		route:       article.Route,
+		title:       article.Title,
+		description: article.Description,
+		text:        plainText(string(article.HTML)),
+		publish:     article.Publish,
+		expires:     article.Expires,
+		terms:       make(map[string]int),
+	}
+
+	for _, field := range []struct {
+		text   string
+		weight int
+	}{
+		{doc.title, titleWeight},
+		{doc.description, descriptionWeight},
+		{doc.text, bodyWeight},
+	} {
+		for _, tok := range tokenize(field.text) {
+			doc.terms[tok.term] += field.weight
+			doc.length += field.weight
+		}
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.remove(article.Path)
+
+	idx.documents[article.Path] = doc
+	idx.totalLength += doc.length
+	for term, freq := range doc.terms {
+		posting, ok := idx.postings[term]
+		if !ok {
+			posting = make(map[string]int)
+			idx.postings[term] = posting
+		}
+		posting[article.Path] = freq
+	}
+}
+
+// Remove deletes the article indexed under path, if there is one.
+func (idx *Index) Remove(path string) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.remove(path)
+}
+
+func (idx *Index) remove(path string) {
+	doc, ok := idx.documents[path]
+	if !ok {
+		return
+	}
+
+	for term := range doc.terms {
+		posting := idx.postings[term]
+		delete(posting, path)
+		if len(posting) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+
+	idx.totalLength -= doc.length
+	delete(idx.documents, path)
+}
+
+// Search returns at most limit articles matching the query that are visible
+// at a time, ordered from most to least relevant.
+func (idx *Index) Search(query string, limit int, now time.Time) []Result {
+	terms := map[string]bool{}
+	for _, tok := range tokenize(query) {
+		terms[tok.term] = true
+	}
+
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	n := float64(len(idx.documents))
+	if n == 0 || len(terms) == 0 {
+		return nil
+	}
+	avgLength := float64(idx.totalLength) / n
+
+	scores := make(map[string]float64)
+	for term := range terms {
+		posting := idx.postings[term]
+		df := float64(len(posting))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for path, freq := range posting {
+			tf := float64(freq)
+			length := float64(idx.documents[path].length)
+			scores[path] += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*length/avgLength))
+		}
+	}
+
+	var results []Result
+	for path, score := range scores {
+		doc := idx.documents[path]
+		if !(content.Article{Publish: doc.publish, Expires: doc.expires}).Visible(now) {
+			continue
+		}
+		results = append(results, Result{
+			Route:       doc.route,
+			Title:       doc.title,
+			Description: doc.description,
+			Score:       score,
+			Snippet:     snippet(doc.text, terms),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Route < results[j].Route
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// token is a normalized term and the byte offsets it was found at.
+type token struct {
+	term       string
+	start, end int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize splits text into lowercase words.
+func tokenize(text string) []token {
+	var tokens []token
+
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+
+	return tokens
+}
+
+// plainText strips markup from HTML and collapses whitespace.
+func plainText(s string) string {
+	s = scriptPattern.ReplaceAllString(s, " ")
+	s = tagPattern.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	return strings.TrimSpace(spacePattern.ReplaceAllString(s, " "))
+}
+
+// snippet returns an excerpt of text around the first term found, with every
+// term in the excerpt wrapped in a <mark> element.
+func snippet(text string, terms map[string]bool) template.HTML {
+	tokens := tokenize(text)
+
+	first := -1
+	for i, tok := range tokens {
+		if terms[tok.term] {
+			first = i
+			break
+		}
+	}
+
+	// The excerpt is centered on the first match, or is the start of the text
+	// if nothing matched.
+	lo, hi := 0, 0
+	if first >= 0 {
+		lo, hi = tokens[first].start, tokens[first].end
+	}
+	start, end := lo-snippetRadius, hi+snippetRadius
+	if first < 0 {
+		start, end = 0, 2*snippetRadius
+	}
+
+	// Snap the excerpt to word boundaries.
+	if start <= 0 {
+		start = 0
+	} else if i := strings.IndexByte(text[start:lo], ' '); i >= 0 {
+		start += i + 1
+	} else {
+		for !utf8.RuneStart(text[start]) {
+			start++
+		}
+	}
+	if end >= len(text) {
+		end = len(text)
+	} else if i := strings.LastIndexByte(text[hi:end], ' '); i >= 0 {
+		end = hi + i
+	} else {
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+	}
+
+	buf := &strings.Builder{}
+	if start > 0 {
+		buf.WriteString("… ")
+	}
+
+	last := start
+	for _, tok := range tokens {
+		if tok.start < start || tok.end > end || !terms[tok.term] {
+			continue
+		}
+		buf.WriteString(html.EscapeString(text[last:tok.start]))
+		buf.WriteString("<mark>")
+		buf.WriteString(html.EscapeString(text[tok.start:tok.end]))
+		buf.WriteString("</mark>")
+		last = tok.end
+	}
+	buf.WriteString(html.EscapeString(text[last:end]))
+
+	if end < len(text) {
+		buf.WriteString(" …")
+	}
+
+	return template.HTML(buf.String())
+}
@@ -0,0 +1,153 @@
+package search_test
+
+import (
+	"html/template"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/toddgaunt/bastion/internal/auth"
+	"github.com/toddgaunt/bastion/internal/content"
+	"github.com/toddgaunt/bastion/internal/search"
+)
+
+func routes(results []search.Result) []string {
+	var list []string
+	for _, result := range results {
+		list = append(list, result.Route)
+	}
+	return list
+}
+
+func TestSearch(t *testing.T) {
+	authenticator, err := auth.NewSimple("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to initialize simple auth: %v", err)
+	}
+
+	index := search.New()
+	index.Add(content.Article{
+		Path:  "/ring.md",
+		Route: "/ring",
+		Title: "The One Ring",
+		HTML:  "<p>One ring to rule them all, one ring to find them.</p>",
+	})
+	index.Add(content.Article{
+		Path:        "/shire.md",
+		Route:       "/shire",
+		Title:       "The Shire",
+		Description: "Home of the hobbits",
+		HTML:        "<p>Frodo keeps the ring in a drawer at Bag End.</p>",
+	})
+	index.Add(content.Article{
+		Path:     "/unlisted.md",
+		Route:    "/unlisted",
+		Title:    "Ring",
+		HTML:     "<p>ring</p>",
+		Unlisted: true,
+	})
+	index.Add(content.Article{
+		Path:          "/protected.md",
+		Route:         "/protected",
+		Title:         "Ring",
+		HTML:          "<p>ring</p>",
+		Authenticator: authenticator,
+	})
+
+	testCases := []struct {
+		name  string
+		query string
+
+		want []string
+	}{
+		{
+			name:  "RankedByRelevance",
+			query: "ring",
+			want:  []string{"/ring", "/shire"},
+		},
+		{
+			name:  "CaseInsensitive",
+			query: "HOBBITS",
+			want:  []string{"/shire"},
+		},
+		{
+			name:  "SeveralTerms",
+			query: "frodo drawer",
+			want:  []string{"/shire"},
+		},
+		{
+			name:  "NoMatches",
+			query: "mordor",
+			want:  nil,
+		},
+		{
+			name:  "EmptyQuery",
+			query: "  ",
+			want:  nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got := routes(index.Search(tc.query, 10, time.Now())); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchIncremental(t *testing.T) {
+	index := search.New()
+	article := content.Article{Path: "/ring.md", Route: "/ring", Title: "Ring", HTML: "<p>precious</p>"}
+	index.Add(article)
+
+	article.HTML = "<p>mithril</p>"
+	index.Add(article)
+
+	if got := index.Search("precious", 10, time.Now()); got != nil {
+		t.Errorf("found replaced text: %v", routes(got))
+	}
+	if got, want := routes(index.Search("mithril", 10, time.Now())), []string{"/ring"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	index.Remove(article.Path)
+	if got := index.Search("mithril", 10, time.Now()); got != nil {
+		t.Errorf("found removed article: %v", routes(got))
+	}
+}
+
+func TestSearchScheduled(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	index := search.New()
+	index.Add(content.Article{Path: "/ring.md", Route: "/ring", HTML: "<p>precious</p>", Publish: now})
+	index.Add(content.Article{Path: "/shire.md", Route: "/shire", HTML: "<p>precious</p>", Expires: now})
+
+	if got, want := routes(index.Search("precious", 10, now.Add(-time.Second))), []string{"/shire"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v before publishing, want %v", got, want)
+	}
+	if got, want := routes(index.Search("precious", 10, now)), []string{"/ring"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v once published, want %v", got, want)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	index := search.New()
+	index.Add(content.Article{
+		Path:  "/ring.md",
+		Route: "/ring",
+		HTML:  `<p>Ash nazg durbatulûk, ash nazg gimbatul, <b>ring</b> &amp; "fire"</p><script>ring()</script>`,
+	})
+
+	results := index.Search("ring", 10, time.Now())
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+
+	want := template.HTML(`Ash nazg durbatulûk, ash nazg gimbatul, <mark>ring</mark> &amp; &#34;fire&#34;`)
+	if got := results[0].Snippet; got != want {
+		t.Fatalf("got snippet %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+	"github.com/toddgaunt/bastion/internal/auth"
+)
+
+// MockOIDCProvider is an OpenID Connect provider for testing, which logs in
+// every user sent to it. It signs ID tokens with an RSA key for RS256, or a
+// P-256 key for ES256.
+type MockOIDCProvider struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+	// Algorithm signs ID tokens, and is RS256 by default.
+	Algorithm string
+	// Claims are added to every ID token, replacing the claims it would
+	// otherwise have.
+	Claims map[string]any
+	// Now is the time ID tokens are issued at, which is the current time by
+	// default.
+	Now time.Time
+
+	rsaKey *rsa.PrivateKey
+	ecKey  auth.ECKey
+
+	mutex sync.Mutex
+	codes map[string]mockOIDCCode
+}
+
+// mockOIDCCode is a code issued to the client, along with the login it was
+// issued for.
+type mockOIDCCode struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+// NewMockOIDCProvider starts a provider for a client. It must be closed once
+// the test is done.
+func NewMockOIDCProvider(clientID, clientSecret string) (*MockOIDCProvider, error) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, err := auth.GenerateECKey()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MockOIDCProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Algorithm:    auth.RS256,
+		Claims:       map[string]any{},
+		rsaKey:       rsaKey,
+		ecKey:        ecKey,
+		codes:        make(map[string]mockOIDCCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/keys", p.keys)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+
+	return p, nil
+}
+
+func (p *MockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/keys",
+	})
+}
+
+func (p *MockOIDCProvider) keys(w http.ResponseWriter, r *http.Request) {
+	encode := func(n *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(n.Bytes())
+	}
+
+	json.NewEncoder(w).Encode(auth.JWKSet{Keys: []auth.JWK{
+		{
+			KeyType:   "RSA",
+			N:         encode(p.rsaKey.N),
+			E:         encode(big.NewInt(int64(p.rsaKey.E))),
+			ID:        "rsa",
+			Algorithm: auth.RS256,
+			Use:       "sig",
+		},
+		p.ecKey.JWK("ec"),
+	}})
+}
+
+// Authorize logs in the user of a URL returned by auth.OIDC.AuthCodeURL, and
+// returns the URL the user is sent back to with a code.
+func (p *MockOIDCProvider) Authorize(authURL string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+
+	code := rand.Text()
+
+	p.mutex.Lock()
+	p.codes[code] = mockOIDCCode{
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	p.mutex.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return "", err
+	}
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+
+	return redirect.String(), nil
+}
+
+func (p *MockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(e string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": e})
+	}
+
+	if id, secret, ok := r.BasicAuth(); !ok || id != p.ClientID || secret != p.ClientSecret {
+		fail("invalid_client")
+		return
+	}
+
+	p.mutex.Lock()
+	code, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	switch {
+	case !ok, r.PostFormValue("grant_type") != "authorization_code":
+		fail("invalid_grant")
+		return
+	case base64.RawURLEncoding.EncodeToString(challenge[:]) != code.challenge:
+		fail("invalid_grant")
+		return
+	case r.PostFormValue("redirect_uri") != code.redirectURI:
+		fail("invalid_grant")
+		return
+	}
+
+	now := p.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	claims := map[string]any{
+		"iss":   p.URL,
+		"sub":   "1234",
+		"aud":   p.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": code.nonce,
+	}
+	for k, v := range p.Claims {
+		claims[k] = v
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		fail("server_error")
+		return
+	}
+
+	var token string
+	if p.Algorithm == auth.ES256 {
+		token, err = jose.SignBytes(payload, jose.ES256, p.ecKey.PrivateKey, jose.Header("kid", "ec"))
+	} else {
+		token, err = jose.SignBytes(payload, jose.RS256, p.rsaKey, jose.Header("kid", "rsa"))
+	}
+	if err != nil {
+		fail("server_error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"id_token":     token,
+	})
+}